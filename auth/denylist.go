@@ -0,0 +1,112 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"sync"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/xttlmap"
+)
+
+// DenylistLookup checks the token id or the user id against the shared denylist,
+// e.g. in the database. The key is either "jti:<id>" or "user:<user_id>".
+type DenylistLookup func(key string) (revoked bool, err error)
+
+// Denylist revokes the tokens by jti or user_id. The lookup results are cached
+// for the cacheTTL, the local revocations are kept until the given time.
+type Denylist struct {
+	lookup   DenylistLookup
+	cacheTTL time.Duration
+	cache    *xttlmap.TTLMap[string, bool]
+
+	// The local revocations are not cached with the lookups
+	// to not be evicted when the cache is full
+	lock    sync.RWMutex
+	revoked map[string]time.Time
+}
+
+// NewDenylist creates the denylist, the lookup may be nil to use the local revocations only.
+func NewDenylist(lookup DenylistLookup, cacheSize int, cacheTTL time.Duration) *Denylist {
+	return &Denylist{
+		lookup:   lookup,
+		cacheTTL: cacheTTL,
+		cache:    xttlmap.New[string, bool](cacheSize),
+		revoked:  make(map[string]time.Time),
+	}
+}
+
+// RevokeToken revokes the token until it expires.
+func (d *Denylist) RevokeToken(jti string, until time.Time) {
+	d.revoke(tokenKey(jti), until)
+}
+
+// RevokeUser revokes all the tokens of the user until the given time,
+// it should exceed the token lifetime.
+func (d *Denylist) RevokeUser(userID string, until time.Time) {
+	d.revoke(userKey(userID), until)
+}
+
+func (d *Denylist) Revoked(claims *ClientClaims) (bool, error) {
+	var keys []string
+	if len(claims.Id) > 0 {
+		keys = append(keys, tokenKey(claims.Id))
+	}
+	if len(claims.UserId) > 0 {
+		keys = append(keys, userKey(claims.UserId))
+	}
+
+	for _, key := range keys {
+		revoked, err := d.check(key)
+		if err != nil || revoked {
+			return revoked, err
+		}
+	}
+	return false, nil
+}
+
+func (d *Denylist) revoke(key string, until time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	now := time.Now()
+	for k, deadline := range d.revoked {
+		if now.After(deadline) {
+			delete(d.revoked, k)
+		}
+	}
+	d.revoked[key] = until
+}
+
+func (d *Denylist) check(key string) (bool, error) {
+	d.lock.RLock()
+	deadline, ok := d.revoked[key]
+	d.lock.RUnlock()
+	if ok && time.Now().Before(deadline) {
+		return true, nil
+	}
+
+	if d.lookup == nil {
+		return false, nil
+	}
+	if revoked, ok := d.cache.Get(key); ok {
+		return revoked, nil
+	}
+
+	revoked, err := d.lookup(key)
+	if err != nil {
+		return false, err
+	}
+	d.cache.Set(key, revoked, time.Now().Add(d.cacheTTL))
+	return revoked, nil
+}
+
+func tokenKey(jti string) string {
+	return "jti:" + jti
+}
+
+func userKey(userID string) string {
+	return "user:" + userID
+}
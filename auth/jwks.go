@@ -0,0 +1,201 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+	"go.uber.org/zap"
+)
+
+// JWK is the public key in the RFC 7517 format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg,omitempty"`
+	Use string `json:"use,omitempty"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// EC and OKP
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// KeySource lists the keys to publish, e.g. xcrypto.RSAKeeper.ListKeys.
+type KeySource func() ([]xcrypto.KeyInfo, error)
+
+const jwksMaxAge = 300 // seconds
+
+var errUnsupportedJWK = errors.New("unsupported jwk")
+
+func NewJWK(keyID uuid.UUID, key crypto.PublicKey) (JWK, error) {
+	alg, err := KeyAlgorithm(key)
+	if err != nil {
+		return JWK{}, err
+	}
+
+	jwk := JWK{
+		Kid: keyID.String(),
+		Alg: alg,
+		Use: "sig",
+	}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = encodeJWKBytes(k.N.Bytes())
+		jwk.E = encodeJWKBytes(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		jwk.Kty = "EC"
+		jwk.Crv = "P-256"
+		jwk.X = encodeJWKBytes(k.X.FillBytes(make([]byte, 32)))
+		jwk.Y = encodeJWKBytes(k.Y.FillBytes(make([]byte, 32)))
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = encodeJWKBytes(k)
+	}
+	return jwk, nil
+}
+
+// KeyID returns the kid as UUID, see JWTMaster.
+func (k *JWK) KeyID() (uuid.UUID, error) {
+	return uuid.Parse(k.Kid)
+}
+
+// PublicKey decodes the key, the alg is checked to match the key type if set.
+func (k *JWK) PublicKey() (crypto.PublicKey, error) {
+	var key crypto.PublicKey
+	switch {
+	case k.Kty == "RSA":
+		n, err := decodeJWKInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("%w: invalid rsa exponent", errUnsupportedJWK)
+		}
+		key = &rsa.PublicKey{N: n, E: int(e.Int64())}
+	case k.Kty == "EC" && k.Crv == "P-256":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("%w: invalid ec point", errUnsupportedJWK)
+		}
+		// ecdh checks the point is on the curve
+		if _, err := ecdh.P256().NewPublicKey(append(append([]byte{4}, x...), y...)); err != nil {
+			return nil, fmt.Errorf("%w: %w", errUnsupportedJWK, err)
+		}
+		key = &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+	case k.Kty == "OKP" && k.Crv == "Ed25519":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%w: invalid ed25519 key", errUnsupportedJWK)
+		}
+		key = ed25519.PublicKey(x)
+	default:
+		return nil, fmt.Errorf("%w: kty %s, crv %s", errUnsupportedJWK, k.Kty, k.Crv)
+	}
+
+	if len(k.Alg) > 0 {
+		alg, err := KeyAlgorithm(key)
+		if err != nil {
+			return nil, err
+		}
+		if alg != k.Alg {
+			return nil, fmt.Errorf("%w: alg %s does not match the key type", errUnsupportedJWK, k.Alg)
+		}
+	}
+	return key, nil
+}
+
+// MasterKeys publishes the public keys of the masters.
+func MasterKeys(masters ...*JWTMaster) KeySource {
+	return func() ([]xcrypto.KeyInfo, error) {
+		keys := make([]xcrypto.KeyInfo, 0, len(masters))
+		for _, m := range masters {
+			keys = append(keys, m.KeyInfo())
+		}
+		return keys, nil
+	}
+}
+
+// NewJWKSHandler serves the keys from all the sources as the JWKS document.
+func NewJWKSHandler(sources ...KeySource) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jwks := JWKS{Keys: []JWK{}}
+		for _, source := range sources {
+			keys, err := source()
+			if err != nil {
+				zap.L().Error("Failed to list the keys for jwks", zap.Error(err))
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			for _, key := range keys {
+				jwk, err := NewJWK(key.Id, key.Key)
+				if err != nil {
+					zap.L().Warn("Skipping the key not supported by jwks", zap.Stringer("kid", key.Id), zap.Error(err))
+					continue
+				}
+				jwks.Keys = append(jwks.Keys, jwk)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", jwksMaxAge))
+		_ = json.NewEncoder(w).Encode(jwks)
+	})
+}
+
+func encodeJWKBytes(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, fmt.Errorf("%w: empty integer", errUnsupportedJWK)
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrKeysStale   = errors.New("keys are stale")
+)
+
+const maxJWKSSize = 1 << 20
+
+type RemoteKeyStoreConfig struct {
+	URL string `yaml:"url"`
+	// RefreshInterval is the period of the background refresh, 10m by default.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// MinRefetchInterval limits the refetch on the unknown key id, 30s by default.
+	MinRefetchInterval time.Duration `yaml:"min_refetch_interval"`
+	// MaxStale is how long the keys are used after the refresh failures, zero means forever.
+	MaxStale time.Duration `yaml:"max_stale"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// RemoteKeyStore is the KeyStore backed by the remote JWKS, see NewJWKSHandler.
+// The keys are refreshed in the background and refetched on the unknown key id
+// at most once per MinRefetchInterval. The last fetched keys are kept on the failures.
+type RemoteKeyStore struct {
+	config RemoteKeyStoreConfig
+	client *http.Client
+
+	fetchLock sync.Mutex // serializes the fetches
+	lastFetch time.Time  // guarded by fetchLock
+
+	lock      sync.RWMutex
+	keys      map[uuid.UUID]crypto.PublicKey
+	fetchedAt time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewRemoteKeyStore fetches the keys and starts the background refresh.
+// The store is created even if the initial fetch fails, the error is returned along.
+func NewRemoteKeyStore(config RemoteKeyStoreConfig, client *http.Client) (*RemoteKeyStore, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("jwks url is not set")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 10 * time.Minute
+	}
+	if config.MinRefetchInterval <= 0 {
+		config.MinRefetchInterval = 30 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &RemoteKeyStore{
+		config: config,
+		client: client,
+		keys:   make(map[uuid.UUID]crypto.PublicKey),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	s.fetchLock.Lock()
+	err := s.fetchLocked(ctx)
+	s.fetchLock.Unlock()
+
+	go s.run(ctx)
+	return s, err
+}
+
+func (s *RemoteKeyStore) GetKey(keyUUID uuid.UUID) (crypto.PublicKey, error) {
+	key, err := s.lookup(keyUUID)
+	if !errors.Is(err, ErrKeyNotFound) {
+		return key, err
+	}
+
+	// The key may be just rotated on the remote side
+	s.fetchLock.Lock()
+	if time.Since(s.lastFetch) >= s.config.MinRefetchInterval {
+		if err := s.fetchLocked(context.Background()); err != nil {
+			zap.L().Warn("Failed to refetch jwks on unknown key", zap.Stringer("kid", keyUUID), zap.Error(err))
+		}
+	}
+	s.fetchLock.Unlock()
+
+	return s.lookup(keyUUID)
+}
+
+func (s *RemoteKeyStore) Close() error {
+	s.cancel()
+	<-s.done
+	return nil
+}
+
+func (s *RemoteKeyStore) lookup(keyUUID uuid.UUID) (crypto.PublicKey, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if s.config.MaxStale > 0 && !s.fetchedAt.IsZero() && time.Since(s.fetchedAt) > s.config.RefreshInterval+s.config.MaxStale {
+		return nil, ErrKeysStale
+	}
+
+	key, ok := s.keys[keyUUID]
+	if !ok {
+		return nil, fmt.Errorf("%w: %v", ErrKeyNotFound, keyUUID)
+	}
+	return key, nil
+}
+
+func (s *RemoteKeyStore) run(ctx context.Context) {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.fetchLock.Lock()
+			err := s.fetchLocked(ctx)
+			s.fetchLock.Unlock()
+			if err != nil && ctx.Err() == nil {
+				zap.L().Warn("Failed to refresh jwks, using the stale keys", zap.String("url", s.config.URL), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (s *RemoteKeyStore) fetchLocked(ctx context.Context) error {
+	s.lastFetch = time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, s.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.config.URL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected jwks response status: %s", resp.Status)
+	}
+
+	var jwks JWKS
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxJWKSSize)).Decode(&jwks); err != nil {
+		return fmt.Errorf("can't decode jwks: %w", err)
+	}
+
+	keys := make(map[uuid.UUID]crypto.PublicKey, len(jwks.Keys))
+	for i := range jwks.Keys {
+		jwk := &jwks.Keys[i]
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+		keyID, err := jwk.KeyID()
+		if err != nil {
+			zap.L().Debug("Skipping jwk with non-uuid kid", zap.String("kid", jwk.Kid))
+			continue
+		}
+		key, err := jwk.PublicKey()
+		if err != nil {
+			zap.L().Warn("Skipping invalid jwk", zap.String("kid", jwk.Kid), zap.Error(err))
+			continue
+		}
+		keys[keyID] = key
+	}
+
+	s.lock.Lock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	s.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+)
+
+func TestJWKSHandler(t *testing.T) {
+	keeper, err := xcrypto.NewRSAKeeper(t.TempDir())
+	require.NoError(t, err)
+	rsaKey, err := xcrypto.GenerateKeyOf(xcrypto.KeyTypeRSA)
+	require.NoError(t, err)
+	rsaID := uuid.New()
+	require.NoError(t, keeper.AddKey(rsaID, rsaKey.Public()))
+
+	var masters []*JWTMaster
+	for _, keyType := range []xcrypto.KeyType{xcrypto.KeyTypeECDSA, xcrypto.KeyTypeEd25519} {
+		master, _, _ := newTestMaster(t, keyType)
+		masters = append(masters, master)
+	}
+
+	rec := httptest.NewRecorder()
+	NewJWKSHandler(MasterKeys(masters...), keeper.ListKeys).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/.well-known/jwks.json", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var jwks JWKS
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &jwks))
+	require.Len(t, jwks.Keys, 3)
+
+	expected := map[string]interface{}{rsaID.String(): rsaKey.Public()}
+	for _, m := range masters {
+		info := m.KeyInfo()
+		expected[info.Id.String()] = info.Key
+	}
+	for _, jwk := range jwks.Keys {
+		key, err := jwk.PublicKey()
+		require.NoError(t, err, jwk.Kty)
+		assert.Equal(t, expected[jwk.Kid], key, jwk.Kty)
+	}
+
+	// The alg can't be changed for the key
+	jwk := jwks.Keys[0]
+	jwk.Alg = AlgorithmRS256
+	if jwks.Keys[0].Kty == "RSA" {
+		jwk.Alg = AlgorithmEdDSA
+	}
+	_, err = jwk.PublicKey()
+	assert.ErrorIs(t, err, errUnsupportedJWK)
+}
+
+func TestRemoteKeyStore(t *testing.T) {
+	var lock sync.Mutex
+	var masters []*JWTMaster
+	fail := false
+	var fetches atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		lock.Lock()
+		defer lock.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		NewJWKSHandler(MasterKeys(masters...)).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	first, firstID, _ := newTestMaster(t, xcrypto.KeyTypeEd25519)
+	masters = append(masters, first)
+
+	store, err := NewRemoteKeyStore(RemoteKeyStoreConfig{
+		URL:                srv.URL,
+		RefreshInterval:    time.Hour,
+		MinRefetchInterval: 100 * time.Millisecond,
+	}, nil)
+	require.NoError(t, err)
+	defer store.Close()
+
+	checker, err := NewJWTChecker(store)
+	require.NoError(t, err)
+	token, err := first.Token(newTestClaims())
+	require.NoError(t, err)
+	require.NoError(t, checker.Parse(*token, &ClientClaims{}))
+
+	// The rotated key is refetched on the miss, but not more often than allowed
+	second, secondID, _ := newTestMaster(t, xcrypto.KeyTypeECDSA)
+	lock.Lock()
+	masters = append(masters, second)
+	lock.Unlock()
+
+	_, err = store.GetKey(secondID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	time.Sleep(150 * time.Millisecond)
+	key, err := store.GetKey(secondID)
+	require.NoError(t, err)
+	assert.Equal(t, second.KeyInfo().Key, key)
+	assert.Equal(t, int32(2), fetches.Load())
+
+	_, err = store.GetKey(uuid.New())
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, int32(2), fetches.Load())
+
+	// The stale keys are used while the remote is down
+	lock.Lock()
+	fail = true
+	lock.Unlock()
+	time.Sleep(150 * time.Millisecond)
+	_, err = store.GetKey(uuid.New())
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+	assert.Equal(t, int32(3), fetches.Load())
+	_, err = store.GetKey(firstID)
+	assert.NoError(t, err)
+}
@@ -5,9 +5,10 @@
 package auth
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/google/uuid"
@@ -24,8 +25,7 @@ const (
 	AudienceTunnel     = "tunnel"
 	AudienceAuthorizer = "authorizer"
 
-	jwtSigningMethod = "RS256"
-	jwtKeyID         = "kid"
+	jwtKeyID = "kid"
 )
 
 type StringList []string
@@ -54,33 +54,33 @@ type ClientClaims struct {
 // KeyStoreWrapper wraps any type into its closure func `fn`
 // and provides the KeyStore interface.
 type KeyStoreWrapper struct {
-	Fn func(keyUUID uuid.UUID) (*rsa.PublicKey, error)
+	Fn func(keyUUID uuid.UUID) (crypto.PublicKey, error)
 }
 
-func (w *KeyStoreWrapper) GetKey(keyUUID uuid.UUID) (*rsa.PublicKey, error) {
+func (w *KeyStoreWrapper) GetKey(keyUUID uuid.UUID) (crypto.PublicKey, error) {
 	return w.Fn(keyUUID)
 }
 
+// KeyStore returns the public key by its ID, the key type defines
+// the signing algorithm of the tokens, see KeyAlgorithm.
 type KeyStore interface {
-	GetKey(keyUUID uuid.UUID) (*rsa.PublicKey, error)
+	GetKey(keyUUID uuid.UUID) (crypto.PublicKey, error)
 }
 
 type JWTChecker struct {
 	keys   KeyStore
-	method jwt.SigningMethod
+	policy *Policy
 }
 
 // NewJWTChecker creates new JWT validator that uses keys from a given keystore
-func NewJWTChecker(keyKeeper KeyStore) (*JWTChecker, error) {
-	method := jwt.GetSigningMethod(jwtSigningMethod)
-	if method == nil {
-		return nil, fmt.Errorf("signing method is not supported: %v", jwtSigningMethod)
+func NewJWTChecker(keyKeeper KeyStore, opts ...CheckerOption) (*JWTChecker, error) {
+	checker := &JWTChecker{
+		keys: keyKeeper,
 	}
-
-	return &JWTChecker{
-		keys:   keyKeeper,
-		method: method,
-	}, nil
+	for _, o := range opts {
+		o(checker)
+	}
+	return checker, nil
 }
 
 func (instance *JWTChecker) keyHelper(token *jwt.Token) (interface{}, error) {
@@ -104,12 +104,22 @@ func (instance *JWTChecker) keyHelper(token *jwt.Token) (interface{}, error) {
 		return nil, err
 	}
 
-	return key, nil
+	// The algorithm is bound to the key, the one from the token header is not trusted
+	alg, err := KeyAlgorithm(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if method := token.Method.Alg(); method != alg {
+		return nil, fmt.Errorf("%w: invalid signing method %v for key %v", ErrInvalidToken, method, keyUUID)
+	}
 
+	return key, nil
 }
 
 func (instance *JWTChecker) Parse(tokenString string, claims jwt.Claims) error {
-	token, err := jwt.ParseWithClaims(tokenString, claims, instance.keyHelper)
+	// The claims are validated with the policy leeway instead
+	parser := &jwt.Parser{SkipClaimsValidation: instance.policy != nil}
+	token, err := parser.ParseWithClaims(tokenString, claims, instance.keyHelper)
 	if err != nil {
 		return fmt.Errorf("%w: %w", ErrInvalidToken, err)
 	}
@@ -118,9 +128,8 @@ func (instance *JWTChecker) Parse(tokenString string, claims jwt.Claims) error {
 		return ErrInvalidToken
 	}
 
-	method := token.Method.Alg()
-	if method != instance.method.Alg() {
-		return fmt.Errorf("%w: invalid signing method: %v", ErrInvalidToken, method)
+	if instance.policy != nil {
+		return instance.policy.validate(claims, time.Now())
 	}
 
 	return nil
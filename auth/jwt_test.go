@@ -0,0 +1,88 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+)
+
+func newTestMaster(t *testing.T, keyType xcrypto.KeyType) (*JWTMaster, uuid.UUID, crypto.PublicKey) {
+	private, err := xcrypto.GenerateKeyOf(keyType)
+	require.NoError(t, err)
+	keyID := uuid.New()
+	master, err := NewJWTMaster(private, &keyID)
+	require.NoError(t, err)
+	return master, keyID, private.Public()
+}
+
+func newTestClaims() *ClientClaims {
+	return &ClientClaims{
+		Audience: StringList{AudienceTunnel},
+		UserId:   "project/auth/user",
+		StandardClaims: jwt.StandardClaims{
+			ExpiresAt: time.Now().Add(time.Hour).Unix(),
+		},
+	}
+}
+
+func TestJWT_algorithms(t *testing.T) {
+	for keyType, alg := range map[xcrypto.KeyType]string{
+		xcrypto.KeyTypeRSA:     AlgorithmRS256,
+		xcrypto.KeyTypeECDSA:   AlgorithmES256,
+		xcrypto.KeyTypeEd25519: AlgorithmEdDSA,
+	} {
+		master, keyID, public := newTestMaster(t, keyType)
+		token, err := master.Token(newTestClaims())
+		require.NoError(t, err)
+
+		parsed, _, err := new(jwt.Parser).ParseUnverified(*token, &ClientClaims{})
+		require.NoError(t, err)
+		assert.Equal(t, alg, parsed.Method.Alg())
+
+		checker, err := NewJWTChecker(&KeyStoreWrapper{Fn: func(id uuid.UUID) (crypto.PublicKey, error) {
+			assert.Equal(t, keyID, id)
+			return public, nil
+		}})
+		require.NoError(t, err)
+
+		var claims ClientClaims
+		require.NoError(t, checker.Parse(*token, &claims), alg)
+		assert.Equal(t, "project/auth/user", claims.UserId)
+		require.NoError(t, master.Parse(*token, &ClientClaims{}), alg)
+	}
+}
+
+func TestJWT_algorithmBoundToKey(t *testing.T) {
+	master, keyID, _ := newTestMaster(t, xcrypto.KeyTypeEd25519)
+	token, err := master.Token(newTestClaims())
+	require.NoError(t, err)
+
+	// The token signed with the other key type is rejected even if the key ID matches
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	checker, err := NewJWTChecker(&KeyStoreWrapper{Fn: func(id uuid.UUID) (crypto.PublicKey, error) {
+		return &other.PublicKey, nil
+	}})
+	require.NoError(t, err)
+	assert.ErrorIs(t, checker.Parse(*token, &ClientClaims{}), ErrInvalidToken)
+
+	// The unsigned token is rejected
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, newTestClaims())
+	unsigned.Header[jwtKeyID] = keyID.String()
+	tokenString, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+	assert.ErrorIs(t, checker.Parse(tokenString, &ClientClaims{}), ErrInvalidToken)
+}
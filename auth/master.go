@@ -5,6 +5,7 @@
 package auth
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"errors"
 	"fmt"
@@ -16,11 +17,19 @@ import (
 
 type JWTMaster struct {
 	keyID   *uuid.UUID
-	private *rsa.PrivateKey
+	private crypto.Signer
 	method  jwt.SigningMethod
 }
 
-func NewJWTMaster(private *rsa.PrivateKey, privateId *uuid.UUID) (*JWTMaster, error) {
+// NewJWTMaster creates the token issuer with the RSA, ECDSA P-256 or Ed25519 private key,
+// the signing algorithm is defined by the key type, see KeyAlgorithm.
+// The RSA key is generated if the private key is not given.
+func NewJWTMaster(private crypto.Signer, privateId *uuid.UUID) (*JWTMaster, error) {
+	// The typed nil RSA key is treated as not given
+	if rsaKey, ok := private.(*rsa.PrivateKey); ok && rsaKey == nil {
+		private = nil
+	}
+
 	// Generate new private key if it's not given by caller
 	if private == nil {
 		if privateId != nil {
@@ -44,9 +53,9 @@ func NewJWTMaster(private *rsa.PrivateKey, privateId *uuid.UUID) (*JWTMaster, er
 		}
 	}
 
-	method := jwt.GetSigningMethod(jwtSigningMethod)
-	if method == nil {
-		return nil, fmt.Errorf("signing method is not supported: %v", jwtSigningMethod)
+	method, err := signingMethod(private.Public())
+	if err != nil {
+		return nil, err
 	}
 
 	return &JWTMaster{
@@ -90,3 +99,11 @@ func (instance *JWTMaster) Parse(tokenString string, claims jwt.Claims) error {
 
 	return nil
 }
+
+// KeyInfo returns the public key to verify the tokens, see NewJWKSHandler.
+func (instance *JWTMaster) KeyInfo() xcrypto.KeyInfo {
+	return xcrypto.KeyInfo{
+		Id:  *instance.keyID,
+		Key: instance.private.Public(),
+	}
+}
@@ -0,0 +1,170 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var ErrTokenRevoked = errors.New("token is revoked")
+
+// Claim names the claim required by the Policy.
+type Claim string
+
+const (
+	ClaimUserID         Claim = "user_id"
+	ClaimInstallationID Claim = "installation_id"
+	ClaimPlatformType   Claim = "platform_type"
+	ClaimID             Claim = "jti"
+	ClaimSubject        Claim = "sub"
+	ClaimExpiresAt      Claim = "exp"
+	ClaimIssuedAt       Claim = "iat"
+)
+
+// RevocationChecker reports whether the valid token is revoked before the expiry, see Denylist.
+type RevocationChecker interface {
+	Revoked(claims *ClientClaims) (bool, error)
+}
+
+// Policy is the declarative validation of ClientClaims applied by JWTChecker.
+// The zero fields are not checked.
+type Policy struct {
+	// Audiences the token must have at least one of.
+	Audiences StringList
+	// Issuers the token must be issued by one of.
+	Issuers []string
+	// PlatformTypes the token must be issued for one of.
+	PlatformTypes []string
+	// MaxAge limits the time since the token is issued, iat is required then.
+	MaxAge time.Duration
+	// Leeway is the clock skew allowed for exp, nbf and iat.
+	Leeway time.Duration
+	// Required claims must be set.
+	Required []Claim
+	// Revocation is consulted after all the other checks have passed.
+	Revocation RevocationChecker
+}
+
+type CheckerOption func(c *JWTChecker)
+
+// WithPolicy makes JWTChecker validate the ClientClaims with the policy,
+// the jwt.StandardClaims are validated with the policy leeway and MaxAge only
+// and the other claims types as usual.
+func WithPolicy(policy *Policy) CheckerOption {
+	return func(c *JWTChecker) {
+		c.policy = policy
+	}
+}
+
+// Validate checks the claims against the policy at the given time.
+func (p *Policy) Validate(claims *ClientClaims, now time.Time) error {
+	if err := p.validateTime(&claims.StandardClaims, now); err != nil {
+		return err
+	}
+
+	for _, claim := range p.Required {
+		if !hasClaim(claims, claim) {
+			return fmt.Errorf("%w: %s is required", ErrInvalidToken, claim)
+		}
+	}
+
+	if len(p.Audiences) > 0 && !hasAnyAudience(claims.Audience, p.Audiences) {
+		return fmt.Errorf("%w: unexpected audience %v", ErrInvalidToken, claims.Audience)
+	}
+
+	if len(p.Issuers) > 0 && !StringList(p.Issuers).Has(claims.Issuer) {
+		return fmt.Errorf("%w: unexpected issuer %s", ErrInvalidToken, claims.Issuer)
+	}
+
+	if len(p.PlatformTypes) > 0 && !StringList(p.PlatformTypes).Has(claims.PlatformType) {
+		return fmt.Errorf("%w: unexpected platform type %s", ErrInvalidToken, claims.PlatformType)
+	}
+
+	if p.Revocation != nil {
+		revoked, err := p.Revocation.Revoked(claims)
+		if err != nil {
+			return fmt.Errorf("%w: can't check revocation: %w", ErrInvalidToken, err)
+		}
+		if revoked {
+			return fmt.Errorf("%w: %w", ErrInvalidToken, ErrTokenRevoked)
+		}
+	}
+
+	return nil
+}
+
+// validate applies the policy to the claims of any type.
+func (p *Policy) validate(claims jwt.Claims, now time.Time) error {
+	switch c := claims.(type) {
+	case *ClientClaims:
+		return p.Validate(c, now)
+	case *jwt.StandardClaims:
+		return p.validateTime(c, now)
+	default:
+		if err := claims.Valid(); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidToken, err)
+		}
+		return nil
+	}
+}
+
+func (p *Policy) validateTime(claims *jwt.StandardClaims, now time.Time) error {
+	unix := now.Unix()
+	leeway := int64(p.Leeway / time.Second)
+
+	if claims.ExpiresAt != 0 && unix > claims.ExpiresAt+leeway {
+		return fmt.Errorf("%w: token is expired", ErrInvalidToken)
+	}
+	if claims.NotBefore != 0 && unix+leeway < claims.NotBefore {
+		return fmt.Errorf("%w: token is not valid yet", ErrInvalidToken)
+	}
+	if claims.IssuedAt != 0 && unix+leeway < claims.IssuedAt {
+		return fmt.Errorf("%w: token is used before issued", ErrInvalidToken)
+	}
+
+	if p.MaxAge > 0 {
+		if claims.IssuedAt == 0 {
+			return fmt.Errorf("%w: iat is required", ErrInvalidToken)
+		}
+		if unix-claims.IssuedAt > int64(p.MaxAge/time.Second)+leeway {
+			return fmt.Errorf("%w: token is too old", ErrInvalidToken)
+		}
+	}
+	return nil
+}
+
+func hasClaim(claims *ClientClaims, claim Claim) bool {
+	switch claim {
+	case ClaimUserID:
+		return len(claims.UserId) > 0
+	case ClaimInstallationID:
+		return len(claims.InstallationId) > 0
+	case ClaimPlatformType:
+		return len(claims.PlatformType) > 0
+	case ClaimID:
+		return len(claims.Id) > 0
+	case ClaimSubject:
+		return len(claims.Subject) > 0
+	case ClaimExpiresAt:
+		return claims.ExpiresAt != 0
+	case ClaimIssuedAt:
+		return claims.IssuedAt != 0
+	default:
+		return false
+	}
+}
+
+func hasAnyAudience(audience StringList, expected StringList) bool {
+	for _, aud := range expected {
+		if audience.Has(aud) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,125 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+)
+
+func TestPolicy(t *testing.T) {
+	now := time.Now()
+	policy := &Policy{
+		Audiences:     StringList{AudienceTunnel, AudienceDiscover},
+		Issuers:       []string{"auth.vpnhouse.net"},
+		PlatformTypes: []string{"android", "ios"},
+		MaxAge:        time.Hour,
+		Leeway:        30 * time.Second,
+		Required:      []Claim{ClaimUserID, ClaimExpiresAt},
+	}
+	valid := func() *ClientClaims {
+		return &ClientClaims{
+			Audience:     StringList{AudienceTunnel},
+			UserId:       "project/auth/user",
+			PlatformType: "android",
+			StandardClaims: jwt.StandardClaims{
+				Issuer:    "auth.vpnhouse.net",
+				IssuedAt:  now.Add(-time.Minute).Unix(),
+				ExpiresAt: now.Add(-10 * time.Second).Unix(), // within the leeway
+			},
+		}
+	}
+	require.NoError(t, policy.Validate(valid(), now))
+
+	for name, mutate := range map[string]func(c *ClientClaims){
+		"audience":  func(c *ClientClaims) { c.Audience = StringList{AudienceAuth} },
+		"issuer":    func(c *ClientClaims) { c.Issuer = "evil" },
+		"platform":  func(c *ClientClaims) { c.PlatformType = "web" },
+		"required":  func(c *ClientClaims) { c.UserId = "" },
+		"expired":   func(c *ClientClaims) { c.ExpiresAt = now.Add(-time.Minute).Unix() },
+		"too old":   func(c *ClientClaims) { c.IssuedAt = now.Add(-2 * time.Hour).Unix() },
+		"no iat":    func(c *ClientClaims) { c.IssuedAt = 0 },
+		"not yet":   func(c *ClientClaims) { c.NotBefore = now.Add(time.Minute).Unix() },
+		"future at": func(c *ClientClaims) { c.IssuedAt = now.Add(time.Minute).Unix() },
+	} {
+		c := valid()
+		mutate(c)
+		assert.ErrorIs(t, policy.Validate(c, now), ErrInvalidToken, name)
+	}
+}
+
+func TestJWTChecker_policy(t *testing.T) {
+	master, _, public := newTestMaster(t, xcrypto.KeyTypeEd25519)
+	denylist := NewDenylist(nil, 16, time.Minute)
+	checker, err := NewJWTChecker(&KeyStoreWrapper{Fn: func(uuid.UUID) (crypto.PublicKey, error) {
+		return public, nil
+	}}, WithPolicy(&Policy{
+		Audiences:  StringList{AudienceTunnel},
+		Leeway:     time.Minute,
+		Revocation: denylist,
+	}))
+	require.NoError(t, err)
+
+	// The expired token is accepted within the leeway
+	claims := newTestClaims()
+	claims.Id = "token-1"
+	claims.ExpiresAt = time.Now().Add(-10 * time.Second).Unix()
+	token, err := master.Token(claims)
+	require.NoError(t, err)
+	require.NoError(t, checker.Parse(*token, &ClientClaims{}))
+
+	denylist.RevokeToken("token-1", time.Now().Add(time.Hour))
+	err = checker.Parse(*token, &ClientClaims{})
+	assert.ErrorIs(t, err, ErrInvalidToken)
+	assert.ErrorIs(t, err, ErrTokenRevoked)
+}
+
+func TestDenylist(t *testing.T) {
+	lookups := 0
+	d := NewDenylist(func(key string) (bool, error) {
+		lookups++
+		switch key {
+		case "user:banned":
+			return true, nil
+		case "user:broken":
+			return false, errors.New("db is down")
+		}
+		return false, nil
+	}, 16, time.Minute)
+
+	revoked, err := d.Revoked(&ClientClaims{UserId: "banned"})
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	for i := 0; i < 3; i++ {
+		revoked, err = d.Revoked(&ClientClaims{UserId: "good"})
+		require.NoError(t, err)
+		assert.False(t, revoked)
+	}
+	// the lookups are cached
+	assert.Equal(t, 2, lookups)
+
+	_, err = d.Revoked(&ClientClaims{UserId: "broken"})
+	assert.Error(t, err)
+
+	d.RevokeUser("good", time.Now().Add(time.Hour))
+	revoked, err = d.Revoked(&ClientClaims{UserId: "good"})
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// the revocation ends
+	d.RevokeToken("jti", time.Now().Add(-time.Second))
+	revoked, err = d.Revoked(&ClientClaims{StandardClaims: jwt.StandardClaims{Id: "jti"}})
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
@@ -0,0 +1,241 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+const AudienceRefresh = "refresh"
+
+// TokenIssuer signs and verifies the tokens, see JWTMaster and RotatingMaster.
+type TokenIssuer interface {
+	Token(claims jwt.Claims) (*string, error)
+	Parse(tokenString string, claims jwt.Claims) error
+}
+
+// RefreshRecord is the issued refresh token, the token itself is not stored.
+type RefreshRecord struct {
+	// ID is the SHA-256 of the token secret.
+	ID       string `db:"id"`
+	FamilyID string `db:"family_id"`
+	UserID   string `db:"user_id"`
+	// Claims is the JSON of the ClientClaims the access tokens are issued with.
+	Claims    []byte `db:"claims"`
+	IssuedAt  int64  `db:"issued_at"`
+	ExpiresAt int64  `db:"expires_at"`
+	// UsedAt is set once the token is exchanged, zero if not used yet.
+	UsedAt  int64 `db:"used_at"`
+	Revoked bool  `db:"revoked"`
+}
+
+// RefreshStore keeps the refresh tokens, see NewMemoryRefreshStore and the refreshsql package.
+type RefreshStore interface {
+	// Save returns ErrRefreshTokenInvalid if the family is revoked,
+	// the check is atomic with RevokeFamily.
+	Save(ctx context.Context, record *RefreshRecord) error
+	// Get returns ErrRefreshTokenInvalid if there is no record.
+	Get(ctx context.Context, id string) (*RefreshRecord, error)
+	// MarkUsed atomically sets UsedAt, it returns false if the token is used already
+	// and ErrRefreshTokenInvalid if the token is revoked.
+	MarkUsed(ctx context.Context, id string, usedAt int64) (bool, error)
+	// RevokeFamily revokes the tokens of the family, including the ones saved after.
+	RevokeFamily(ctx context.Context, familyID string) error
+	// DeleteExpired removes the records expired before the given unix time.
+	DeleteExpired(ctx context.Context, before int64) error
+}
+
+type RefreshConfig struct {
+	AccessTTL  time.Duration `yaml:"access_ttl"`  // 15m by default
+	RefreshTTL time.Duration `yaml:"refresh_ttl"` // 30 days by default
+	// JWTRefresh issues the refresh tokens as JWT instead of the opaque strings,
+	// they are still checked against the store.
+	JWTRefresh bool `yaml:"jwt_refresh"`
+}
+
+type TokenPair struct {
+	AccessToken      string    `json:"access_token"`
+	RefreshToken     string    `json:"refresh_token"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// Refresher issues the short-lived access tokens along with the refresh tokens.
+// Each refresh token is exchanged once for the new pair, the reuse of the token
+// revokes the whole family issued since the login.
+type Refresher struct {
+	issuer TokenIssuer
+	store  RefreshStore
+	config RefreshConfig
+}
+
+func NewRefresher(issuer TokenIssuer, store RefreshStore, config RefreshConfig) *Refresher {
+	if config.AccessTTL <= 0 {
+		config.AccessTTL = 15 * time.Minute
+	}
+	if config.RefreshTTL <= 0 {
+		config.RefreshTTL = 30 * 24 * time.Hour
+	}
+	return &Refresher{
+		issuer: issuer,
+		store:  store,
+		config: config,
+	}
+}
+
+// Issue starts the new family with the claims, the time claims and jti are set by the Refresher.
+func (r *Refresher) Issue(ctx context.Context, claims *ClientClaims) (*TokenPair, error) {
+	template, err := json.Marshal(claims)
+	if err != nil {
+		return nil, err
+	}
+	return r.issue(ctx, uuid.NewString(), claims.UserId, template)
+}
+
+// Refresh exchanges the refresh token for the new pair.
+func (r *Refresher) Refresh(ctx context.Context, refreshToken string) (*TokenPair, error) {
+	secret, err := r.secret(refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	id := refreshID(secret)
+	record, err := r.store.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if record.Revoked || now.Unix() >= record.ExpiresAt {
+		return nil, ErrRefreshTokenInvalid
+	}
+
+	ok, err := r.store.MarkUsed(ctx, id, now.Unix())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// The token is stolen either by the client or the attacker, both are logged out
+		if err := r.store.RevokeFamily(ctx, record.FamilyID); err != nil {
+			return nil, err
+		}
+		zap.L().Warn("Refresh token reuse detected, the family is revoked",
+			zap.String("family_id", record.FamilyID), zap.String("user_id", record.UserID))
+		return nil, ErrRefreshTokenReused
+	}
+
+	// The concurrent reuse may revoke the family before the new token is saved,
+	// the store rejects it then
+	return r.issue(ctx, record.FamilyID, record.UserID, record.Claims)
+}
+
+// RevokeFamily logs out the session the refresh token belongs to.
+func (r *Refresher) RevokeFamily(ctx context.Context, refreshToken string) error {
+	secret, err := r.secret(refreshToken)
+	if err != nil {
+		return err
+	}
+	record, err := r.store.Get(ctx, refreshID(secret))
+	if err != nil {
+		return err
+	}
+	return r.store.RevokeFamily(ctx, record.FamilyID)
+}
+
+func (r *Refresher) issue(ctx context.Context, familyID, userID string, template []byte) (*TokenPair, error) {
+	now := time.Now()
+
+	var claims ClientClaims
+	if err := json.Unmarshal(template, &claims); err != nil {
+		return nil, err
+	}
+	accessExpiresAt := now.Add(r.config.AccessTTL)
+	claims.Id = uuid.NewString()
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = accessExpiresAt.Unix()
+	accessToken, err := r.issuer.Token(&claims)
+	if err != nil {
+		return nil, err
+	}
+
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(secretBytes)
+
+	refreshExpiresAt := now.Add(r.config.RefreshTTL)
+	refreshToken := secret
+	if r.config.JWTRefresh {
+		token, err := r.issuer.Token(&jwt.StandardClaims{
+			Audience:  AudienceRefresh,
+			Id:        secret,
+			Subject:   userID,
+			IssuedAt:  now.Unix(),
+			ExpiresAt: refreshExpiresAt.Unix(),
+		})
+		if err != nil {
+			return nil, err
+		}
+		refreshToken = *token
+	}
+
+	err = r.store.Save(ctx, &RefreshRecord{
+		ID:        refreshID(secret),
+		FamilyID:  familyID,
+		UserID:    userID,
+		Claims:    template,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: refreshExpiresAt.Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &TokenPair{
+		AccessToken:      *accessToken,
+		RefreshToken:     refreshToken,
+		AccessExpiresAt:  accessExpiresAt,
+		RefreshExpiresAt: refreshExpiresAt,
+	}, nil
+}
+
+// secret extracts the token secret, the JWT refresh token keeps it in the jti.
+func (r *Refresher) secret(refreshToken string) (string, error) {
+	if !r.config.JWTRefresh {
+		return refreshToken, nil
+	}
+
+	var claims jwt.StandardClaims
+	if err := r.issuer.Parse(refreshToken, &claims); err != nil {
+		return "", fmt.Errorf("%w: %w", ErrRefreshTokenInvalid, err)
+	}
+	if claims.Audience != AudienceRefresh || len(claims.Id) == 0 {
+		return "", ErrRefreshTokenInvalid
+	}
+	return claims.Id, nil
+}
+
+func refreshID(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,101 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"sync"
+)
+
+type memoryRefreshStore struct {
+	lock    sync.Mutex
+	records map[string]*RefreshRecord
+	// revoked families, kept while the family has records
+	revoked map[string]struct{}
+}
+
+// NewMemoryRefreshStore keeps the refresh tokens in memory,
+// all the sessions are logged out on restart.
+func NewMemoryRefreshStore() RefreshStore {
+	return &memoryRefreshStore{
+		records: make(map[string]*RefreshRecord),
+		revoked: make(map[string]struct{}),
+	}
+}
+
+func (s *memoryRefreshStore) Save(ctx context.Context, record *RefreshRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.revoked[record.FamilyID]; ok {
+		return ErrRefreshTokenInvalid
+	}
+	r := *record
+	s.records[r.ID] = &r
+	return nil
+}
+
+func (s *memoryRefreshStore) Get(ctx context.Context, id string) (*RefreshRecord, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return nil, ErrRefreshTokenInvalid
+	}
+	result := *r
+	return &result, nil
+}
+
+func (s *memoryRefreshStore) MarkUsed(ctx context.Context, id string, usedAt int64) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.records[id]
+	if !ok {
+		return false, ErrRefreshTokenInvalid
+	}
+	if r.Revoked {
+		return false, ErrRefreshTokenInvalid
+	}
+	if r.UsedAt != 0 {
+		return false, nil
+	}
+	r.UsedAt = usedAt
+	return true, nil
+}
+
+func (s *memoryRefreshStore) RevokeFamily(ctx context.Context, familyID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.revoked[familyID] = struct{}{}
+	for _, r := range s.records {
+		if r.FamilyID == familyID {
+			r.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (s *memoryRefreshStore) DeleteExpired(ctx context.Context, before int64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	families := make(map[string]struct{})
+	for id, r := range s.records {
+		if r.ExpiresAt < before {
+			delete(s.records, id)
+			continue
+		}
+		families[r.FamilyID] = struct{}{}
+	}
+	for familyID := range s.revoked {
+		if _, ok := families[familyID]; !ok {
+			delete(s.revoked, familyID)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+)
+
+func TestRefresher(t *testing.T) {
+	for _, jwtRefresh := range []bool{false, true} {
+		master, _, _ := newTestMaster(t, xcrypto.KeyTypeEd25519)
+		r := NewRefresher(master, NewMemoryRefreshStore(), RefreshConfig{
+			AccessTTL:  time.Minute,
+			JWTRefresh: jwtRefresh,
+		})
+		ctx := context.Background()
+
+		pair, err := r.Issue(ctx, &ClientClaims{UserId: "project/auth/user", Audience: StringList{AudienceTunnel}})
+		require.NoError(t, err)
+
+		var claims ClientClaims
+		require.NoError(t, master.Parse(pair.AccessToken, &claims))
+		assert.Equal(t, "project/auth/user", claims.UserId)
+		assert.Equal(t, pair.AccessExpiresAt.Unix(), claims.ExpiresAt)
+		assert.NotEmpty(t, claims.Id)
+
+		// the access token can't be used for the refresh
+		_, err = r.Refresh(ctx, pair.AccessToken)
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid, jwtRefresh)
+
+		next, err := r.Refresh(ctx, pair.RefreshToken)
+		require.NoError(t, err)
+		assert.NotEqual(t, pair.RefreshToken, next.RefreshToken)
+		require.NoError(t, master.Parse(next.AccessToken, &claims))
+		assert.Equal(t, StringList{AudienceTunnel}, claims.Audience)
+
+		// the reuse of the rotated token revokes the whole family
+		_, err = r.Refresh(ctx, pair.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshTokenReused)
+		_, err = r.Refresh(ctx, next.RefreshToken)
+		assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+	}
+}
+
+func TestRefresher_revoke(t *testing.T) {
+	master, _, _ := newTestMaster(t, xcrypto.KeyTypeECDSA)
+	store := NewMemoryRefreshStore()
+	r := NewRefresher(master, store, RefreshConfig{RefreshTTL: time.Hour})
+	ctx := context.Background()
+
+	pair, err := r.Issue(ctx, &ClientClaims{UserId: "user"})
+	require.NoError(t, err)
+	other, err := r.Issue(ctx, &ClientClaims{UserId: "user"})
+	require.NoError(t, err)
+
+	require.NoError(t, r.RevokeFamily(ctx, pair.RefreshToken))
+	_, err = r.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+
+	// the other session stays
+	_, err = r.Refresh(ctx, other.RefreshToken)
+	assert.NoError(t, err)
+
+	require.NoError(t, store.DeleteExpired(ctx, time.Now().Add(2*time.Hour).Unix()))
+	_, err = r.Refresh(ctx, other.RefreshToken)
+	assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+}
+
+// slowSaveStore widens the window between MarkUsed and Save of the refresh.
+type slowSaveStore struct {
+	RefreshStore
+}
+
+func (s slowSaveStore) Save(ctx context.Context, record *RefreshRecord) error {
+	time.Sleep(time.Millisecond)
+	return s.RefreshStore.Save(ctx, record)
+}
+
+func TestRefresher_concurrent(t *testing.T) {
+	master, _, _ := newTestMaster(t, xcrypto.KeyTypeEd25519)
+	r := NewRefresher(master, slowSaveStore{NewMemoryRefreshStore()}, RefreshConfig{})
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		pair, err := r.Issue(ctx, &ClientClaims{UserId: "user"})
+		require.NoError(t, err)
+
+		var wg sync.WaitGroup
+		pairs := make([]*TokenPair, 8)
+		errs := make([]error, len(pairs))
+		for j := range pairs {
+			wg.Add(1)
+			go func(j int) {
+				defer wg.Done()
+				pairs[j], errs[j] = r.Refresh(ctx, pair.RefreshToken)
+			}(j)
+		}
+		wg.Wait()
+
+		reused := 0
+		for j := range pairs {
+			if errs[j] == nil {
+				continue
+			}
+			if errors.Is(errs[j], ErrRefreshTokenReused) {
+				reused++
+				continue
+			}
+			assert.ErrorIs(t, errs[j], ErrRefreshTokenInvalid)
+		}
+		assert.Positive(t, reused)
+
+		// the family is revoked, so the pair issued to the racing winner is dead too
+		for j := range pairs {
+			if pairs[j] != nil {
+				_, err := r.Refresh(ctx, pairs[j].RefreshToken)
+				assert.ErrorIs(t, err, ErrRefreshTokenInvalid)
+			}
+		}
+	}
+}
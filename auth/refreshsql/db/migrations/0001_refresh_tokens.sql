@@ -0,0 +1,24 @@
+-- +migrate Up
+CREATE TABLE refresh_tokens (
+    id         TEXT PRIMARY KEY,
+    family_id  TEXT NOT NULL,
+    user_id    TEXT NOT NULL,
+    claims     BLOB NOT NULL,
+    issued_at  INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER NOT NULL DEFAULT 0,
+    revoked    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX refresh_tokens_expires_at ON refresh_tokens (expires_at);
+
+-- The family stays revoked for the tokens saved after the revocation
+CREATE TABLE revoked_refresh_families (
+    family_id  TEXT PRIMARY KEY,
+    revoked_at INTEGER NOT NULL
+);
+
+-- +migrate Down
+DROP TABLE revoked_refresh_families;
+DROP TABLE refresh_tokens;
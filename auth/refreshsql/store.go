@@ -0,0 +1,130 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+// Package refreshsql implements auth.RefreshStore on sqlite.
+package refreshsql
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vpnhouse/common-lib-go/auth"
+	"github.com/vpnhouse/common-lib-go/xerror"
+	"github.com/vpnhouse/common-lib-go/xstorage"
+)
+
+// insertQuery saves the token unless the family is revoked,
+// the sqlite writes are serialized, so it's atomic with RevokeFamily.
+const insertQuery = `INSERT INTO refresh_tokens (id, family_id, user_id, claims, issued_at, expires_at, used_at, revoked)
+SELECT :id, :family_id, :user_id, :claims, :issued_at, :expires_at, :used_at, :revoked
+WHERE NOT EXISTS (SELECT 1 FROM revoked_refresh_families WHERE family_id=:family_id)`
+
+//go:embed db/migrations
+var migrations embed.FS
+
+type Store struct {
+	db *sqlx.DB
+}
+
+// New opens the sqlite database at the path and applies the migrations.
+func New(path string) (*Store, error) {
+	db, err := xstorage.NewSqlite3(path, migrations)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) Save(ctx context.Context, record *auth.RefreshRecord) error {
+	result, err := s.db.NamedExecContext(ctx, insertQuery, record)
+	if err != nil {
+		return xerror.EStorageError("can't save refresh token", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return xerror.EStorageError("can't save refresh token", err)
+	}
+	if n == 0 {
+		return auth.ErrRefreshTokenInvalid
+	}
+	return nil
+}
+
+func (s *Store) Get(ctx context.Context, id string) (*auth.RefreshRecord, error) {
+	var record auth.RefreshRecord
+	err := s.db.GetContext(ctx, &record, "SELECT * FROM refresh_tokens WHERE id=?", id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, auth.ErrRefreshTokenInvalid
+		}
+		return nil, xerror.EStorageError("can't get refresh token", err)
+	}
+	return &record, nil
+}
+
+func (s *Store) MarkUsed(ctx context.Context, id string, usedAt int64) (bool, error) {
+	result, err := s.db.ExecContext(ctx, "UPDATE refresh_tokens SET used_at=? WHERE id=? AND used_at=0 AND NOT revoked", usedAt, id)
+	if err != nil {
+		return false, xerror.EStorageError("can't mark refresh token used", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, xerror.EStorageError("can't mark refresh token used", err)
+	}
+	if n == 1 {
+		return true, nil
+	}
+
+	// Either used or revoked, the revocation is never undone
+	record, err := s.Get(ctx, id)
+	if err != nil {
+		return false, err
+	}
+	if record.Revoked {
+		return false, auth.ErrRefreshTokenInvalid
+	}
+	return false, nil
+}
+
+func (s *Store) RevokeFamily(ctx context.Context, familyID string) error {
+	tx, err := s.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return xerror.EStorageError("can't revoke refresh tokens", err)
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "INSERT OR IGNORE INTO revoked_refresh_families (family_id, revoked_at) VALUES (?, ?)", familyID, time.Now().Unix())
+	if err != nil {
+		return xerror.EStorageError("can't revoke refresh tokens", err)
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE refresh_tokens SET revoked=TRUE WHERE family_id=?", familyID)
+	if err != nil {
+		return xerror.EStorageError("can't revoke refresh tokens", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return xerror.EStorageError("can't revoke refresh tokens", err)
+	}
+	return nil
+}
+
+func (s *Store) DeleteExpired(ctx context.Context, before int64) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM refresh_tokens WHERE expires_at<?", before)
+	if err != nil {
+		return xerror.EStorageError("can't delete expired refresh tokens", err)
+	}
+	// The revoked families are kept while they have tokens
+	_, err = s.db.ExecContext(ctx, "DELETE FROM revoked_refresh_families WHERE family_id NOT IN (SELECT family_id FROM refresh_tokens)")
+	if err != nil {
+		return xerror.EStorageError("can't delete expired refresh tokens", err)
+	}
+	return nil
+}
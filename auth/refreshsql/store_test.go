@@ -0,0 +1,69 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package refreshsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/auth"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+)
+
+func TestStore(t *testing.T) {
+	store, err := New(filepath.Join(t.TempDir(), "refresh.db"))
+	require.NoError(t, err)
+	defer store.Close()
+
+	private, err := xcrypto.GenerateKeyOf(xcrypto.KeyTypeEd25519)
+	require.NoError(t, err)
+	keyID := uuid.New()
+	master, err := auth.NewJWTMaster(private, &keyID)
+	require.NoError(t, err)
+
+	r := auth.NewRefresher(master, store, auth.RefreshConfig{RefreshTTL: time.Hour})
+	ctx := context.Background()
+
+	pair, err := r.Issue(ctx, &auth.ClientClaims{UserId: "project/auth/user"})
+	require.NoError(t, err)
+	next, err := r.Refresh(ctx, pair.RefreshToken)
+	require.NoError(t, err)
+
+	_, err = r.Refresh(ctx, pair.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenReused)
+	_, err = r.Refresh(ctx, next.RefreshToken)
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenInvalid)
+
+	// the family is revoked, so the token racing with the reuse isn't saved
+	record, err := store.Get(ctx, refreshTokenID(next.RefreshToken))
+	require.NoError(t, err)
+	record.ID = "racing"
+	record.Revoked = false
+	assert.ErrorIs(t, store.Save(ctx, record), auth.ErrRefreshTokenInvalid)
+	_, err = store.MarkUsed(ctx, refreshTokenID(next.RefreshToken), time.Now().Unix())
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenInvalid)
+
+	_, err = store.Get(ctx, "missing")
+	assert.ErrorIs(t, err, auth.ErrRefreshTokenInvalid)
+
+	require.NoError(t, store.DeleteExpired(ctx, time.Now().Add(2*time.Hour).Unix()))
+	var count int
+	require.NoError(t, store.db.Get(&count, "SELECT COUNT(*) FROM refresh_tokens"))
+	assert.Zero(t, count)
+	require.NoError(t, store.db.Get(&count, "SELECT COUNT(*) FROM revoked_refresh_families"))
+	assert.Zero(t, count)
+}
+
+func refreshTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,392 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
+	"github.com/vpnhouse/common-lib-go/secret"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+	"go.uber.org/zap"
+)
+
+type RotatingMasterConfig struct {
+	// KeyType of the generated keys, RSA by default.
+	KeyType xcrypto.KeyType `yaml:"key_type"`
+	// RotationInterval is the signing key lifetime, zero means the rotation on demand only.
+	RotationInterval time.Duration `yaml:"rotation_interval"`
+	// RetireAfter is how long the rotated key stays valid for the verification,
+	// it must exceed the token lifetime. 24h by default.
+	RetireAfter time.Duration `yaml:"retire_after"`
+	// StatePath is the file to persist the key ring to, the ring is kept in memory if not set.
+	StatePath string `yaml:"state_path"`
+}
+
+// RotatingMaster issues the tokens with the current signing key and
+// accepts the ones signed by the previous keys until they are retired.
+// It implements KeyStore and its Keys are to be published with NewJWKSHandler.
+type RotatingMaster struct {
+	config  RotatingMasterConfig
+	sealer  *secret.Sealer
+	checker *JWTChecker
+
+	lock     sync.RWMutex
+	current  *ringKey
+	previous []*ringKey // newest first
+
+	wakeup chan struct{}
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type ringKey struct {
+	master  *JWTMaster
+	private crypto.Signer
+	created time.Time
+	retires time.Time // zero for the current key
+}
+
+// ringState is the key ring persisted to the StatePath, the current key goes first.
+type ringState struct {
+	Keys []ringStateKey `json:"keys"`
+}
+
+type ringStateKey struct {
+	ID      uuid.UUID `json:"id"`
+	Created time.Time `json:"created"`
+	Retires time.Time `json:"retires"`
+	// Private is the PEM encoded private key sealed with the secret.Sealer.
+	Private string `json:"private"`
+}
+
+// NewRotatingMaster loads the key ring from the StatePath if any
+// or generates the first key. The sealer encrypts the persisted private keys,
+// it is required when the StatePath is set.
+func NewRotatingMaster(config RotatingMasterConfig, sealer *secret.Sealer) (*RotatingMaster, error) {
+	if len(config.KeyType) == 0 {
+		config.KeyType = xcrypto.KeyTypeRSA
+	}
+	if config.RetireAfter <= 0 {
+		config.RetireAfter = 24 * time.Hour
+	}
+	if len(config.StatePath) > 0 && sealer == nil {
+		return nil, errors.New("sealer must be set to persist the key ring")
+	}
+
+	m := &RotatingMaster{
+		config: config,
+		sealer: sealer,
+		wakeup: make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	m.checker, _ = NewJWTChecker(m)
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	if m.current == nil || m.rotationDue() {
+		if err := m.Rotate(); err != nil {
+			return nil, err
+		}
+	}
+
+	go m.run()
+	return m, nil
+}
+
+// Token signs the claims with the current key.
+func (m *RotatingMaster) Token(claims jwt.Claims) (*string, error) {
+	m.lock.RLock()
+	current := m.current.master
+	m.lock.RUnlock()
+
+	return current.Token(claims)
+}
+
+// Parse accepts the tokens signed by the current or any non-retired previous key.
+func (m *RotatingMaster) Parse(tokenString string, claims jwt.Claims) error {
+	return m.checker.Parse(tokenString, claims)
+}
+
+// GetKey returns the public key of the current or any non-retired previous key.
+func (m *RotatingMaster) GetKey(keyUUID uuid.UUID) (crypto.PublicKey, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	now := time.Now()
+	for _, k := range m.ringLocked() {
+		if *k.master.keyID == keyUUID && k.valid(now) {
+			return k.private.Public(), nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %v", ErrKeyNotFound, keyUUID)
+}
+
+// Keys lists the public keys valid for the verification, see KeySource.
+func (m *RotatingMaster) Keys() ([]xcrypto.KeyInfo, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	now := time.Now()
+	var keys []xcrypto.KeyInfo
+	for _, k := range m.ringLocked() {
+		if k.valid(now) {
+			keys = append(keys, k.master.KeyInfo())
+		}
+	}
+	return keys, nil
+}
+
+// Rotate generates the new signing key, the current one is kept
+// for the verification for the RetireAfter period.
+func (m *RotatingMaster) Rotate() error {
+	private, err := xcrypto.GenerateKeyOf(m.config.KeyType)
+	if err != nil {
+		return fmt.Errorf("can't generate JWT key pair: %w", err)
+	}
+	keyID := uuid.New()
+	master, err := NewJWTMaster(private, &keyID)
+	if err != nil {
+		return err
+	}
+
+	m.lock.Lock()
+	now := time.Now()
+	previous := m.previous
+	if m.current != nil {
+		// The current key is copied, so the ring is untouched if it can't be saved
+		retired := *m.current
+		retired.retires = now.Add(m.config.RetireAfter)
+		previous = append([]*ringKey{&retired}, previous...)
+	}
+	previous = validKeys(previous, now)
+	current := &ringKey{
+		master:  master,
+		private: private,
+		created: now,
+	}
+	if err := m.save(append([]*ringKey{current}, previous...)); err != nil {
+		m.lock.Unlock()
+		return fmt.Errorf("can't save JWT key ring: %w", err)
+	}
+	m.current = current
+	m.previous = previous
+	m.lock.Unlock()
+
+	zap.L().Info("JWT signing key rotated", zap.Stringer("kid", keyID))
+
+	select {
+	case m.wakeup <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (m *RotatingMaster) Close() error {
+	close(m.stop)
+	<-m.done
+	return nil
+}
+
+func (m *RotatingMaster) run() {
+	defer close(m.done)
+
+	timer := time.NewTimer(m.nextWakeup())
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+		case <-m.wakeup:
+		case <-timer.C:
+			if m.rotationDue() {
+				if err := m.Rotate(); err != nil {
+					zap.L().Error("Failed to rotate JWT signing key", zap.Error(err))
+				}
+			}
+			m.lock.Lock()
+			if m.pruneLocked(time.Now()) {
+				if err := m.save(m.ringLocked()); err != nil {
+					zap.L().Error("Failed to save JWT key ring", zap.Error(err))
+				}
+			}
+			m.lock.Unlock()
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(m.nextWakeup())
+	}
+}
+
+func (m *RotatingMaster) rotationDue() bool {
+	if m.config.RotationInterval <= 0 {
+		return false
+	}
+
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return !time.Now().Before(m.current.created.Add(m.config.RotationInterval))
+}
+
+// nextWakeup returns the time until the next rotation or retirement.
+func (m *RotatingMaster) nextWakeup() time.Duration {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var next time.Time
+	if m.config.RotationInterval > 0 {
+		next = m.current.created.Add(m.config.RotationInterval)
+	}
+	for _, k := range m.previous {
+		if next.IsZero() || k.retires.Before(next) {
+			next = k.retires
+		}
+	}
+	if next.IsZero() {
+		// Nothing scheduled, waiting for the rotation on demand
+		return time.Hour
+	}
+
+	d := time.Until(next)
+	if d < time.Second {
+		d = time.Second
+	}
+	return d
+}
+
+// pruneLocked drops the retired keys, it returns true if any.
+func (m *RotatingMaster) pruneLocked(now time.Time) bool {
+	valid := validKeys(m.previous, now)
+	pruned := len(valid) != len(m.previous)
+	m.previous = valid
+	return pruned
+}
+
+// validKeys returns the new slice of the non-retired keys.
+func validKeys(keys []*ringKey, now time.Time) []*ringKey {
+	var valid []*ringKey
+	for _, k := range keys {
+		if k.valid(now) {
+			valid = append(valid, k)
+		}
+	}
+	return valid
+}
+
+func (m *RotatingMaster) ringLocked() []*ringKey {
+	return append([]*ringKey{m.current}, m.previous...)
+}
+
+func (k *ringKey) valid(now time.Time) bool {
+	return k.retires.IsZero() || now.Before(k.retires)
+}
+
+func (m *RotatingMaster) load() error {
+	if len(m.config.StatePath) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.config.StatePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	var state ringState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("can't decode JWT key ring: %w", err)
+	}
+
+	now := time.Now()
+	for i, sk := range state.Keys {
+		pemBytes, err := m.sealer.Unseal(sk.Private)
+		if err != nil {
+			return fmt.Errorf("can't unseal JWT key %v: %w", sk.ID, err)
+		}
+		private, err := xcrypto.UnmarshalAnyPrivateKey(pemBytes)
+		if err != nil {
+			return fmt.Errorf("can't decode JWT key %v: %w", sk.ID, err)
+		}
+		keyID := sk.ID
+		master, err := NewJWTMaster(private, &keyID)
+		if err != nil {
+			return err
+		}
+
+		k := &ringKey{
+			master:  master,
+			private: private,
+			created: sk.Created,
+			retires: sk.Retires,
+		}
+		if i == 0 {
+			k.retires = time.Time{}
+			m.current = k
+		} else if k.valid(now) {
+			m.previous = append(m.previous, k)
+		}
+	}
+	return nil
+}
+
+// save persists the ring, the current key goes first.
+func (m *RotatingMaster) save(ring []*ringKey) error {
+	if len(m.config.StatePath) == 0 {
+		return nil
+	}
+
+	var state ringState
+	for _, k := range ring {
+		pemBytes, err := xcrypto.MarshalAnyPrivateKey(k.private)
+		if err != nil {
+			return err
+		}
+		state.Keys = append(state.Keys, ringStateKey{
+			ID:      *k.master.keyID,
+			Created: k.created,
+			Retires: k.retires,
+			Private: m.sealer.Seal(pemBytes),
+		})
+	}
+
+	data, err := json.Marshal(&state)
+	if err != nil {
+		return err
+	}
+
+	// Written to the temporary file first, so the ring is never lost on the crash
+	tmp, err := os.CreateTemp(filepath.Dir(m.config.StatePath), filepath.Base(m.config.StatePath)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.config.StatePath)
+}
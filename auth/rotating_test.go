@@ -0,0 +1,124 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/secret"
+	"github.com/vpnhouse/common-lib-go/xcrypto"
+)
+
+func TestRotatingMaster(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "jwt.json")
+	config := RotatingMasterConfig{
+		KeyType:     xcrypto.KeyTypeEd25519,
+		RetireAfter: 300 * time.Millisecond,
+		StatePath:   statePath,
+	}
+	sealer := secret.New("secret")
+
+	m, err := NewRotatingMaster(config, sealer)
+	require.NoError(t, err)
+
+	oldToken, err := m.Token(newTestClaims())
+	require.NoError(t, err)
+
+	require.NoError(t, m.Rotate())
+	newToken, err := m.Token(newTestClaims())
+	require.NoError(t, err)
+
+	// both keys are valid until the old one is retired
+	assert.NoError(t, m.Parse(*oldToken, &ClientClaims{}))
+	assert.NoError(t, m.Parse(*newToken, &ClientClaims{}))
+	keys, err := m.Keys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	// the private keys are sealed at rest
+	data, err := os.ReadFile(statePath)
+	require.NoError(t, err)
+	assert.False(t, strings.Contains(string(data), "PRIVATE KEY"))
+	require.NoError(t, m.Close())
+
+	// the ring survives the restart
+	m, err = NewRotatingMaster(config, sealer)
+	require.NoError(t, err)
+	defer m.Close()
+	assert.NoError(t, m.Parse(*oldToken, &ClientClaims{}))
+	assert.NoError(t, m.Parse(*newToken, &ClientClaims{}))
+
+	time.Sleep(400 * time.Millisecond)
+	assert.ErrorIs(t, m.Parse(*oldToken, &ClientClaims{}), ErrInvalidToken)
+	assert.NoError(t, m.Parse(*newToken, &ClientClaims{}))
+
+	// the retired key is pruned
+	assert.Eventually(t, func() bool {
+		keys, _ := m.Keys()
+		return len(keys) == 1 && len(m.previousKeys()) == 0
+	}, 2*time.Second, 50*time.Millisecond)
+
+	_, err = NewRotatingMaster(config, secret.New("wrong"))
+	assert.Error(t, err)
+}
+
+func TestRotatingMaster_saveFailure(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+	require.NoError(t, os.Mkdir(dir, 0o700))
+	m, err := NewRotatingMaster(RotatingMasterConfig{
+		KeyType:   xcrypto.KeyTypeEd25519,
+		StatePath: filepath.Join(dir, "jwt.json"),
+	}, secret.New("secret"))
+	require.NoError(t, err)
+	defer m.Close()
+
+	token, err := m.Token(newTestClaims())
+	require.NoError(t, err)
+	before, err := m.Keys()
+	require.NoError(t, err)
+
+	// the ring in memory is kept as persisted if the new one can't be saved
+	require.NoError(t, os.RemoveAll(dir))
+	assert.Error(t, m.Rotate())
+
+	after, err := m.Keys()
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	assert.Empty(t, m.previousKeys())
+	again, err := m.Token(newTestClaims())
+	require.NoError(t, err)
+	assert.NoError(t, m.Parse(*token, &ClientClaims{}))
+	assert.NoError(t, m.Parse(*again, &ClientClaims{}))
+}
+
+func TestRotatingMaster_schedule(t *testing.T) {
+	m, err := NewRotatingMaster(RotatingMasterConfig{
+		KeyType:          xcrypto.KeyTypeECDSA,
+		RotationInterval: time.Second,
+	}, nil)
+	require.NoError(t, err)
+	defer m.Close()
+
+	first, err := m.Keys()
+	require.NoError(t, err)
+	require.Len(t, first, 1)
+
+	assert.Eventually(t, func() bool {
+		keys, _ := m.Keys()
+		return len(keys) == 2 && keys[1].Id == first[0].Id
+	}, 3*time.Second, 50*time.Millisecond)
+}
+
+func (m *RotatingMaster) previousKeys() []*ringKey {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return append([]*ringKey(nil), m.previous...)
+}
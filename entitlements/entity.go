@@ -56,6 +56,12 @@ func (s Entitlements) JSON() ([]byte, error) {
 	return json.Marshal(s)
 }
 
+// Has reports whether the boolean entitlement is granted.
+func (s Entitlements) Has(name string) bool {
+	v, _ := asBool(s[name])
+	return v
+}
+
 func (s Entitlements) SetWireguard(v bool) {
 	s[Wireguard] = v
 }
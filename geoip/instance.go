@@ -4,6 +4,7 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -14,25 +15,89 @@ import (
 
 const reloadTimeout = time.Minute
 
+type (
+	Option  func(opts *options)
+	options struct {
+		cityPath string
+		asnPath  string
+		trusted  *TrustedResolver
+	}
+)
+
+// WithCityDB loads the GeoIP2/GeoLite2 City database alongside the country one.
+func WithCityDB(path string) Option {
+	return func(opts *options) {
+		opts.cityPath = path
+	}
+}
+
+// WithASNDB loads the GeoLite2 ASN or GeoIP2 ISP database alongside the country one.
+func WithASNDB(path string) Option {
+	return func(opts *options) {
+		opts.asnPath = path
+	}
+}
+
+// WithTrustedResolver lets TryGetCountryFromRequest believe the forwarding headers
+// set by the trusted proxies, only the peer address is used otherwise.
+func WithTrustedResolver(trusted *TrustedResolver) Option {
+	return func(opts *options) {
+		opts.trusted = trusted
+	}
+}
+
 type Instance struct {
+	trusted   *TrustedResolver
 	dbCountry atomic.Pointer[db]
+	dbCity    atomic.Pointer[db]
+	dbASN     atomic.Pointer[db]
 	stop      chan struct{}
-	done      chan struct{}
+	done      sync.WaitGroup
 }
 
-func NewGeoip(path string) (*Instance, error) {
-	reader, modTime, err := load(path, time.Time{})
-	if err != nil {
-		return nil, err
+func NewGeoip(path string, opts ...Option) (*Instance, error) {
+	var options options
+	for _, opt := range opts {
+		opt(&options)
 	}
 
 	s := &Instance{
-		stop: make(chan struct{}),
-		done: make(chan struct{}),
+		trusted: options.trusted,
+		stop:    make(chan struct{}),
+	}
+
+	sources := []struct {
+		path string
+		ptr  *atomic.Pointer[db]
+	}{
+		{path, &s.dbCountry},
+		{options.cityPath, &s.dbCity},
+		{options.asnPath, &s.dbASN},
+	}
+
+	modTimes := make([]time.Time, len(sources))
+	for i, src := range sources {
+		if len(src.path) == 0 {
+			continue
+		}
+
+		reader, modTime, err := load(src.path, time.Time{})
+		if err != nil {
+			s.closeAll()
+			return nil, err
+		}
+		src.ptr.Store(newDb(reader))
+		modTimes[i] = modTime
 	}
-	s.dbCountry.Store(newDb(reader))
 
-	go s.run(path, modTime)
+	// every database is reloaded independently
+	for i, src := range sources {
+		if len(src.path) == 0 {
+			continue
+		}
+		s.done.Add(1)
+		go s.run(src.ptr, src.path, modTimes[i])
+	}
 
 	return s, nil
 }
@@ -56,12 +121,97 @@ func (s *Instance) GetCountry(ip net.IP) (string, error) {
 	return record.Country.ISOCode, nil
 }
 
+type City struct {
+	Name           string
+	Region         string
+	RegionCode     string
+	Country        string
+	Latitude       float64
+	Longitude      float64
+	AccuracyRadius uint16
+	TimeZone       string
+}
+
+func (s *Instance) GetCity(ip net.IP) (*City, error) {
+	db := s.dbCity.Load()
+	if db == nil {
+		return nil, xerror.EInternalError("maxmind city database is not configured or instance was stopped", nil)
+	}
+	var record struct {
+		City struct {
+			Names map[string]string `maxminddb:"names"`
+		} `maxminddb:"city"`
+		Country struct {
+			ISOCode string `maxminddb:"iso_code"`
+		} `maxminddb:"country"`
+		Location struct {
+			AccuracyRadius uint16  `maxminddb:"accuracy_radius"`
+			Latitude       float64 `maxminddb:"latitude"`
+			Longitude      float64 `maxminddb:"longitude"`
+			TimeZone       string  `maxminddb:"time_zone"`
+		} `maxminddb:"location"`
+		Subdivisions []struct {
+			ISOCode string            `maxminddb:"iso_code"`
+			Names   map[string]string `maxminddb:"names"`
+		} `maxminddb:"subdivisions"`
+	}
+
+	err := db.Lookup(ip, &record)
+	if err != nil {
+		return nil, xerror.EInternalError("can't lookup city", err)
+	}
+
+	city := &City{
+		Name:           record.City.Names["en"],
+		Country:        record.Country.ISOCode,
+		Latitude:       record.Location.Latitude,
+		Longitude:      record.Location.Longitude,
+		AccuracyRadius: record.Location.AccuracyRadius,
+		TimeZone:       record.Location.TimeZone,
+	}
+	// the first subdivision is the largest one, e.g. the state or the region
+	if len(record.Subdivisions) > 0 {
+		city.Region = record.Subdivisions[0].Names["en"]
+		city.RegionCode = record.Subdivisions[0].ISOCode
+	}
+	return city, nil
+}
+
+type ASN struct {
+	Number       uint
+	Organization string
+	// ISP is available with the GeoIP2 ISP database only.
+	ISP string
+}
+
+func (s *Instance) GetASN(ip net.IP) (*ASN, error) {
+	db := s.dbASN.Load()
+	if db == nil {
+		return nil, xerror.EInternalError("maxmind ASN database is not configured or instance was stopped", nil)
+	}
+	var record struct {
+		Number       uint   `maxminddb:"autonomous_system_number"`
+		Organization string `maxminddb:"autonomous_system_organization"`
+		ISP          string `maxminddb:"isp"`
+	}
+
+	err := db.Lookup(ip, &record)
+	if err != nil {
+		return nil, xerror.EInternalError("can't lookup ASN", err)
+	}
+
+	return &ASN{
+		Number:       record.Number,
+		Organization: record.Organization,
+		ISP:          record.ISP,
+	}, nil
+}
+
 func (s *Instance) TryGetCountryFromRequest(r *http.Request) string {
 	if s == nil {
 		return ""
 	}
-	clientIP := GetRemoteIP(r)
-	ip := net.ParseIP(clientIP)
+	ip := s.trusted.Resolve(r).IP
 	if ip == nil {
 		zap.L().Error("failed to get client ip by request")
 		return ""
@@ -75,6 +225,16 @@ func (s *Instance) TryGetCountryFromRequest(r *http.Request) string {
 	return country
 }
 
+// HasCity reports whether the city database is loaded.
+func (s *Instance) HasCity() bool {
+	return s != nil && s.dbCity.Load() != nil
+}
+
+// HasASN reports whether the ASN database is loaded.
+func (s *Instance) HasASN() bool {
+	return s != nil && s.dbASN.Load() != nil
+}
+
 func (s *Instance) Shutdown() error {
 	db := s.dbCountry.Swap(nil)
 	if db == nil {
@@ -82,20 +242,39 @@ func (s *Instance) Shutdown() error {
 	}
 
 	close(s.stop)
-	<-s.done
+	s.done.Wait()
 
 	err := db.Close()
+	if closeErr := s.closeAll(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
 		return xerror.EInternalError("can't close maxmind db", err)
 	}
 	return nil
 }
 
+func (s *Instance) closeAll() error {
+	var firstErr error
+	for _, ptr := range []*atomic.Pointer[db]{&s.dbCountry, &s.dbCity, &s.dbASN} {
+		db := ptr.Swap(nil)
+		if db == nil {
+			continue
+		}
+		if err := db.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func (s *Instance) Running() bool {
 	return s.dbCountry.Load() != nil
 }
 
-func (s *Instance) run(path string, modTime time.Time) {
+func (s *Instance) run(ptr *atomic.Pointer[db], path string, modTime time.Time) {
+	defer s.done.Done()
+
 	ticker := time.NewTicker(reloadTimeout)
 	defer ticker.Stop()
 
@@ -105,7 +284,6 @@ func (s *Instance) run(path string, modTime time.Time) {
 	for {
 		select {
 		case <-s.stop:
-			close(s.done)
 			return
 		case <-ticker.C:
 			reader, modTime, err = load(path, modTime)
@@ -118,7 +296,7 @@ func (s *Instance) run(path string, modTime time.Time) {
 			if reader == nil {
 				continue
 			}
-			db := s.dbCountry.Swap(newDb(reader))
+			db := ptr.Swap(newDb(reader))
 			if db == nil {
 				continue
 			}
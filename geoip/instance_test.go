@@ -2,12 +2,16 @@ package geoip
 
 import (
 	"net"
+	"net/http"
 	"os"
+	"path/filepath"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/geoip/internal/mmdbtest"
 	"go.uber.org/zap"
 )
 
@@ -91,3 +95,150 @@ func TestGeoipInstance(t *testing.T) {
 
 	wg.Wait()
 }
+
+func writeFixtures(t *testing.T) (country, city, asn string) {
+	dir := t.TempDir()
+	country = filepath.Join(dir, "country.mmdb")
+	city = filepath.Join(dir, "city.mmdb")
+	asn = filepath.Join(dir, "asn.mmdb")
+
+	err := mmdbtest.New("GeoLite2-Country").
+		MustInsert("81.2.69.0/24", map[string]any{
+			"country": map[string]any{"iso_code": "GB"},
+		}).
+		MustInsert("2001:db8::/32", map[string]any{
+			"country": map[string]any{"iso_code": "DE"},
+		}).
+		WriteFile(country)
+	require.NoError(t, err)
+
+	err = mmdbtest.New("GeoLite2-City").
+		MustInsert("81.2.69.0/24", map[string]any{
+			"city":    map[string]any{"names": map[string]any{"en": "London"}},
+			"country": map[string]any{"iso_code": "GB"},
+			"location": map[string]any{
+				"accuracy_radius": uint16(10),
+				"latitude":        51.5142,
+				"longitude":       -0.0931,
+				"time_zone":       "Europe/London",
+			},
+			"subdivisions": []any{
+				map[string]any{"iso_code": "ENG", "names": map[string]any{"en": "England"}},
+			},
+		}).
+		WriteFile(city)
+	require.NoError(t, err)
+
+	err = mmdbtest.New("GeoLite2-ASN").
+		MustInsert("81.2.69.0/24", map[string]any{
+			"autonomous_system_number":       uint32(20712),
+			"autonomous_system_organization": "Andrews & Arnold Ltd",
+		}).
+		WriteFile(asn)
+	require.NoError(t, err)
+
+	return country, city, asn
+}
+
+func TestGeoipInstance_cityASN(t *testing.T) {
+	countryPath, cityPath, asnPath := writeFixtures(t)
+
+	geoip, err := NewGeoip(countryPath, WithCityDB(cityPath), WithASNDB(asnPath))
+	require.NoError(t, err)
+	defer geoip.Shutdown()
+
+	ip := net.ParseIP("81.2.69.160")
+
+	country, err := geoip.GetCountry(ip)
+	require.NoError(t, err)
+	assert.Equal(t, "GB", country)
+
+	country, err = geoip.GetCountry(net.ParseIP("2001:db8::1"))
+	require.NoError(t, err)
+	assert.Equal(t, "DE", country)
+
+	city, err := geoip.GetCity(ip)
+	require.NoError(t, err)
+	assert.Equal(t, &City{
+		Name:           "London",
+		Region:         "England",
+		RegionCode:     "ENG",
+		Country:        "GB",
+		Latitude:       51.5142,
+		Longitude:      -0.0931,
+		AccuracyRadius: 10,
+		TimeZone:       "Europe/London",
+	}, city)
+
+	asn, err := geoip.GetASN(ip)
+	require.NoError(t, err)
+	assert.Equal(t, &ASN{Number: 20712, Organization: "Andrews & Arnold Ltd"}, asn)
+
+	// unknown networks give empty records
+	asn, err = geoip.GetASN(net.ParseIP("192.0.2.1"))
+	require.NoError(t, err)
+	assert.Equal(t, &ASN{}, asn)
+
+	r, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/", http.NoBody)
+	require.NoError(t, err)
+	r.RemoteAddr = "81.2.69.160:6578"
+
+	resolver := &Resolver{Geo: geoip}
+	assert.Equal(t, Info{
+		Country:      "gb",
+		City:         "London",
+		Region:       "England",
+		Latitude:     51.5142,
+		Longitude:    -0.0931,
+		ASN:          20712,
+		Organization: "Andrews & Arnold Ltd",
+	}, resolver.GetInfo(r))
+
+	require.NoError(t, geoip.Shutdown())
+	assert.False(t, geoip.Running())
+	assert.False(t, geoip.HasCity())
+	assert.False(t, geoip.HasASN())
+}
+
+func TestGeoipInstance_countryOnly(t *testing.T) {
+	countryPath, _, _ := writeFixtures(t)
+
+	geoip, err := NewGeoip(countryPath)
+	require.NoError(t, err)
+	defer geoip.Shutdown()
+
+	assert.True(t, geoip.Running())
+	assert.False(t, geoip.HasCity())
+	assert.False(t, geoip.HasASN())
+
+	r, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/", http.NoBody)
+	require.NoError(t, err)
+	r.RemoteAddr = "81.2.69.160:6578"
+
+	resolver := &Resolver{Geo: geoip}
+	assert.Equal(t, Info{Country: "gb"}, resolver.GetInfo(r))
+}
+
+func TestGeoipInstance_request(t *testing.T) {
+	countryPath, _, _ := writeFixtures(t)
+
+	r, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/", http.NoBody)
+	require.NoError(t, err)
+	r.RemoteAddr = "[2001:db8::1]:6578"
+	r.Header.Set("X-Forwarded-For", "81.2.69.160")
+	r.Header.Set("X-Real-Ip", "81.2.69.160")
+
+	// the headers of the untrusted peer are ignored
+	geoip, err := NewGeoip(countryPath)
+	require.NoError(t, err)
+	defer geoip.Shutdown()
+	assert.Equal(t, "DE", geoip.TryGetCountryFromRequest(r))
+	assert.Equal(t, Info{Country: "de"}, (&Resolver{Geo: geoip}).GetInfo(r))
+
+	trusted, err := NewTrustedResolver([]string{"2001:db8::/32"}, SourceXForwardedFor)
+	require.NoError(t, err)
+	proxied, err := NewGeoip(countryPath, WithTrustedResolver(trusted))
+	require.NoError(t, err)
+	defer proxied.Shutdown()
+	assert.Equal(t, "GB", proxied.TryGetCountryFromRequest(r))
+}
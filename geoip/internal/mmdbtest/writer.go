@@ -0,0 +1,268 @@
+// Package mmdbtest builds tiny MaxMind DB files for the test fixtures.
+// Only the features required by the geoip tests are supported:
+// IPv6 trees with 32 bit records, IPv4 networks are mapped into ::/96.
+package mmdbtest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"sort"
+	"time"
+)
+
+const (
+	typeString = 2
+	typeDouble = 3
+	typeUint16 = 5
+	typeUint32 = 6
+	typeMap    = 7
+	typeUint64 = 9
+	typeArray  = 11
+	typeBool   = 14
+
+	dataSectionSeparatorSize = 16
+)
+
+var metadataStartMarker = []byte("\xAB\xCD\xEFMaxMind.com")
+
+type node struct {
+	children [2]*node
+	data     int
+}
+
+// Writer collects networks with their records.
+// Records are the plain Go values: map[string]any, []any, string,
+// float64, bool and unsigned integers.
+type Writer struct {
+	databaseType string
+	root         *node
+	records      []any
+}
+
+func New(databaseType string) *Writer {
+	return &Writer{
+		databaseType: databaseType,
+		root:         &node{data: -1},
+	}
+}
+
+// Insert adds the record for the given CIDR,
+// more specific networks must be inserted after the less specific ones.
+func (w *Writer) Insert(cidr string, record any) error {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return err
+	}
+
+	ip := network.IP.To16()
+	ones, bits := network.Mask.Size()
+	if bits == 32 {
+		// IPv4 network lives in the ::/96 subtree
+		ip = make(net.IP, net.IPv6len)
+		copy(ip[12:], network.IP.To4())
+		ones += 96
+	}
+	if ones == 0 {
+		return fmt.Errorf("mmdbtest: the whole address space can not be inserted")
+	}
+
+	w.records = append(w.records, record)
+	n := w.root
+	for i := 0; i < ones; i++ {
+		bit := (ip[i/8] >> (7 - i%8)) & 1
+		if n.children[bit] == nil {
+			n.children[bit] = &node{data: -1}
+		}
+		n = n.children[bit]
+		// the more specific network splits the less specific one
+		if n.data >= 0 && i < ones-1 {
+			n.children[0] = &node{data: n.data}
+			n.children[1] = &node{data: n.data}
+			n.data = -1
+		}
+	}
+	n.data = len(w.records) - 1
+	n.children = [2]*node{}
+	return nil
+}
+
+// MustInsert is Insert for the fixtures defined in code.
+func (w *Writer) MustInsert(cidr string, record any) *Writer {
+	if err := w.Insert(cidr, record); err != nil {
+		panic(err)
+	}
+	return w
+}
+
+// Bytes serializes the database.
+func (w *Writer) Bytes() ([]byte, error) {
+	// number the internal nodes in the BFS order
+	var nodes []*node
+	index := map[*node]uint32{}
+	queue := []*node{w.root}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		if n.data >= 0 || n.children == [2]*node{} {
+			continue
+		}
+		index[n] = uint32(len(nodes))
+		nodes = append(nodes, n)
+		for _, c := range n.children {
+			if c != nil {
+				queue = append(queue, c)
+			}
+		}
+	}
+	nodeCount := uint32(len(nodes))
+
+	data := &bytes.Buffer{}
+	offsets := make([]uint32, len(w.records))
+	for i, r := range w.records {
+		offsets[i] = uint32(data.Len())
+		if err := encode(data, r); err != nil {
+			return nil, err
+		}
+	}
+
+	out := &bytes.Buffer{}
+	for _, n := range nodes {
+		for _, c := range n.children {
+			var record uint32
+			switch {
+			case c == nil:
+				record = nodeCount
+			case c.data >= 0:
+				record = nodeCount + dataSectionSeparatorSize + offsets[c.data]
+			case c.children == [2]*node{}:
+				record = nodeCount
+			default:
+				record = index[c]
+			}
+			_ = binary.Write(out, binary.BigEndian, record)
+		}
+	}
+	out.Write(make([]byte, dataSectionSeparatorSize))
+	out.Write(data.Bytes())
+	out.Write(metadataStartMarker)
+
+	metadata := map[string]any{
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(time.Now().Unix()),
+		"database_type":               w.databaseType,
+		"description":                 map[string]any{"en": "test fixture"},
+		"ip_version":                  uint16(6),
+		"languages":                   []any{"en"},
+		"node_count":                  nodeCount,
+		"record_size":                 uint16(32),
+	}
+	if err := encode(out, metadata); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// WriteFile serializes the database into the file at path.
+func (w *Writer) WriteFile(path string) error {
+	b, err := w.Bytes()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
+
+func writeControl(buf *bytes.Buffer, typ int, size int) {
+	var ctrl byte
+	var ext []byte
+	if typ > 7 {
+		ext = append(ext, byte(typ-7))
+	} else {
+		ctrl = byte(typ) << 5
+	}
+
+	switch {
+	case size < 29:
+		ctrl |= byte(size)
+	case size < 29+256:
+		ctrl |= 29
+		ext = append(ext, byte(size-29))
+	case size < 285+65536:
+		ctrl |= 30
+		ext = binary.BigEndian.AppendUint16(ext, uint16(size-285))
+	default:
+		ctrl |= 31
+		v := size - 65821
+		ext = append(ext, byte(v>>16), byte(v>>8), byte(v))
+	}
+
+	buf.WriteByte(ctrl)
+	buf.Write(ext)
+}
+
+func writeUint(buf *bytes.Buffer, typ int, v uint64) {
+	b := binary.BigEndian.AppendUint64(nil, v)
+	b = bytes.TrimLeft(b, "\x00")
+	writeControl(buf, typ, len(b))
+	buf.Write(b)
+}
+
+func encode(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case string:
+		writeControl(buf, typeString, len(v))
+		buf.WriteString(v)
+	case float64:
+		writeControl(buf, typeDouble, 8)
+		_ = binary.Write(buf, binary.BigEndian, math.Float64bits(v))
+	case bool:
+		size := 0
+		if v {
+			size = 1
+		}
+		writeControl(buf, typeBool, size)
+	case uint16:
+		writeUint(buf, typeUint16, uint64(v))
+	case uint32:
+		writeUint(buf, typeUint32, uint64(v))
+	case uint:
+		writeUint(buf, typeUint64, uint64(v))
+	case uint64:
+		writeUint(buf, typeUint64, v)
+	case int:
+		if v < 0 {
+			return fmt.Errorf("mmdbtest: negative integers are not supported")
+		}
+		writeUint(buf, typeUint32, uint64(v))
+	case []any:
+		writeControl(buf, typeArray, len(v))
+		for _, item := range v {
+			if err := encode(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeControl(buf, typeMap, len(v))
+		for _, k := range keys {
+			if err := encode(buf, k); err != nil {
+				return err
+			}
+			if err := encode(buf, v[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("mmdbtest: unsupported type %T", v)
+	}
+	return nil
+}
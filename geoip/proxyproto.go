@@ -0,0 +1,248 @@
+package geoip
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	proxyHeaderTimeout = 5 * time.Second
+	// max length of the v1 header including CRLF
+	proxyV1MaxLength = 107
+)
+
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+)
+
+type proxyConnKey struct{}
+
+// proxyListener parses the PROXY protocol v1/v2 header sent by the trusted peers,
+// the connections from other peers are passed as is.
+type proxyListener struct {
+	net.Listener
+	trusted *TrustedResolver
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	peer := addrIP(c.RemoteAddr())
+	if !l.trusted.IsTrusted(peer) {
+		return c, nil
+	}
+	return &proxyConn{Conn: c, reader: bufio.NewReader(c)}, nil
+}
+
+// proxyConn reads the header lazily on the first Read or RemoteAddr call,
+// so Accept is never blocked by a slow peer.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once   sync.Once
+	source net.Addr
+	err    error
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+// RemoteAddr returns the source address from the PROXY header, if any.
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.once.Do(c.readHeader)
+	if c.source != nil {
+		return c.source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// proxySource returns the source address from the PROXY header, nil if there was no header.
+func (c *proxyConn) proxySource() net.Addr {
+	c.once.Do(c.readHeader)
+	return c.source
+}
+
+func (c *proxyConn) readHeader() {
+	_ = c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+	defer c.Conn.SetReadDeadline(time.Time{})
+
+	c.source, c.err = readProxyHeader(c.reader)
+}
+
+// readProxyHeader consumes the PROXY header from r, if any,
+// and returns the source address of the proxied connection.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	// peek only as much as required to not wait for the data
+	// the directly connected client may never send.
+	first, err := r.Peek(1)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var prefix []byte
+	switch first[0] {
+	case proxyV2Signature[0]:
+		prefix = proxyV2Signature
+	case proxyV1Prefix[0]:
+		prefix = proxyV1Prefix
+	default:
+		// no header, the peer talks directly
+		return nil, nil
+	}
+
+	peek, err := r.Peek(len(prefix))
+	if !bytes.Equal(peek, prefix) {
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	if first[0] == proxyV2Signature[0] {
+		return readProxyV2(r)
+	}
+	return readProxyV1(r)
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, fmt.Errorf("%w: v1 header is too long", errInvalidProxyHeader)
+		}
+	}
+
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, fmt.Errorf("%w: v1 header must end with CRLF", errInvalidProxyHeader)
+	}
+
+	// PROXY TCP4 192.0.2.1 198.51.100.1 56324 443
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("%w: malformed v1 header", errInvalidProxyHeader)
+	}
+
+	ip := net.ParseIP(fields[2])
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if ip == nil || err != nil {
+		return nil, fmt.Errorf("%w: malformed v1 source address", errInvalidProxyHeader)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	version, command := header[12]>>4, header[12]&0x0f
+	if version != 2 {
+		return nil, fmt.Errorf("%w: unsupported version %d", errInvalidProxyHeader, version)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	// LOCAL command: health checks of the proxy itself
+	if command == 0 {
+		return nil, nil
+	}
+	if command != 1 {
+		return nil, fmt.Errorf("%w: unsupported command %d", errInvalidProxyHeader, command)
+	}
+
+	switch family := header[13] >> 4; family {
+	case 1: // AF_INET
+		if len(payload) < 12 {
+			return nil, fmt.Errorf("%w: short v2 IPv4 address block", errInvalidProxyHeader)
+		}
+		ip := net.IP(append([]byte(nil), payload[0:4]...))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[8:10]))}, nil
+	case 2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, fmt.Errorf("%w: short v2 IPv6 address block", errInvalidProxyHeader)
+		}
+		ip := net.IP(append([]byte(nil), payload[0:16]...))
+		return &net.TCPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(payload[32:34]))}, nil
+	default:
+		// AF_UNSPEC or AF_UNIX, nothing useful for us
+		return nil, nil
+	}
+}
+
+// ConnContext stores the PROXY protocol connection in the request context,
+// use it as http.Server.ConnContext along with TrustedResolver.Listener.
+func ConnContext(ctx context.Context, c net.Conn) context.Context {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	if pc, ok := c.(*proxyConn); ok {
+		return context.WithValue(ctx, proxyConnKey{}, pc)
+	}
+	return ctx
+}
+
+func proxySourceFromContext(ctx context.Context) net.IP {
+	pc, ok := ctx.Value(proxyConnKey{}).(*proxyConn)
+	if !ok {
+		return nil
+	}
+	return addrIP(pc.proxySource())
+}
+
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
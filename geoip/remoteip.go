@@ -8,10 +8,11 @@ import (
 
 type IPParser func(*http.Request) string
 
+// DefaultIPAddressParsers take the direct peer address only, since the forwarding
+// headers can be set by the client. Use TrustedResolver.IPParser to believe them
+// when the peer is a trusted proxy.
 var DefaultIPAddressParsers = []IPParser{
-	GetForwardedIP, // "x-forwarded-for"
-	GetRealIP,      // "x-real-ip"
-	GetRemoteAddr,  // r.RemoteAddr
+	GetRemoteAddr, // r.RemoteAddr
 }
 
 type (
@@ -80,9 +81,8 @@ func GetRemoteAddr(r *http.Request) string {
 
 func hasValidCDNSecret(r *http.Request, cdnSecrets map[string]string) bool {
 	if len(cdnSecrets) == 0 {
-		// CDN secrets is not configured thus CDN secret check is disabled
-		// So beleive the request
-		return true
+		// CDN secrets are not configured, so nothing proves the request came through the CDN
+		return false
 	}
 	for header, secret := range cdnSecrets {
 		if r.Header.Get(header) == secret {
@@ -24,8 +24,11 @@ func TestParseIP(t *testing.T) {
 	ip = GetForwardedIP(r)
 	assert.Equal(t, "192.0.2.2", ip)
 
+	// the headers are not believed by default
 	ip = GetRemoteIP(r)
-	assert.Equal(t, "192.0.2.2", ip)
+	assert.Equal(t, "10.0.0.2", ip)
+	ip = GetRemoteIP(r, WithIPParser(CDNSecretIPParser(nil), GetRemoteAddr))
+	assert.Equal(t, "10.0.0.2", ip)
 
 	cdnSecrets := map[string]string{
 		"X-CDN-Secret": "secret",
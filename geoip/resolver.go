@@ -10,11 +10,22 @@ import (
 
 type Info struct {
 	Country string
+	// The fields below are set only if the corresponding database is loaded,
+	// see WithCityDB and WithASNDB.
+	City         string
+	Region       string
+	Latitude     float64
+	Longitude    float64
+	ASN          uint
+	Organization string
+	ISP          string
 }
 
 type Resolver struct {
 	Geo        *Instance
 	CDNSecrets map[string]string
+	// Trusted replaces the CDNSecrets check if set.
+	Trusted *TrustedResolver
 }
 
 func (s *Resolver) GetInfo(r *http.Request) Info {
@@ -22,7 +33,12 @@ func (s *Resolver) GetInfo(r *http.Request) Info {
 		return Info{}
 	}
 
-	ip := GetRemoteIP(r, WithIPParser(CDNSecretIPParser(s.CDNSecrets), GetRemoteAddr))
+	var ip string
+	if s.Trusted != nil {
+		ip = s.Trusted.Resolve(r).String()
+	} else {
+		ip = GetRemoteIP(r, WithIPParser(CDNSecretIPParser(s.CDNSecrets), GetRemoteAddr))
+	}
 	if ip == "" {
 		return Info{}
 	}
@@ -37,7 +53,26 @@ func (s *Resolver) GetInfo(r *http.Request) Info {
 		zap.L().Error("failed to get country by ip", zap.String("ip", ip), zap.Error(err))
 	}
 
-	return Info{
+	info := Info{
 		Country: strings.ToLower(country),
 	}
+
+	if s.Geo.HasCity() {
+		if city, err := s.Geo.GetCity(addr); err == nil {
+			info.City = city.Name
+			info.Region = city.Region
+			info.Latitude = city.Latitude
+			info.Longitude = city.Longitude
+		}
+	}
+
+	if s.Geo.HasASN() {
+		if asn, err := s.Geo.GetASN(addr); err == nil {
+			info.ASN = asn.Number
+			info.Organization = asn.Organization
+			info.ISP = asn.ISP
+		}
+	}
+
+	return info
 }
@@ -0,0 +1,119 @@
+package selector
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+type Server struct {
+	ID        string
+	Country   string
+	City      string
+	Latitude  float64
+	Longitude float64
+	// Capacity is the max number of sessions, zero means unlimited.
+	Capacity int
+	Load     int
+	Tags     []string
+	// Requires lists the entitlements the client must have,
+	// e.g. entitlements.Wireguard or entitlements.Proxy.
+	Requires []string
+	// PaidOnly servers are not offered to the clients with ads.
+	PaidOnly bool
+	// DenyCountries lists the client countries the server must not be offered to.
+	DenyCountries []string
+}
+
+func (s *Server) loadRatio() float64 {
+	if s.Capacity <= 0 {
+		return 0
+	}
+	return float64(s.Load) / float64(s.Capacity)
+}
+
+func (s *Server) hasTag(tag string) bool {
+	for _, t := range s.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) denies(country string) bool {
+	for _, c := range s.DenyCountries {
+		if strings.EqualFold(c, country) {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) clone() Server {
+	c := *s
+	c.Tags = append([]string(nil), s.Tags...)
+	c.Requires = append([]string(nil), s.Requires...)
+	c.DenyCountries = append([]string(nil), s.DenyCountries...)
+	return c
+}
+
+// Registry is the concurrent-safe set of servers to select from.
+type Registry struct {
+	lock    sync.RWMutex
+	servers map[string]*Server
+}
+
+func NewRegistry(servers ...Server) *Registry {
+	r := &Registry{
+		servers: make(map[string]*Server, len(servers)),
+	}
+	for _, s := range servers {
+		r.Set(s)
+	}
+	return r
+}
+
+// Set adds the server or replaces the one with the same ID.
+func (r *Registry) Set(s Server) {
+	c := s.clone()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.servers[s.ID] = &c
+}
+
+func (r *Registry) Remove(id string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.servers, id)
+}
+
+// UpdateLoad sets the current load of the server,
+// false is returned if there is no such server.
+func (r *Registry) UpdateLoad(id string, load int) bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	s, ok := r.servers[id]
+	if !ok {
+		return false
+	}
+	s.Load = load
+	return true
+}
+
+// List returns copies of all servers sorted by ID.
+func (r *Registry) List() []Server {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	result := make([]Server, 0, len(r.servers))
+	for _, s := range r.servers {
+		result = append(result, s.clone())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
+}
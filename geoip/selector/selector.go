@@ -0,0 +1,232 @@
+// Package selector ranks VPN servers for the client by the distance
+// to its geoip location, by the server load and by the policy constraints.
+package selector
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/vpnhouse/common-lib-go/entitlements"
+	"github.com/vpnhouse/common-lib-go/geoip"
+)
+
+const (
+	earthRadiusKm = 6371.0
+
+	defaultDistanceWeight = 1.0
+	defaultLoadWeight     = 2.0
+)
+
+type (
+	Option  func(opts *options)
+	options struct {
+		distanceWeight float64
+		loadWeight     float64
+		ipOptions      []geoip.IPParserOption
+	}
+)
+
+// WithDistanceWeight sets the score penalty per 1000km between the client and the server.
+func WithDistanceWeight(w float64) Option {
+	return func(opts *options) {
+		opts.distanceWeight = w
+	}
+}
+
+// WithLoadWeight sets the score penalty of the fully loaded server.
+func WithLoadWeight(w float64) Option {
+	return func(opts *options) {
+		opts.loadWeight = w
+	}
+}
+
+// WithIPParserOptions sets options used to get the client IP from the HTTP request.
+func WithIPParserOptions(opts ...geoip.IPParserOption) Option {
+	return func(o *options) {
+		o.ipOptions = opts
+	}
+}
+
+type Request struct {
+	IP           net.IP
+	Entitlements entitlements.Entitlements
+	// Country limits the servers to the given country, if set.
+	Country string
+	// Tags the server must have all of.
+	Tags []string
+	// Limit is the max number of candidates returned, zero means no limit.
+	Limit int
+}
+
+type Candidate struct {
+	Server Server
+	// DistanceKm is negative if the client location is unknown.
+	DistanceKm float64
+	LoadRatio  float64
+	// Score is the ranking key, lower is better.
+	Score   float64
+	Reasons []string
+}
+
+type Rejection struct {
+	ServerID string
+	Reason   string
+}
+
+type Result struct {
+	ClientIP      string
+	ClientCountry string
+	// ClientLocated is set if the client coordinates are known.
+	ClientLocated bool
+	Latitude      float64
+	Longitude     float64
+	Candidates    []Candidate
+	Rejected      []Rejection
+}
+
+type Selector struct {
+	geo      *geoip.Instance
+	registry *Registry
+	options  options
+}
+
+func New(geo *geoip.Instance, registry *Registry, opts ...Option) *Selector {
+	options := options{
+		distanceWeight: defaultDistanceWeight,
+		loadWeight:     defaultLoadWeight,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	return &Selector{
+		geo:      geo,
+		registry: registry,
+		options:  options,
+	}
+}
+
+// SelectForRequest takes the client IP from the HTTP request, see geoip.GetRemoteIP.
+func (s *Selector) SelectForRequest(r *http.Request, req Request) Result {
+	req.IP = net.ParseIP(geoip.GetRemoteIP(r, s.options.ipOptions...))
+	return s.Select(req)
+}
+
+// Select returns servers allowed for the client ranked by the score,
+// along with the servers rejected by the policy constraints.
+func (s *Selector) Select(req Request) Result {
+	result := s.locate(req.IP)
+
+	for _, srv := range s.registry.List() {
+		if reason := s.reject(&srv, &req, result.ClientCountry); len(reason) > 0 {
+			result.Rejected = append(result.Rejected, Rejection{ServerID: srv.ID, Reason: reason})
+			continue
+		}
+		result.Candidates = append(result.Candidates, s.rank(srv, &result))
+	}
+
+	sort.SliceStable(result.Candidates, func(i, j int) bool {
+		return result.Candidates[i].Score < result.Candidates[j].Score
+	})
+	if req.Limit > 0 && len(result.Candidates) > req.Limit {
+		result.Candidates = result.Candidates[:req.Limit]
+	}
+	return result
+}
+
+func (s *Selector) locate(ip net.IP) Result {
+	result := Result{}
+	if ip == nil {
+		return result
+	}
+	result.ClientIP = ip.String()
+
+	if s.geo == nil || !s.geo.Running() {
+		return result
+	}
+
+	if country, err := s.geo.GetCountry(ip); err == nil {
+		result.ClientCountry = strings.ToLower(country)
+	}
+
+	if s.geo.HasCity() {
+		city, err := s.geo.GetCity(ip)
+		// zero coordinates with no accuracy means the network is unknown
+		if err == nil && (city.AccuracyRadius > 0 || city.Latitude != 0 || city.Longitude != 0) {
+			result.ClientLocated = true
+			result.Latitude = city.Latitude
+			result.Longitude = city.Longitude
+			if len(result.ClientCountry) == 0 {
+				result.ClientCountry = strings.ToLower(city.Country)
+			}
+		}
+	}
+
+	return result
+}
+
+func (s *Selector) reject(srv *Server, req *Request, clientCountry string) string {
+	if len(req.Country) > 0 && !strings.EqualFold(srv.Country, req.Country) {
+		return fmt.Sprintf("server country %s does not match requested %s", srv.Country, req.Country)
+	}
+	if len(clientCountry) > 0 && srv.denies(clientCountry) {
+		return fmt.Sprintf("clients from %s are not allowed", clientCountry)
+	}
+	for _, tag := range req.Tags {
+		if !srv.hasTag(tag) {
+			return fmt.Sprintf("missing tag %s", tag)
+		}
+	}
+	for _, ent := range srv.Requires {
+		if !req.Entitlements.Has(ent) {
+			return fmt.Sprintf("client has no %s entitlement", ent)
+		}
+	}
+	if srv.PaidOnly && !req.Entitlements.IsPaid() {
+		return "server is for paid clients only"
+	}
+	if srv.Capacity > 0 && srv.Load >= srv.Capacity {
+		return fmt.Sprintf("server is full (%d/%d)", srv.Load, srv.Capacity)
+	}
+	return ""
+}
+
+func (s *Selector) rank(srv Server, client *Result) Candidate {
+	c := Candidate{
+		Server:     srv,
+		DistanceKm: -1,
+		LoadRatio:  srv.loadRatio(),
+	}
+
+	if client.ClientLocated {
+		c.DistanceKm = Distance(client.Latitude, client.Longitude, srv.Latitude, srv.Longitude)
+		c.Score += s.options.distanceWeight * c.DistanceKm / 1000
+		c.Reasons = append(c.Reasons, fmt.Sprintf("distance %.0fkm", c.DistanceKm))
+	} else {
+		c.Reasons = append(c.Reasons, "client location is unknown")
+	}
+
+	c.Score += s.options.loadWeight * c.LoadRatio
+	if srv.Capacity > 0 {
+		c.Reasons = append(c.Reasons, fmt.Sprintf("load %.0f%%", c.LoadRatio*100))
+	} else {
+		c.Reasons = append(c.Reasons, "capacity is unlimited")
+	}
+
+	return c
+}
+
+// Distance returns the great-circle distance between two points in kilometers.
+func Distance(lat1, lon1, lat2, lon2 float64) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+
+	dLat := rad(lat2 - lat1)
+	dLon := rad(lon2 - lon1)
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(lat1))*math.Cos(rad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(a)))
+}
@@ -0,0 +1,131 @@
+package selector
+
+import (
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/entitlements"
+	"github.com/vpnhouse/common-lib-go/geoip"
+	"github.com/vpnhouse/common-lib-go/geoip/internal/mmdbtest"
+)
+
+func newGeoip(t *testing.T) *geoip.Instance {
+	dir := t.TempDir()
+	countryPath := filepath.Join(dir, "country.mmdb")
+	cityPath := filepath.Join(dir, "city.mmdb")
+
+	// London and Frankfurt networks
+	err := mmdbtest.New("GeoLite2-Country").
+		MustInsert("81.2.69.0/24", map[string]any{"country": map[string]any{"iso_code": "GB"}}).
+		MustInsert("89.160.20.0/24", map[string]any{"country": map[string]any{"iso_code": "DE"}}).
+		WriteFile(countryPath)
+	require.NoError(t, err)
+
+	err = mmdbtest.New("GeoLite2-City").
+		MustInsert("81.2.69.0/24", map[string]any{
+			"country":  map[string]any{"iso_code": "GB"},
+			"location": map[string]any{"accuracy_radius": uint16(10), "latitude": 51.5142, "longitude": -0.0931},
+		}).
+		MustInsert("89.160.20.0/24", map[string]any{
+			"country":  map[string]any{"iso_code": "DE"},
+			"location": map[string]any{"accuracy_radius": uint16(20), "latitude": 50.1109, "longitude": 8.6821},
+		}).
+		WriteFile(cityPath)
+	require.NoError(t, err)
+
+	geo, err := geoip.NewGeoip(countryPath, geoip.WithCityDB(cityPath))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = geo.Shutdown() })
+	return geo
+}
+
+func newRegistry() *Registry {
+	return NewRegistry(
+		Server{ID: "lon-1", Country: "gb", Latitude: 51.5074, Longitude: -0.1278, Capacity: 100, Load: 90},
+		Server{ID: "lon-2", Country: "gb", Latitude: 51.5074, Longitude: -0.1278, Capacity: 100, Load: 10},
+		Server{ID: "fra-1", Country: "de", Latitude: 50.1109, Longitude: 8.6821, Capacity: 100, Load: 0, Tags: []string{"streaming"}},
+		Server{ID: "ams-1", Country: "nl", Latitude: 52.3676, Longitude: 4.9041, Capacity: 50, Load: 50},
+		Server{ID: "nyc-1", Country: "us", Latitude: 40.7128, Longitude: -74.0060, PaidOnly: true, Requires: []string{entitlements.Wireguard}},
+		Server{ID: "sgp-1", Country: "sg", Latitude: 1.3521, Longitude: 103.8198, DenyCountries: []string{"GB"}},
+	)
+}
+
+func ids(candidates []Candidate) []string {
+	var result []string
+	for _, c := range candidates {
+		result = append(result, c.Server.ID)
+	}
+	return result
+}
+
+func TestSelect(t *testing.T) {
+	s := New(newGeoip(t), newRegistry())
+
+	res := s.Select(Request{IP: net.ParseIP("81.2.69.160")})
+	assert.Equal(t, "gb", res.ClientCountry)
+	assert.True(t, res.ClientLocated)
+	// the busy London server loses to both the idle London and Frankfurt ones
+	assert.Equal(t, []string{"lon-2", "fra-1", "lon-1"}, ids(res.Candidates))
+	assert.ElementsMatch(t, []Rejection{
+		{ServerID: "ams-1", Reason: "server is full (50/50)"},
+		{ServerID: "nyc-1", Reason: "client has no wireguard entitlement"},
+		{ServerID: "sgp-1", Reason: "clients from gb are not allowed"},
+	}, res.Rejected)
+	assert.Contains(t, res.Candidates[0].Reasons, "load 10%")
+	assert.InDelta(t, 2.5, res.Candidates[0].DistanceKm, 0.5)
+
+	res = s.Select(Request{IP: net.ParseIP("89.160.20.1"), Limit: 2})
+	assert.Equal(t, []string{"fra-1", "lon-2"}, ids(res.Candidates))
+
+	res = s.Select(Request{IP: net.ParseIP("81.2.69.160"), Tags: []string{"streaming"}})
+	assert.Equal(t, []string{"fra-1"}, ids(res.Candidates))
+
+	res = s.Select(Request{IP: net.ParseIP("81.2.69.160"), Country: "US", Entitlements: entitlements.Entitlements{
+		entitlements.Wireguard: true,
+	}})
+	assert.Equal(t, []string{"nyc-1"}, ids(res.Candidates))
+	assert.Contains(t, res.Candidates[0].Reasons, "capacity is unlimited")
+
+	res = s.Select(Request{IP: net.ParseIP("81.2.69.160"), Country: "US", Entitlements: entitlements.Entitlements{
+		entitlements.Wireguard: true,
+		entitlements.Ads:       true,
+	}})
+	assert.Empty(t, res.Candidates)
+}
+
+func TestSelect_unknownLocation(t *testing.T) {
+	s := New(newGeoip(t), newRegistry())
+
+	res := s.Select(Request{IP: net.ParseIP("192.0.2.1")})
+	assert.False(t, res.ClientLocated)
+	assert.Empty(t, res.ClientCountry)
+	// ranked by the load only
+	assert.Equal(t, []string{"fra-1", "sgp-1", "lon-2", "lon-1"}, ids(res.Candidates))
+	assert.Contains(t, res.Candidates[0].Reasons, "client location is unknown")
+	assert.EqualValues(t, -1, res.Candidates[0].DistanceKm)
+}
+
+func TestSelectForRequest(t *testing.T) {
+	registry := newRegistry()
+	s := New(newGeoip(t), registry)
+
+	r, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/", http.NoBody)
+	require.NoError(t, err)
+	r.RemoteAddr = "89.160.20.1:1234"
+
+	require.True(t, registry.UpdateLoad("fra-1", 99))
+	res := s.SelectForRequest(r, Request{})
+	assert.Equal(t, "89.160.20.1", res.ClientIP)
+	assert.Equal(t, "de", res.ClientCountry)
+	assert.Equal(t, []string{"lon-2", "fra-1", "lon-1"}, ids(res.Candidates)[:3])
+}
+
+func TestDistance(t *testing.T) {
+	// London - New York
+	assert.InDelta(t, 5570, Distance(51.5074, -0.1278, 40.7128, -74.0060), 10)
+	assert.Zero(t, Distance(10, 10, 10, 10))
+}
@@ -0,0 +1,197 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type ClientIPSource string
+
+const (
+	SourceUnknown       ClientIPSource = ""
+	SourceRemoteAddr    ClientIPSource = "remote_addr"
+	SourceProxyProtocol ClientIPSource = "proxy_protocol"
+	SourceForwarded     ClientIPSource = "forwarded"
+	SourceXForwardedFor ClientIPSource = "x_forwarded_for"
+	SourceXRealIP       ClientIPSource = "x_real_ip"
+)
+
+// ClientIP is the client address along with the place it was taken from.
+type ClientIP struct {
+	IP     net.IP
+	Source ClientIPSource
+}
+
+func (c ClientIP) String() string {
+	if c.IP == nil {
+		return ""
+	}
+	return c.IP.String()
+}
+
+// TrustedResolver resolves the client IP taking into account
+// only the header set by the trusted proxies,
+// so the client can not spoof its address by sending them on its own.
+type TrustedResolver struct {
+	trusted []*net.IPNet
+	// header is the only one the trusted proxies set, the others may come from the client
+	header ClientIPSource
+}
+
+// NewTrustedResolver creates the resolver trusting given CIDRs or single addresses,
+// e.g. the load balancer subnets and the published CDN ranges.
+// The header is the one the trusted proxies set: SourceForwarded, SourceXForwardedFor
+// or SourceXRealIP, SourceUnknown means the headers are not used at all.
+func NewTrustedResolver(cidrs []string, header ClientIPSource) (*TrustedResolver, error) {
+	switch header {
+	case SourceUnknown, SourceForwarded, SourceXForwardedFor, SourceXRealIP:
+	default:
+		return nil, fmt.Errorf("trusted proxies: unsupported header %s", header)
+	}
+
+	t := &TrustedResolver{header: header}
+	for _, s := range cidrs {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("trusted proxies: invalid address %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			t.trusted = append(t.trusted, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("trusted proxies: %w", err)
+		}
+		t.trusted = append(t.trusted, network)
+	}
+	return t, nil
+}
+
+func (t *TrustedResolver) IsTrusted(ip net.IP) bool {
+	if t == nil || ip == nil {
+		return false
+	}
+	for _, n := range t.trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Listener wraps l to accept the PROXY protocol v1/v2 header from the trusted peers,
+// the server must use ConnContext to let Resolve know about the header.
+func (t *TrustedResolver) Listener(l net.Listener) net.Listener {
+	return &proxyListener{Listener: l, trusted: t}
+}
+
+// IPParser adapts the resolver to be used with GetRemoteIP.
+func (t *TrustedResolver) IPParser() IPParser {
+	return func(r *http.Request) string {
+		return t.Resolve(r).String()
+	}
+}
+
+// Resolve walks the proxy chain of the configured header from the nearest hop
+// to the client and returns the first address not belonging to the trusted proxies.
+// The other headers are ignored since the proxies pass them from the client as is,
+// all of them are ignored if the direct peer is not trusted or the resolver is nil.
+func (t *TrustedResolver) Resolve(r *http.Request) ClientIP {
+	peer := ClientIP{IP: net.ParseIP(GetRemoteAddr(r)), Source: SourceRemoteAddr}
+	if peer.IP == nil {
+		return ClientIP{}
+	}
+	if src := proxySourceFromContext(r.Context()); src != nil && src.Equal(peer.IP) {
+		peer.Source = SourceProxyProtocol
+	}
+
+	if !t.IsTrusted(peer.IP) {
+		return peer
+	}
+
+	switch t.header {
+	case SourceForwarded:
+		if ip, ok := t.walk(parseForwarded(r.Header.Values("Forwarded"))); ok {
+			return ClientIP{IP: ip, Source: SourceForwarded}
+		}
+	case SourceXForwardedFor:
+		if ip, ok := t.walk(parseXForwardedFor(r.Header.Values("X-Forwarded-For"))); ok {
+			return ClientIP{IP: ip, Source: SourceXForwardedFor}
+		}
+	case SourceXRealIP:
+		if ip := net.ParseIP(strings.TrimSpace(r.Header.Get("X-Real-Ip"))); ip != nil {
+			return ClientIP{IP: ip, Source: SourceXRealIP}
+		}
+	}
+
+	return peer
+}
+
+// walk goes from the rightmost hop skipping the trusted ones.
+// Nothing beyond an invalid or obfuscated hop can be believed,
+// so the walk fails there as well as when every hop is trusted.
+func (t *TrustedResolver) walk(hops []string) (net.IP, bool) {
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+		if ip == nil {
+			return nil, false
+		}
+		if !t.IsTrusted(ip) {
+			return ip, true
+		}
+	}
+	return nil, false
+}
+
+func parseXForwardedFor(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+	return hops
+}
+
+// parseForwarded extracts the "for" parameters of the Forwarded header elements,
+// e.g. `for=192.0.2.60;proto=http;by=203.0.113.43, for="[2001:db8:cafe::17]:4711"`.
+func parseForwarded(values []string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			hop := ""
+			for _, pair := range strings.Split(element, ";") {
+				key, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(key, "for") {
+					hop = strings.Trim(value, `"`)
+				}
+			}
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// parseHop parses the address with optional port, IPv6 may be in brackets.
+// The "unknown" and obfuscated identifiers give nil.
+func parseHop(s string) net.IP {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		return net.ParseIP(host)
+	}
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		return net.ParseIP(s[1 : len(s)-1])
+	}
+	return nil
+}
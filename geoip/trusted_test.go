@@ -0,0 +1,176 @@
+package geoip
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedResolver(t *testing.T) {
+	cases := []struct {
+		name       string
+		header     ClientIPSource
+		remoteAddr string
+		headers    map[string][]string
+		expected   ClientIP
+	}{
+		{
+			name:       "untrusted peer spoofs headers",
+			header:     SourceXForwardedFor,
+			remoteAddr: "198.51.100.7:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1"}, "X-Real-Ip": {"1.1.1.1"}},
+			expected:   ClientIP{IP: net.ParseIP("198.51.100.7"), Source: SourceRemoteAddr},
+		},
+		{
+			name:       "client prepends fake hop",
+			header:     SourceXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"1.1.1.1, 203.0.113.5", "10.1.1.1"}},
+			expected:   ClientIP{IP: net.ParseIP("203.0.113.5"), Source: SourceXForwardedFor},
+		},
+		{
+			name:       "all hops are trusted",
+			header:     SourceXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Forwarded-For": {"192.0.2.10, 10.1.1.1"}},
+			expected:   ClientIP{IP: net.ParseIP("10.0.0.1"), Source: SourceRemoteAddr},
+		},
+		{
+			name:       "client sends forwarded to the xff proxy",
+			header:     SourceXForwardedFor,
+			remoteAddr: "10.0.0.1:1234",
+			headers: map[string][]string{
+				"Forwarded":       {"for=6.6.6.6"},
+				"X-Forwarded-For": {"203.0.113.7"},
+			},
+			expected: ClientIP{IP: net.ParseIP("203.0.113.7"), Source: SourceXForwardedFor},
+		},
+		{
+			name:       "client sends xff to the forwarded proxy",
+			header:     SourceForwarded,
+			remoteAddr: "[2001:db8::1]:1234",
+			headers: map[string][]string{
+				"Forwarded":       {`for=198.51.100.1, for="[2001:db8:cafe::17]:4711";proto=https`},
+				"X-Forwarded-For": {"203.0.113.5"},
+			},
+			expected: ClientIP{IP: net.ParseIP("198.51.100.1"), Source: SourceForwarded},
+		},
+		{
+			name:       "obfuscated hop stops the walk",
+			header:     SourceForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=198.51.100.1, for=_hidden, for=10.2.2.2"}},
+			expected:   ClientIP{IP: net.ParseIP("10.0.0.1"), Source: SourceRemoteAddr},
+		},
+		{
+			name:       "unknown nearest hop",
+			header:     SourceForwarded,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"Forwarded": {"for=unknown"}},
+			expected:   ClientIP{IP: net.ParseIP("10.0.0.1"), Source: SourceRemoteAddr},
+		},
+		{
+			name:       "real ip header",
+			header:     SourceXRealIP,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.9"}, "X-Forwarded-For": {"6.6.6.6"}},
+			expected:   ClientIP{IP: net.ParseIP("203.0.113.9"), Source: SourceXRealIP},
+		},
+		{
+			name:       "headers are not used",
+			header:     SourceUnknown,
+			remoteAddr: "10.0.0.1:1234",
+			headers:    map[string][]string{"X-Real-Ip": {"203.0.113.9"}, "X-Forwarded-For": {"6.6.6.6"}},
+			expected:   ClientIP{IP: net.ParseIP("10.0.0.1"), Source: SourceRemoteAddr},
+		},
+	}
+
+	for _, c := range cases {
+		trusted, err := NewTrustedResolver([]string{"10.0.0.0/8", "2001:db8::/32", "192.0.2.10"}, c.header)
+		require.NoError(t, err)
+
+		r, err := http.NewRequest(http.MethodGet, "http://127.0.0.1/", http.NoBody)
+		require.NoError(t, err)
+		r.RemoteAddr = c.remoteAddr
+		for k, vs := range c.headers {
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+
+		assert.Equal(t, c.expected, trusted.Resolve(r), c.name)
+	}
+
+	_, err := NewTrustedResolver([]string{"not-an-ip"}, SourceUnknown)
+	assert.Error(t, err)
+	_, err = NewTrustedResolver(nil, SourceProxyProtocol)
+	assert.Error(t, err)
+}
+
+func proxyV2Header(src net.IP, port uint16) []byte {
+	b := append([]byte(nil), proxyV2Signature...)
+	b = append(b, 0x21, 0x11) // v2 PROXY, TCP over IPv4
+	b = binary.BigEndian.AppendUint16(b, 12+4)
+	b = append(b, src.To4()...)
+	b = append(b, 127, 0, 0, 1)
+	b = binary.BigEndian.AppendUint16(b, port)
+	b = binary.BigEndian.AppendUint16(b, 443)
+	// a TLV to skip
+	b = append(b, 0x04, 0x00, 0x01, 0xff)
+	return b
+}
+
+func TestProxyProtocol(t *testing.T) {
+	trusted, err := NewTrustedResolver([]string{"127.0.0.1"}, SourceXForwardedFor)
+	require.NoError(t, err)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	srv := &http.Server{
+		ConnContext: ConnContext,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := trusted.Resolve(r)
+			_, _ = fmt.Fprintf(w, "%s %s", ip.Source, ip)
+		}),
+	}
+	go func() { _ = srv.Serve(trusted.Listener(lis)) }()
+	defer srv.Shutdown(context.Background())
+
+	request := func(header []byte, extra string) string {
+		conn, err := net.DialTimeout("tcp", lis.Addr().String(), time.Second)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = conn.Write(header)
+		require.NoError(t, err)
+		_, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: test\r\n"+extra+"Connection: close\r\n\r\n")
+		require.NoError(t, err)
+
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return string(body)
+	}
+
+	assert.Equal(t, "proxy_protocol 203.0.113.1",
+		request([]byte("PROXY TCP4 203.0.113.1 127.0.0.1 56324 443\r\n"), ""))
+	assert.Equal(t, "proxy_protocol 198.51.100.2",
+		request(proxyV2Header(net.ParseIP("198.51.100.2"), 40000), "X-Forwarded-For: 1.1.1.1\r\n"))
+	assert.Equal(t, "remote_addr 127.0.0.1",
+		request([]byte("PROXY UNKNOWN\r\n"), ""))
+	// no header at all, the trusted peer itself forwards the client
+	assert.Equal(t, "x_forwarded_for 203.0.113.3",
+		request(nil, "X-Forwarded-For: 203.0.113.3\r\n"))
+}
@@ -0,0 +1,59 @@
+// keystore-mint generates the API key for the owner and stores
+// only its hash in the keystore dir. The key is printed once.
+//
+// Usage:
+//
+//	keystore-mint -dir /opt/keystore -owner billing -scopes gh -ttl 720h
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/capabilities"
+	"github.com/vpnhouse/common-lib-go/keystore"
+)
+
+func main() {
+	dir := flag.String("dir", "", "path to the keystore dir")
+	owner := flag.String("owner", "", "key owner, used as the file name")
+	scopes := flag.String("scopes", "", "comma-separated capabilities granted to the key")
+	ttl := flag.Duration("ttl", 0, "key lifetime, no expiry if zero")
+	algorithm := flag.String("algorithm", string(keystore.AlgorithmSHA256), "secret hash algorithm: sha256, argon2id")
+	flag.Parse()
+
+	if len(*dir) == 0 || len(*owner) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dir, *owner, *scopes, *ttl, keystore.Algorithm(*algorithm)); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dir string, owner string, scopes string, ttl time.Duration, algorithm keystore.Algorithm) error {
+	set := capabilities.NewCapabilitySet()
+	if len(scopes) > 0 {
+		var err error
+		if set, err = capabilities.ParseCapabilitySet(scopes, false); err != nil {
+			return fmt.Errorf("invalid scopes `%s`: %w", scopes, err)
+		}
+	}
+
+	key, err := keystore.Mint(dir, owner, keystore.MintOptions{
+		Algorithm: algorithm,
+		Scopes:    set,
+		TTL:       ttl,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "the key is shown only once, store it safely:")
+	fmt.Println(key)
+	return nil
+}
@@ -4,8 +4,14 @@
 
 package keystore
 
+import "github.com/vpnhouse/common-lib-go/capabilities"
+
 type DenyAllKeystore struct{}
 
 func (DenyAllKeystore) Authorize(key string) (string, bool) {
 	return "", false
 }
+
+func (DenyAllKeystore) Check(key string, required ...*capabilities.Capability) (*Entry, error) {
+	return nil, ErrUnauthorized
+}
@@ -0,0 +1,193 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package keystore
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/capabilities"
+	"golang.org/x/crypto/argon2"
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrKeyExpired   = errors.New("key is expired")
+	ErrScope        = errors.New("key scope is not allowed")
+)
+
+// Algorithm hashes the key secret with the per-key salt.
+type Algorithm string
+
+const (
+	AlgorithmSHA256   Algorithm = "sha256"
+	AlgorithmArgon2ID Algorithm = "argon2id"
+)
+
+const (
+	keyIDLen     = 8
+	keySecretLen = 32
+	saltLen      = 16
+
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+)
+
+// Entry describes the key, the secret itself is never kept.
+type Entry struct {
+	ID        string
+	Owner     string
+	Scopes    *capabilities.CapabilitySet
+	ExpiresAt time.Time // zero means no expiry
+	LastUsed  time.Time // since the process start
+}
+
+// keyFile is the JSON content of the key file, the file name is the key owner.
+type keyFile struct {
+	ID        string     `json:"id"`
+	Algorithm Algorithm  `json:"algorithm"`
+	Salt      string     `json:"salt"`
+	Hash      string     `json:"hash"`
+	Scopes    string     `json:"scopes,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+type entry struct {
+	Entry
+	algorithm Algorithm
+	salt      []byte
+	hash      []byte
+	lastUsed  atomic.Int64
+}
+
+// parseKey splits the "<id>.<secret>" key, see Mint.
+func parseKey(key string) (id string, secret string, ok bool) {
+	id, secret, ok = strings.Cut(key, ".")
+	return id, secret, ok && len(id) > 0 && len(secret) > 0
+}
+
+func parseEntry(owner string, data []byte) (*entry, error) {
+	var f keyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, err
+	}
+	if len(f.ID) == 0 {
+		return nil, errors.New("no key id")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(f.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid salt: %w", err)
+	}
+	hash, err := base64.RawStdEncoding.DecodeString(f.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("invalid hash: %w", err)
+	}
+	if f.Algorithm != AlgorithmSHA256 && f.Algorithm != AlgorithmArgon2ID {
+		return nil, fmt.Errorf("unsupported algorithm: %s", f.Algorithm)
+	}
+	// The unknown scopes are not granted
+	scopes, _ := capabilities.ParseCapabilitySet(f.Scopes, true)
+
+	e := &entry{
+		Entry: Entry{
+			ID:     f.ID,
+			Owner:  owner,
+			Scopes: scopes,
+		},
+		algorithm: f.Algorithm,
+		salt:      salt,
+		hash:      hash,
+	}
+	if f.ExpiresAt != nil {
+		e.ExpiresAt = *f.ExpiresAt
+	}
+	return e, nil
+}
+
+func (e *entry) verify(secret string) bool {
+	return subtle.ConstantTimeCompare(hashSecret(e.algorithm, e.salt, secret), e.hash) == 1
+}
+
+func (e *entry) check(now time.Time, required []*capabilities.Capability) error {
+	if !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt) {
+		return ErrKeyExpired
+	}
+	for _, c := range required {
+		if !e.Scopes.Contains(c) {
+			return fmt.Errorf("%w: %s", ErrScope, c)
+		}
+	}
+	e.lastUsed.Store(now.UnixNano())
+	return nil
+}
+
+// snapshot returns the copy of the entry with the last used time.
+func (e *entry) snapshot() *Entry {
+	result := e.Entry
+	if lastUsed := e.lastUsed.Load(); lastUsed != 0 {
+		result.LastUsed = time.Unix(0, lastUsed)
+	}
+	return &result
+}
+
+func hashSecret(algorithm Algorithm, salt []byte, secret string) []byte {
+	switch algorithm {
+	case AlgorithmArgon2ID:
+		return argon2.IDKey([]byte(secret), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	default:
+		h := sha256.New()
+		h.Write(salt)
+		h.Write([]byte(secret))
+		return h.Sum(nil)
+	}
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func newKeyFile(algorithm Algorithm, scopes *capabilities.CapabilitySet, expiresAt time.Time) (key string, f *keyFile, err error) {
+	id, err := randomBytes(keyIDLen)
+	if err != nil {
+		return "", nil, err
+	}
+	secret, err := randomBytes(keySecretLen)
+	if err != nil {
+		return "", nil, err
+	}
+	salt, err := randomBytes(saltLen)
+	if err != nil {
+		return "", nil, err
+	}
+
+	secretStr := base64.RawURLEncoding.EncodeToString(secret)
+	f = &keyFile{
+		ID:        hex.EncodeToString(id),
+		Algorithm: algorithm,
+		Salt:      base64.RawStdEncoding.EncodeToString(salt),
+		Hash:      base64.RawStdEncoding.EncodeToString(hashSecret(algorithm, salt, secretStr)),
+		Scopes:    scopes.String(),
+	}
+	if !expiresAt.IsZero() {
+		f.ExpiresAt = &expiresAt
+	}
+	return f.ID + "." + secretStr, f, nil
+}
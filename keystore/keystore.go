@@ -5,13 +5,19 @@
 package keystore
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
 	"io/fs"
 	"os"
-	"path"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/vpnhouse/common-lib-go/capabilities"
 	"github.com/vpnhouse/common-lib-go/xerror"
 	"go.uber.org/zap"
 )
@@ -22,109 +28,365 @@ type Keystore interface {
 	Authorize(key string) (who string, ok bool)
 }
 
+// ScopedKeystore checks the key expiry and scopes as well.
+type ScopedKeystore interface {
+	Keystore
+	// Check returns the entry of the valid key granted all the required scopes.
+	Check(key string, required ...*capabilities.Capability) (*Entry, error)
+}
+
+// FsKeystore reloads the keys on the filesystem changes.
+type FsKeystore interface {
+	ScopedKeystore
+	// Status reports the result of the last reload.
+	Status() ReloadStatus
+	// Close stops watching the filesystem, the loaded keys are kept.
+	Close() error
+}
+
+type ReloadStatus struct {
+	// LastAttempt is the time of the last reload
+	LastAttempt time.Time
+	// LastSuccess is the time of the last reload that applied the keys
+	LastSuccess time.Time
+	// LastError is the error of the last reload, the previous keys are kept on error
+	LastError error
+	// Keys is the number of the loaded keys
+	Keys int
+}
+
+const (
+	defaultDebounce       = 200 * time.Millisecond
+	defaultResyncInterval = time.Minute
+)
+
+type options struct {
+	debounce       time.Duration
+	resyncInterval time.Duration
+}
+
+type Option func(opts *options)
+
+// WithDebounce sets the delay to coalesce the burst of the filesystem events into a single reload.
+func WithDebounce(d time.Duration) Option {
+	return func(opts *options) {
+		opts.debounce = d
+	}
+}
+
+// WithResyncInterval sets the interval of the full reload in case some events are missed,
+// zero disables the periodic reload.
+func WithResyncInterval(d time.Duration) Option {
+	return func(opts *options) {
+		opts.resyncInterval = d
+	}
+}
+
 type fsStore struct {
 	mu sync.RWMutex
-	// map key -> owner, not the vise versa
-	// because we need O(1) lookups.
-	keys map[string]string
-	root string
+	// map key id -> entry, the secret is hashed, see Mint.
+	entries map[string]*entry
+	// map sha256(key) -> owner for the legacy plaintext key files
+	legacy map[[sha256.Size]byte]string
+	status ReloadStatus
+	root   string
+	opts   options
+
+	// reloadMu serializes the reloads and guards the watched dirs
+	reloadMu sync.Mutex
+	watch    *fsnotify.Watcher
+	watched  map[string]struct{}
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+type keySet struct {
+	entries map[string]*entry
+	legacy  map[[sha256.Size]byte]string
+	dirs    []string
 }
 
-func NewFsKeystore(root string) (Keystore, error) {
+// NewFsKeystore loads the keys from the root and its subdirectories,
+// the file name is the key owner and the content is either the hashed key
+// created by Mint or the legacy plaintext key.
+// The hidden files and dirs are ignored, so the Kubernetes secret mounts
+// with the ..data symlink are supported.
+func NewFsKeystore(root string, opts ...Option) (FsKeystore, error) {
 	if len(root) == 0 {
 		return nil, xerror.EInternalError("config: no path to the management keystore is given", nil)
 	}
 
+	fss := &fsStore{
+		root: root,
+		opts: options{
+			debounce:       defaultDebounce,
+			resyncInterval: defaultResyncInterval,
+		},
+		watched: make(map[string]struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(&fss.opts)
+	}
+
 	keys, err := loadKeys(root)
 	if err != nil {
-		return nil, err
+		return nil, xerror.EInternalError("failed to load the keystore", err, zap.String("root", root))
 	}
 
-	fss := &fsStore{keys: keys, root: root}
-
-	if err := fss.watchDir(root); err != nil {
-		return nil, err
+	watch, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, xerror.EInternalError("failed to allocate fs watcher", err, zap.String("root", root))
 	}
+	fss.watch = watch
+	if _, err := fss.watchDirs(keys.dirs); err != nil {
+		_ = watch.Close()
+		return nil, xerror.EInternalError("failed to watch the keystore dir", err, zap.String("root", root))
+	}
+	fss.apply(keys, time.Now())
 
+	go fss.run()
 	return fss, nil
 }
 
 func (fss *fsStore) Authorize(key string) (string, bool) {
+	e, err := fss.Check(key)
+	if err != nil {
+		return "", false
+	}
+	return e.Owner, true
+}
+
+func (fss *fsStore) Check(key string, required ...*capabilities.Capability) (*Entry, error) {
+	if id, secret, ok := parseKey(key); ok {
+		fss.mu.RLock()
+		e, ok := fss.entries[id]
+		fss.mu.RUnlock()
+
+		// The entries are never changed once loaded, so the secret is hashed
+		// outside the lock and the slow hashes don't hold up the reload
+		if ok {
+			if !e.verify(secret) {
+				return nil, ErrUnauthorized
+			}
+			if err := e.check(time.Now(), required); err != nil {
+				return nil, err
+			}
+			return e.snapshot(), nil
+		}
+	}
+
+	// The legacy keys have no scopes and expiry
+	sum := sha256.Sum256([]byte(key))
+	fss.mu.RLock()
+	owner, ok := fss.legacy[sum]
+	fss.mu.RUnlock()
+	if !ok || len(required) > 0 {
+		return nil, ErrUnauthorized
+	}
+	return &Entry{Owner: owner, Scopes: capabilities.NewCapabilitySet()}, nil
+}
+
+func (fss *fsStore) Status() ReloadStatus {
 	fss.mu.RLock()
 	defer fss.mu.RUnlock()
 
-	v, ok := fss.keys[key]
-	return v, ok
+	return fss.status
 }
 
-func (fss *fsStore) watchDir(root string) error {
-	watch, err := fsnotify.NewWatcher()
-	if err != nil {
-		return xerror.EInternalError("failed to allocate fs watcher", err, zap.String("root", root))
-	}
-
-	if err := watch.Add(root); err != nil {
-		return xerror.EInternalError("failed to watch the root dir", err, zap.String("root", root))
-	}
-
-	go func() {
-		for {
-			select {
-			case event, ok := <-watch.Events:
-				if !ok {
-					return
-				}
-				if event.Op&fsnotify.Chmod > 0 {
-					continue
-				}
-
-				zap.L().Debug("got fs event", zap.Stringer("operation", event.Op))
-				fss.updateFromFS()
-			case err, ok := <-watch.Errors:
-				if !ok {
-					return
-				}
-				zap.L().Warn("fsnotify: got unexpected error during the watch", zap.Error(err))
+func (fss *fsStore) Close() error {
+	fss.once.Do(func() {
+		close(fss.stop)
+		<-fss.done
+	})
+	return nil
+}
+
+func (fss *fsStore) run() {
+	defer close(fss.done)
+	defer fss.watch.Close()
+
+	var resync <-chan time.Time
+	if fss.opts.resyncInterval > 0 {
+		ticker := time.NewTicker(fss.opts.resyncInterval)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	// The reload happens after the debounce delay since the first event,
+	// so the constant stream of events can't postpone it forever.
+	debounce := time.NewTimer(fss.opts.debounce)
+	debounce.Stop()
+	pending := false
+
+	for {
+		select {
+		case <-fss.stop:
+			return
+		case event, ok := <-fss.watch.Events:
+			if !ok {
+				return
 			}
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+
+			zap.L().Debug("got fs event", zap.Stringer("operation", event.Op), zap.String("path", event.Name))
+			if !pending {
+				pending = true
+				debounce.Reset(fss.opts.debounce)
+			}
+		case <-debounce.C:
+			pending = false
+			fss.reload()
+		case <-resync:
+			fss.reload()
+		case err, ok := <-fss.watch.Errors:
+			if !ok {
+				return
+			}
+			zap.L().Warn("fsnotify: got unexpected error during the watch", zap.Error(err))
 		}
-	}()
-	return nil
+	}
 }
 
-func (fss *fsStore) updateFromFS() {
-	keys, err := loadKeys(fss.root)
+func (fss *fsStore) reload() {
+	fss.reloadMu.Lock()
+	defer fss.reloadMu.Unlock()
+
+	now := time.Now()
+	keys, err := fss.loadAndWatch()
 	if err != nil {
+		zap.L().Warn("failed to reload the keystore, keeping the previous keys", zap.String("root", fss.root), zap.Error(err))
+
+		fss.mu.Lock()
+		defer fss.mu.Unlock()
+		fss.status.LastAttempt = now
+		fss.status.LastError = err
 		return
 	}
 
+	fss.apply(keys, now)
+}
+
+func (fss *fsStore) apply(keys *keySet, now time.Time) {
 	fss.mu.Lock()
 	defer fss.mu.Unlock()
 
-	fss.keys = keys
-	zap.L().Debug("keystore updated", zap.Int("n", len(keys)))
+	// Keep the last used time of the unchanged keys
+	for id, e := range keys.entries {
+		if old, ok := fss.entries[id]; ok {
+			e.lastUsed.Store(old.lastUsed.Load())
+		}
+	}
+	fss.entries = keys.entries
+	fss.legacy = keys.legacy
+	fss.status = ReloadStatus{
+		LastAttempt: now,
+		LastSuccess: now,
+		Keys:        len(keys.entries) + len(keys.legacy),
+	}
+	zap.L().Debug("keystore updated", zap.Int("n", fss.status.Keys))
+}
+
+// loadAndWatch loads the keys once again if the new dirs appeared,
+// since the files created there before the watch is added are not reported.
+func (fss *fsStore) loadAndWatch() (*keySet, error) {
+	for {
+		keys, err := loadKeys(fss.root)
+		if err != nil {
+			return nil, err
+		}
+		added, err := fss.watchDirs(keys.dirs)
+		if err != nil {
+			return nil, err
+		}
+		if !added {
+			return keys, nil
+		}
+	}
+}
+
+// watchDirs makes the watch list match the dirs, fsnotify is not recursive.
+func (fss *fsStore) watchDirs(dirs []string) (added bool, err error) {
+	actual := make(map[string]struct{}, len(dirs))
+	for _, dir := range dirs {
+		actual[dir] = struct{}{}
+		if _, ok := fss.watched[dir]; ok {
+			continue
+		}
+		if err := fss.watch.Add(dir); err != nil {
+			return added, fmt.Errorf("failed to watch %s: %w", dir, err)
+		}
+		fss.watched[dir] = struct{}{}
+		added = true
+	}
+
+	for dir := range fss.watched {
+		if _, ok := actual[dir]; !ok {
+			// The watch of the removed dir is dropped by fsnotify itself
+			_ = fss.watch.Remove(dir)
+			delete(fss.watched, dir)
+		}
+	}
+	return added, nil
+}
+
+func isHidden(name string) bool {
+	return strings.HasPrefix(name, ".")
 }
 
-func loadKeys(root string) (map[string]string, error) {
+func loadKeys(root string) (*keySet, error) {
+	keys := &keySet{
+		entries: make(map[string]*entry),
+		legacy:  make(map[[sha256.Size]byte]string),
+	}
+
 	var keyPaths []string
 	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 		if d.IsDir() {
+			if path != root && isHidden(d.Name()) {
+				return filepath.SkipDir
+			}
+			keys.dirs = append(keys.dirs, path)
+			return nil
+		}
+		if isHidden(d.Name()) {
 			return nil
 		}
 		keyPaths = append(keyPaths, path)
 		return nil
 	})
 	if err != nil {
-		return nil, xerror.EInternalError("failed to walk keystore root", err, zap.String("root", root))
+		return nil, fmt.Errorf("failed to walk keystore root: %w", err)
 	}
 
-	keys := make(map[string]string, len(keyPaths))
 	for _, keyPath := range keyPaths {
+		// The symlinks are followed, e.g. the key -> ..data/key of the Kubernetes secret
+		info, err := os.Stat(keyPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				// Removed in the middle of the reload, the next event follows
+				continue
+			}
+			return nil, fmt.Errorf("failed to stat the key file: %w", err)
+		}
+		if info.IsDir() {
+			zap.L().Warn("got symlink to dir in the keystore dir, skipping", zap.String("path", keyPath))
+			continue
+		}
+
 		bs, err := os.ReadFile(keyPath)
 		if err != nil {
-			return nil, xerror.EInternalError("failed to read the key file", err, zap.String("path", keyPath))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read the key file: %w", err)
 		}
 
 		if len(bs) == 0 {
@@ -132,8 +394,19 @@ func loadKeys(root string) (map[string]string, error) {
 			continue
 		}
 
-		_, name := path.Split(keyPath)
-		keys[string(bs)] = name
+		name := filepath.Base(keyPath)
+		if !bytes.HasPrefix(bytes.TrimSpace(bs), []byte("{")) {
+			zap.L().Warn("got plaintext key in the keystore dir, consider re-minting it", zap.String("path", keyPath))
+			keys.legacy[sha256.Sum256(bs)] = name
+			continue
+		}
+
+		e, err := parseEntry(name, bs)
+		if err != nil {
+			zap.L().Warn("got invalid key file in the keystore dir, skipping", zap.String("path", keyPath), zap.Error(err))
+			continue
+		}
+		keys.entries[e.ID] = e
 	}
 
 	return keys, nil
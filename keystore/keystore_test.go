@@ -0,0 +1,160 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package keystore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/capabilities"
+)
+
+func TestFsKeystore(t *testing.T) {
+	root := t.TempDir()
+
+	argonKey, err := Mint(root, "ghost", MintOptions{Algorithm: AlgorithmArgon2ID, Scopes: capabilities.NewCapabilitySet(capabilities.CapabilityGhost)})
+	require.NoError(t, err)
+	shaKey, err := Mint(root, "plain", MintOptions{})
+	require.NoError(t, err)
+	_, err = Mint(root, "plain", MintOptions{})
+	assert.Error(t, err, "must not overwrite the key")
+	require.NoError(t, os.WriteFile(filepath.Join(root, "legacy"), []byte("legacy-secret"), 0o600))
+
+	// only the hash is stored
+	data, err := os.ReadFile(filepath.Join(root, "ghost"))
+	require.NoError(t, err)
+	_, secret, _ := parseKey(argonKey)
+	assert.NotContains(t, string(data), secret)
+	data, err = os.ReadFile(filepath.Join(root, "plain"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"algorithm": "sha256"`)
+
+	store, err := NewFsKeystore(root)
+	require.NoError(t, err)
+
+	e, err := store.Check(argonKey, capabilities.CapabilityGhost)
+	require.NoError(t, err)
+	assert.Equal(t, "ghost", e.Owner)
+	assert.False(t, e.LastUsed.IsZero())
+	assert.True(t, e.ExpiresAt.IsZero())
+
+	_, err = store.Check(shaKey, capabilities.CapabilityGhost)
+	assert.ErrorIs(t, err, ErrScope)
+	who, ok := store.Authorize(shaKey)
+	assert.True(t, ok)
+	assert.Equal(t, "plain", who)
+
+	who, ok = store.Authorize("legacy-secret")
+	assert.True(t, ok)
+	assert.Equal(t, "legacy", who)
+	_, err = store.Check("legacy-secret", capabilities.CapabilityGhost)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	id, _, _ := parseKey(argonKey)
+	_, err = store.Check(id + ".wrong")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, ok = store.Authorize("")
+	assert.False(t, ok)
+}
+
+func TestFsKeystore_expiry(t *testing.T) {
+	root := t.TempDir()
+	key, err := Mint(root, "temp", MintOptions{Algorithm: AlgorithmSHA256, TTL: time.Hour})
+	require.NoError(t, err)
+
+	store, err := NewFsKeystore(root)
+	require.NoError(t, err)
+	e, err := store.Check(key)
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), e.ExpiresAt, time.Minute)
+
+	fss := store.(*fsStore)
+	id, _, _ := parseKey(key)
+	assert.ErrorIs(t, fss.entries[id].check(time.Now().Add(2*time.Hour), nil), ErrKeyExpired)
+}
+
+func TestFsKeystore_reload(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewFsKeystore(root, WithDebounce(10*time.Millisecond))
+	require.NoError(t, err)
+	defer store.Close()
+	assert.Zero(t, store.Status().Keys)
+
+	// the keys in the new subdirectory are picked up too
+	sub := filepath.Join(root, "team")
+	require.NoError(t, os.Mkdir(sub, 0o700))
+	key, err := Mint(sub, "member", MintOptions{Algorithm: AlgorithmSHA256})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		_, ok := store.Authorize(key)
+		return ok
+	}, time.Second, 10*time.Millisecond)
+
+	// the rename into place
+	tmp := filepath.Join(root, ".tmp")
+	require.NoError(t, os.WriteFile(tmp, []byte("renamed-secret"), 0o600))
+	require.NoError(t, os.Rename(tmp, filepath.Join(root, "renamed")))
+	require.Eventually(t, func() bool {
+		_, ok := store.Authorize("renamed-secret")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, store.Status().Keys)
+	assert.NoError(t, store.Status().LastError)
+}
+
+func TestFsKeystore_secretMount(t *testing.T) {
+	root := t.TempDir()
+	// the layout of the Kubernetes secret volume
+	writeVersion := func(version string, secret string) {
+		dir := filepath.Join(root, version)
+		require.NoError(t, os.Mkdir(dir, 0o700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "service"), []byte(secret), 0o600))
+		require.NoError(t, os.Symlink(version, filepath.Join(root, "..data_tmp")))
+		require.NoError(t, os.Rename(filepath.Join(root, "..data_tmp"), filepath.Join(root, "..data")))
+	}
+	writeVersion("..v1", "first")
+	require.NoError(t, os.Symlink("..data/service", filepath.Join(root, "service")))
+
+	store, err := NewFsKeystore(root, WithDebounce(10*time.Millisecond))
+	require.NoError(t, err)
+	defer store.Close()
+
+	who, ok := store.Authorize("first")
+	assert.True(t, ok)
+	assert.Equal(t, "service", who)
+	assert.Equal(t, 1, store.Status().Keys)
+
+	writeVersion("..v2", "second")
+	require.Eventually(t, func() bool {
+		_, ok := store.Authorize("second")
+		return ok
+	}, time.Second, 10*time.Millisecond)
+	_, ok = store.Authorize("first")
+	assert.False(t, ok)
+}
+
+func TestFsKeystore_reloadError(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "keys")
+	require.NoError(t, os.Mkdir(root, 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "owner"), []byte("secret"), 0o600))
+
+	store, err := NewFsKeystore(root, WithResyncInterval(0))
+	require.NoError(t, err)
+	defer store.Close()
+
+	require.NoError(t, os.RemoveAll(root))
+	store.(*fsStore).reload()
+
+	status := store.Status()
+	assert.Error(t, status.LastError)
+	assert.True(t, status.LastAttempt.After(status.LastSuccess))
+	assert.Equal(t, 1, status.Keys)
+	_, ok := store.Authorize("secret")
+	assert.True(t, ok, "the previous keys are kept")
+}
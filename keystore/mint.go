@@ -0,0 +1,73 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package keystore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/capabilities"
+)
+
+type MintOptions struct {
+	// Algorithm defaults to AlgorithmSHA256, the secrets are random,
+	// so the slow AlgorithmArgon2ID adds no strength but costs 64 MiB per check
+	Algorithm Algorithm
+	Scopes    *capabilities.CapabilitySet
+	// TTL is the key lifetime, zero means no expiry
+	TTL time.Duration
+}
+
+// Mint generates the new key for the owner and stores its hash in the root,
+// the returned key must be handed to the owner since it can't be restored.
+func Mint(root string, owner string, opts MintOptions) (string, error) {
+	if len(owner) == 0 || strings.ContainsAny(owner, `/\`) || strings.HasPrefix(owner, ".") {
+		return "", fmt.Errorf("invalid key owner: `%s`", owner)
+	}
+
+	algorithm := opts.Algorithm
+	if len(algorithm) == 0 {
+		algorithm = AlgorithmSHA256
+	}
+	if algorithm != AlgorithmArgon2ID && algorithm != AlgorithmSHA256 {
+		return "", fmt.Errorf("unsupported algorithm: %s", algorithm)
+	}
+
+	var expiresAt time.Time
+	if opts.TTL > 0 {
+		expiresAt = time.Now().Add(opts.TTL).UTC().Truncate(time.Second)
+	}
+
+	key, f, err := newKeyFile(algorithm, opts.Scopes, expiresAt)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	// Never overwrite the existing key of the owner
+	fd, err := os.OpenFile(filepath.Join(root, owner), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return "", fmt.Errorf("key of `%s` already exists", owner)
+		}
+		return "", err
+	}
+	if _, err := fd.Write(data); err != nil {
+		fd.Close()
+		return "", err
+	}
+	if err := fd.Close(); err != nil {
+		return "", err
+	}
+	return key, nil
+}
@@ -2,8 +2,10 @@ package protect
 
 import (
 	"net/netip"
+	"sort"
 	"sync"
 	"syscall"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -12,12 +14,35 @@ type ProtectCounter struct {
 	lock      sync.Mutex
 	protector Protector
 	protected map[netip.Addr]int
+	leases    map[uint64]*Lease
+	leaseID   uint64
+}
+
+// Lease holds the protection of the addresses on behalf of the owner
+// until it is released or expired.
+type Lease struct {
+	counter *ProtectCounter
+	id      uint64
+	owner   string
+	addrs   []netip.Addr
+	expires time.Time
+	timer   *time.Timer
+}
+
+// ProtectedAddr describes the protected address for debugging.
+type ProtectedAddr struct {
+	Addr netip.Addr
+	// Count is the number of references including the ones without the lease.
+	Count int
+	// Owners of the leases holding the address.
+	Owners []string
 }
 
 func WithProtectCounter(protector Protector) *ProtectCounter {
 	return &ProtectCounter{
 		protector: protector,
 		protected: make(map[netip.Addr]int),
+		leases:    make(map[uint64]*Lease),
 	}
 }
 
@@ -29,14 +54,116 @@ func (pc *ProtectCounter) ProtectAddresses(addrs []netip.Addr) error {
 	pc.lock.Lock()
 	defer pc.lock.Unlock()
 
+	return pc.protect(addrs)
+}
+
+func (pc *ProtectCounter) UnprotectAddresses(addrs []netip.Addr) error {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	return pc.unprotect(addrs)
+}
+
+// ProtectLease protects the addresses until the returned lease is released,
+// the owner is released with ReleaseOwner or the ttl passes. Zero ttl means no expiry.
+func (pc *ProtectCounter) ProtectLease(owner string, ttl time.Duration, addrs []netip.Addr) (*Lease, error) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	if err := pc.protect(addrs); err != nil {
+		return nil, err
+	}
+
+	pc.leaseID++
+	lease := &Lease{
+		counter: pc,
+		id:      pc.leaseID,
+		owner:   owner,
+		addrs:   append([]netip.Addr(nil), addrs...),
+	}
+	pc.leases[lease.id] = lease
+	lease.setTTL(ttl)
+
+	return lease, nil
+}
+
+// ReleaseOwner releases all the leases of the owner, e.g. when the owner has crashed.
+func (pc *ProtectCounter) ReleaseOwner(owner string) error {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	var unprotect []netip.Addr
+	for _, lease := range pc.leases {
+		if lease.owner == owner {
+			unprotect = append(unprotect, pc.dropLease(lease)...)
+		}
+	}
+
+	return pc.unprotect(unprotect)
+}
+
+// Protected lists the currently protected addresses with the lease owners.
+func (pc *ProtectCounter) Protected() []ProtectedAddr {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	owners := make(map[netip.Addr]map[string]struct{})
+	for _, lease := range pc.leases {
+		for _, addr := range lease.addrs {
+			if owners[addr] == nil {
+				owners[addr] = make(map[string]struct{})
+			}
+			owners[addr][lease.owner] = struct{}{}
+		}
+	}
+
+	result := make([]ProtectedAddr, 0, len(pc.protected))
+	for addr, count := range pc.protected {
+		info := ProtectedAddr{Addr: addr, Count: count}
+		for owner := range owners[addr] {
+			info.Owners = append(info.Owners, owner)
+		}
+		sort.Strings(info.Owners)
+		result = append(result, info)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Addr.Less(result[j].Addr)
+	})
+
+	return result
+}
+
+func (pc *ProtectCounter) UnprotectAll() {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	for _, lease := range pc.leases {
+		pc.dropLease(lease)
+	}
+
+	protected := make([]netip.Addr, 0)
+	for addr := range pc.protected {
+		protected = append(protected, addr)
+	}
+
+	err := pc.protector.UnprotectAddresses(protected)
+	if err != nil {
+		zap.L().Error("Failed to unprotect addresses", zap.Error(err), zap.Any("aderesses", protected))
+	}
+	pc.protected = make(map[netip.Addr]int)
+}
+
+func (pc *ProtectCounter) protect(addrs []netip.Addr) error {
 	unprotected := make([]netip.Addr, 0)
+	seen := make(map[netip.Addr]struct{}, len(addrs))
 	for _, addr := range addrs {
 		_, isProtected := pc.protected[addr]
-		if isProtected {
+		_, isSeen := seen[addr]
+		if isProtected || isSeen {
 			continue
-		} else {
-			unprotected = append(unprotected, addr)
 		}
+		seen[addr] = struct{}{}
+		unprotected = append(unprotected, addr)
 	}
 
 	err := pc.protector.ProtectAddresses(unprotected)
@@ -51,44 +178,113 @@ func (pc *ProtectCounter) ProtectAddresses(addrs []netip.Addr) error {
 	return nil
 }
 
-func (pc *ProtectCounter) UnprotectAddresses(addrs []netip.Addr) error {
-	pc.lock.Lock()
-	defer pc.lock.Unlock()
+func (pc *ProtectCounter) unprotect(addrs []netip.Addr) error {
+	// The same address may come several times, e.g. from the leases of the same owner,
+	// so the references are counted per address before asking the protector.
+	drop := make(map[netip.Addr]int, len(addrs))
+	for _, addr := range addrs {
+		drop[addr] += 1
+	}
 
 	unprotect := make([]netip.Addr, 0)
 	for _, addr := range addrs {
-		count := pc.protected[addr]
-		if count == 1 {
-			unprotect = append(unprotect, addr)
-		} else {
+		n, ok := drop[addr]
+		if !ok {
 			continue
 		}
-	}
-
-	err := pc.protector.UnprotectAddresses(unprotect)
+		if count, isProtected := pc.protected[addr]; isProtected && count <= n {
+			unprotect = append(unprotect, addr)
+		}
 
-	for _, addr := range addrs {
-		pc.protected[addr] -= 1
-		if pc.protected[addr] == 0 {
+		pc.protected[addr] -= n
+		if pc.protected[addr] <= 0 {
 			delete(pc.protected, addr)
 		}
+		delete(drop, addr)
 	}
 
-	return err
+	return pc.protector.UnprotectAddresses(unprotect)
 }
 
-func (pc *ProtectCounter) UnprotectAll() {
+// dropLease forgets the lease and returns the addresses to unprotect,
+// nil if the lease has been dropped already.
+func (pc *ProtectCounter) dropLease(lease *Lease) []netip.Addr {
+	if _, ok := pc.leases[lease.id]; !ok {
+		return nil
+	}
+	delete(pc.leases, lease.id)
+	if lease.timer != nil {
+		lease.timer.Stop()
+		lease.timer = nil
+	}
+	return lease.addrs
+}
+
+func (pc *ProtectCounter) expire(lease *Lease) {
 	pc.lock.Lock()
 	defer pc.lock.Unlock()
 
-	protected := make([]netip.Addr, 0)
-	for addr := range pc.protected {
-		protected = append(protected, addr)
+	// the lease may be renewed while the timer was firing
+	if lease.expires.IsZero() || time.Now().Before(lease.expires) {
+		return
 	}
 
-	err := pc.protector.UnprotectAddresses(protected)
+	err := pc.unprotect(pc.dropLease(lease))
 	if err != nil {
-		zap.L().Error("Failed to unprotect addresses", zap.Error(err), zap.Any("aderesses", protected))
+		zap.L().Error("Failed to unprotect expired lease", zap.Error(err), zap.String("owner", lease.owner), zap.Any("addresses", lease.addrs))
 	}
-	pc.protected = make(map[netip.Addr]int)
+}
+
+func (l *Lease) Owner() string {
+	return l.owner
+}
+
+func (l *Lease) Addresses() []netip.Addr {
+	return append([]netip.Addr(nil), l.addrs...)
+}
+
+// Expires returns the zero time if the lease never expires.
+func (l *Lease) Expires() time.Time {
+	l.counter.lock.Lock()
+	defer l.counter.lock.Unlock()
+
+	return l.expires
+}
+
+// Renew extends the lease for ttl from now, zero ttl removes the expiry.
+// It returns false if the lease has been released or expired already.
+func (l *Lease) Renew(ttl time.Duration) bool {
+	l.counter.lock.Lock()
+	defer l.counter.lock.Unlock()
+
+	if _, ok := l.counter.leases[l.id]; !ok {
+		return false
+	}
+	l.setTTL(ttl)
+	return true
+}
+
+// Release drops the references held by the lease, releasing twice is a no-op.
+func (l *Lease) Release() error {
+	l.counter.lock.Lock()
+	defer l.counter.lock.Unlock()
+
+	return l.counter.unprotect(l.counter.dropLease(l))
+}
+
+func (l *Lease) setTTL(ttl time.Duration) {
+	if l.timer != nil {
+		l.timer.Stop()
+		l.timer = nil
+	}
+
+	if ttl <= 0 {
+		l.expires = time.Time{}
+		return
+	}
+
+	l.expires = time.Now().Add(ttl)
+	l.timer = time.AfterFunc(ttl, func() {
+		l.counter.expire(l)
+	})
 }
@@ -4,6 +4,7 @@ import (
 	"net/netip"
 	"syscall"
 	"testing"
+	"time"
 )
 
 type protector struct {
@@ -104,3 +105,149 @@ func TestCounter(t *testing.T) {
 	testOnce(t, p, c, 0)
 	testOnce(t, p, c, 1)
 }
+
+func newTestCounter() (*protector, *ProtectCounter) {
+	p := &protector{
+		protects:   make(map[netip.Addr]int),
+		unprotects: make(map[netip.Addr]int),
+	}
+
+	return p, WithProtectCounter(p)
+}
+
+func testProtected(t *testing.T, c *ProtectCounter, addr string, count int, owners ...string) {
+	for _, info := range c.Protected() {
+		if info.Addr != netip.MustParseAddr(addr) {
+			continue
+		}
+		if info.Count != count || len(info.Owners) != len(owners) {
+			t.Fatalf("Invalid protection of %s expected %d %v got %d %v", addr, count, owners, info.Count, info.Owners)
+		}
+		for idx, owner := range owners {
+			if info.Owners[idx] != owner {
+				t.Fatalf("Invalid owners of %s expected %v got %v", addr, owners, info.Owners)
+			}
+		}
+		return
+	}
+
+	if count != 0 {
+		t.Fatalf("Address %s is not protected", addr)
+	}
+}
+
+func TestCounterLease(t *testing.T) {
+	p, c := newTestCounter()
+
+	first, err := c.ProtectLease("dns", 0, mkSlice("1.2.3.4", "2.3.4.5"))
+	if err != nil {
+		t.Fatal("Protecting failed")
+	}
+	second, err := c.ProtectLease("proxy", 0, mkSlice("1.2.3.4"))
+	if err != nil {
+		t.Fatal("Protecting failed")
+	}
+	if c.ProtectAddresses(mkSlice("1.2.3.4")) != nil {
+		t.Fatal("Protecting failed")
+	}
+
+	p.testProtects(t, "1.2.3.4", 1)
+	p.testProtects(t, "2.3.4.5", 1)
+	testProtected(t, c, "1.2.3.4", 3, "dns", "proxy")
+	testProtected(t, c, "2.3.4.5", 1, "dns")
+
+	if first.Release() != nil || first.Release() != nil {
+		t.Fatal("Releasing failed")
+	}
+	p.testUnprotects(t, "1.2.3.4", 0)
+	p.testUnprotects(t, "2.3.4.5", 1)
+	testProtected(t, c, "1.2.3.4", 2, "proxy")
+	testProtected(t, c, "2.3.4.5", 0)
+	if first.Renew(time.Minute) {
+		t.Fatal("Released lease must not be renewed")
+	}
+
+	if c.ReleaseOwner("proxy") != nil {
+		t.Fatal("Releasing failed")
+	}
+	testProtected(t, c, "1.2.3.4", 1)
+	if second.Release() != nil {
+		t.Fatal("Releasing failed")
+	}
+	testProtected(t, c, "1.2.3.4", 1)
+
+	if c.UnprotectAddresses(mkSlice("1.2.3.4")) != nil {
+		t.Fatal("Unprotecting failed")
+	}
+	p.testUnprotects(t, "1.2.3.4", 1)
+	if len(c.Protected()) != 0 {
+		t.Fatalf("Nothing must be protected, got %v", c.Protected())
+	}
+}
+
+func TestCounterReleaseOwner(t *testing.T) {
+	p, c := newTestCounter()
+
+	for i := 0; i < 2; i++ {
+		if _, err := c.ProtectLease("dns", 0, mkSlice("1.2.3.4")); err != nil {
+			t.Fatal("Protecting failed")
+		}
+	}
+	p.testProtects(t, "1.2.3.4", 1)
+	testProtected(t, c, "1.2.3.4", 2, "dns")
+
+	if c.ReleaseOwner("dns") != nil {
+		t.Fatal("Releasing failed")
+	}
+	p.testUnprotects(t, "1.2.3.4", 1)
+	if len(c.Protected()) != 0 {
+		t.Fatalf("Nothing must be protected, got %v", c.Protected())
+	}
+
+	// the duplicates in a single call are counted too
+	if c.ProtectAddresses(mkSlice("2.3.4.5", "2.3.4.5")) != nil {
+		t.Fatal("Protecting failed")
+	}
+	p.testProtects(t, "2.3.4.5", 1)
+	if c.UnprotectAddresses(mkSlice("2.3.4.5", "2.3.4.5")) != nil {
+		t.Fatal("Unprotecting failed")
+	}
+	p.testUnprotects(t, "2.3.4.5", 1)
+}
+
+func TestCounterLeaseExpiry(t *testing.T) {
+	p, c := newTestCounter()
+
+	short, err := c.ProtectLease("crashed", 20*time.Millisecond, mkSlice("1.2.3.4"))
+	if err != nil {
+		t.Fatal("Protecting failed")
+	}
+	renewed, err := c.ProtectLease("alive", 20*time.Millisecond, mkSlice("2.3.4.5"))
+	if err != nil {
+		t.Fatal("Protecting failed")
+	}
+	if short.Expires().IsZero() {
+		t.Fatal("Lease must expire")
+	}
+	if !renewed.Renew(0) {
+		t.Fatal("Renewing failed")
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for len(c.Protected()) > 1 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	testProtected(t, c, "1.2.3.4", 0)
+	testProtected(t, c, "2.3.4.5", 1, "alive")
+	p.testUnprotects(t, "1.2.3.4", 1)
+	if short.Release() != nil {
+		t.Fatal("Releasing expired lease failed")
+	}
+
+	c.UnprotectAll()
+	p.testUnprotects(t, "2.3.4.5", 1)
+	if renewed.Renew(time.Minute) {
+		t.Fatal("Lease must be dropped by UnprotectAll")
+	}
+}
@@ -0,0 +1,212 @@
+package protect
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"syscall"
+
+	"github.com/vishvananda/netlink"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultTable    = unix.RT_TABLE_MAIN
+	defaultPriority = 100
+)
+
+// LinuxConfig describes how the traffic is routed around the tunnel.
+type LinuxConfig struct {
+	// Mark is set as SO_MARK on the protected sockets,
+	// the traffic with this mark is routed via Table. Zero disables marking.
+	Mark uint32
+	// Interface the protected sockets are bound to with SO_BINDTODEVICE, if set.
+	Interface string
+	// Table is the routing table bypassing the tunnel, the main table by default.
+	Table int
+	// Priority of the policy routing rules, must be lower than the tunnel rules priority.
+	Priority int
+}
+
+// Linux protects sockets by the firewall mark or by binding them to the interface,
+// and protects destinations by the policy routing rules looking up the bypass table.
+type Linux struct {
+	config LinuxConfig
+
+	lock      sync.Mutex
+	markRules []*netlink.Rule
+	rules     map[netip.Addr]*netlink.Rule
+}
+
+func NewLinuxProtector(config LinuxConfig) (*Linux, error) {
+	if config.Table == 0 {
+		config.Table = defaultTable
+	}
+	if config.Priority == 0 {
+		config.Priority = defaultPriority
+	}
+	if config.Interface != "" {
+		if _, err := net.InterfaceByName(config.Interface); err != nil {
+			return nil, fmt.Errorf("protect: interface %s: %w", config.Interface, err)
+		}
+	}
+
+	p := &Linux{
+		config: config,
+		rules:  make(map[netip.Addr]*netlink.Rule),
+	}
+
+	if config.Mark != 0 {
+		for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			rule := p.newRule()
+			rule.Family = family
+			rule.Mark = int(config.Mark)
+			if err := ruleAdd(rule); err != nil {
+				p.Close()
+				return nil, fmt.Errorf("protect: add fwmark rule: %w", err)
+			}
+			p.markRules = append(p.markRules, rule)
+		}
+	}
+
+	return p, nil
+}
+
+func (p *Linux) SocketProtector() func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		var opErr error
+		err := conn.Control(func(fd uintptr) {
+			if p.config.Mark != 0 {
+				opErr = unix.SetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK, int(p.config.Mark))
+				if opErr != nil {
+					opErr = fmt.Errorf("protect: set SO_MARK: %w", opErr)
+					return
+				}
+			}
+			if p.config.Interface != "" {
+				opErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, p.config.Interface)
+				if opErr != nil {
+					opErr = fmt.Errorf("protect: set SO_BINDTODEVICE: %w", opErr)
+				}
+			}
+		})
+		if err != nil {
+			return err
+		}
+		return opErr
+	}
+}
+
+func (p *Linux) ProtectAddresses(addrs []netip.Addr) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if _, ok := p.rules[addr]; ok {
+			continue
+		}
+
+		rule := p.newRule()
+		rule.Dst = addrNet(addr)
+		if err := ruleAdd(rule); err != nil {
+			return fmt.Errorf("protect: add rule for %s: %w", addr, err)
+		}
+		p.rules[addr] = rule
+	}
+
+	return nil
+}
+
+func (p *Linux) UnprotectAddresses(addrs []netip.Addr) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs []error
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		rule, ok := p.rules[addr]
+		if !ok {
+			continue
+		}
+		if err := ruleDel(rule); err != nil {
+			errs = append(errs, fmt.Errorf("protect: delete rule for %s: %w", addr, err))
+			continue
+		}
+		delete(p.rules, addr)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Protected returns the addresses having the routing rule installed.
+func (p *Linux) Protected() []netip.Addr {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	addrs := make([]netip.Addr, 0, len(p.rules))
+	for addr := range p.rules {
+		addrs = append(addrs, addr)
+	}
+	return addrs
+}
+
+// Close removes all the routing rules installed by the protector.
+func (p *Linux) Close() error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	var errs []error
+	for addr, rule := range p.rules {
+		if err := ruleDel(rule); err != nil {
+			errs = append(errs, fmt.Errorf("protect: delete rule for %s: %w", addr, err))
+		}
+	}
+	p.rules = make(map[netip.Addr]*netlink.Rule)
+
+	for _, rule := range p.markRules {
+		if err := ruleDel(rule); err != nil {
+			errs = append(errs, fmt.Errorf("protect: delete fwmark rule: %w", err))
+		}
+	}
+	p.markRules = nil
+
+	err := errors.Join(errs...)
+	if err != nil {
+		zap.L().Error("Failed to remove protection rules", zap.Error(err))
+	}
+	return err
+}
+
+func (p *Linux) newRule() *netlink.Rule {
+	rule := netlink.NewRule()
+	rule.Table = p.config.Table
+	rule.Priority = p.config.Priority
+	return rule
+}
+
+func addrNet(addr netip.Addr) *net.IPNet {
+	bits := addr.BitLen()
+	return &net.IPNet{IP: net.IP(addr.AsSlice()), Mask: net.CIDRMask(bits, bits)}
+}
+
+// ruleAdd treats the rule left by the previous run as added.
+func ruleAdd(rule *netlink.Rule) error {
+	err := netlink.RuleAdd(rule)
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+// ruleDel treats the rule removed by someone else as deleted.
+func ruleDel(rule *netlink.Rule) error {
+	err := netlink.RuleDel(rule)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	return err
+}
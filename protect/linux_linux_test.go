@@ -0,0 +1,128 @@
+package protect
+
+import (
+	"net"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// withNetns runs the test in a fresh network namespace,
+// netlink and sockets use the namespace of the locked thread.
+func withNetns(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("network namespaces require root")
+	}
+
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	require.NoError(t, err)
+
+	ns, err := netns.New()
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Skipf("failed to create network namespace: %v", err)
+	}
+
+	t.Cleanup(func() {
+		_ = netns.Set(origin)
+		_ = ns.Close()
+		_ = origin.Close()
+		runtime.UnlockOSThread()
+	})
+
+	lo, err := netlink.LinkByName("lo")
+	require.NoError(t, err)
+	require.NoError(t, netlink.LinkSetUp(lo))
+}
+
+func findRules(t *testing.T, family int, match func(r netlink.Rule) bool) []netlink.Rule {
+	rules, err := netlink.RuleList(family)
+	require.NoError(t, err)
+
+	var found []netlink.Rule
+	for _, r := range rules {
+		if match(r) {
+			found = append(found, r)
+		}
+	}
+	return found
+}
+
+func TestLinux_rules(t *testing.T) {
+	withNetns(t)
+
+	p, err := NewLinuxProtector(LinuxConfig{Mark: 0x1234, Table: 100, Priority: 50})
+	require.NoError(t, err)
+
+	byMark := func(r netlink.Rule) bool { return r.Mark == 0x1234 && r.Table == 100 && r.Priority == 50 }
+	assert.Len(t, findRules(t, netlink.FAMILY_V4, byMark), 1)
+	assert.Len(t, findRules(t, netlink.FAMILY_V6, byMark), 1)
+
+	addrs := mkSlice("1.2.3.4", "2001:db8::1")
+	require.NoError(t, p.ProtectAddresses(addrs))
+	// protecting twice is a no-op
+	require.NoError(t, p.ProtectAddresses(mkSlice("1.2.3.4")))
+	assert.ElementsMatch(t, addrs, p.Protected())
+
+	byDst := func(dst string) func(r netlink.Rule) bool {
+		return func(r netlink.Rule) bool {
+			return r.Dst != nil && r.Dst.String() == dst && r.Table == 100
+		}
+	}
+	assert.Len(t, findRules(t, netlink.FAMILY_V4, byDst("1.2.3.4/32")), 1)
+	assert.Len(t, findRules(t, netlink.FAMILY_V6, byDst("2001:db8::1/128")), 1)
+
+	require.NoError(t, p.UnprotectAddresses(mkSlice("1.2.3.4", "5.6.7.8")))
+	assert.Empty(t, findRules(t, netlink.FAMILY_V4, byDst("1.2.3.4/32")))
+	assert.Equal(t, mkSlice("2001:db8::1"), p.Protected())
+
+	require.NoError(t, p.Close())
+	assert.Empty(t, findRules(t, netlink.FAMILY_V6, byDst("2001:db8::1/128")))
+	assert.Empty(t, findRules(t, netlink.FAMILY_V4, byMark))
+	assert.Empty(t, findRules(t, netlink.FAMILY_V6, byMark))
+}
+
+func TestLinux_socketProtector(t *testing.T) {
+	withNetns(t)
+
+	p, err := NewLinuxProtector(LinuxConfig{Mark: 0x4321, Interface: "lo"})
+	require.NoError(t, err)
+	defer p.Close()
+
+	dialer := net.Dialer{Control: p.SocketProtector()}
+	conn, err := dialer.Dial("udp4", "127.0.0.1:53")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	raw, err := conn.(*net.UDPConn).SyscallConn()
+	require.NoError(t, err)
+
+	var mark int
+	var device string
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		mark, opErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_MARK)
+		if opErr != nil {
+			return
+		}
+		device, opErr = unix.GetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE)
+	})
+	require.NoError(t, err)
+	require.NoError(t, opErr)
+	assert.Equal(t, 0x4321, mark)
+	assert.Equal(t, "lo", device)
+}
+
+func TestLinux_unknownInterface(t *testing.T) {
+	_, err := NewLinuxProtector(LinuxConfig{Interface: "no-such-iface0"})
+	assert.Error(t, err)
+}
+
+var _ Protector = (*Linux)(nil)
@@ -0,0 +1,48 @@
+//go:build !linux
+// +build !linux
+
+package protect
+
+import (
+	"errors"
+	"net/netip"
+	"syscall"
+)
+
+var errNotSupported = errors.New("protect: linux protector is not supported on this platform")
+
+// LinuxConfig describes how the traffic is routed around the tunnel.
+type LinuxConfig struct {
+	Mark      uint32
+	Interface string
+	Table     int
+	Priority  int
+}
+
+type Linux struct{}
+
+func NewLinuxProtector(config LinuxConfig) (*Linux, error) {
+	return nil, errNotSupported
+}
+
+func (p *Linux) SocketProtector() func(network, address string, conn syscall.RawConn) error {
+	return func(network, address string, conn syscall.RawConn) error {
+		return errNotSupported
+	}
+}
+
+func (p *Linux) ProtectAddresses([]netip.Addr) error {
+	return errNotSupported
+}
+
+func (p *Linux) UnprotectAddresses([]netip.Addr) error {
+	return errNotSupported
+}
+
+func (p *Linux) Protected() []netip.Addr {
+	return nil
+}
+
+func (p *Linux) Close() error {
+	return nil
+}
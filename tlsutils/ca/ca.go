@@ -0,0 +1,311 @@
+// Package ca issues the short-lived leaf certificates with the tlsutils CA sign
+// and publishes their revocation status as the CRL and the OCSP responses.
+package ca
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/tlsutils"
+	"golang.org/x/crypto/ocsp"
+)
+
+var (
+	ErrRevoked = errors.New("certificate is revoked")
+	ErrExpired = errors.New("certificate is expired")
+	ErrIssuer  = errors.New("certificate is not issued by the ca")
+	// ErrUnverified is returned by Renew if the peer certificate is not verified by the TLS handshake.
+	ErrUnverified = errors.New("peer certificate is not verified")
+)
+
+const (
+	defaultValidity    = 24 * time.Hour
+	defaultCRLValidity = time.Hour
+	backdate           = 5 * time.Minute
+)
+
+type Config struct {
+	// Validity of the issued certificates, 24h by default.
+	Validity time.Duration `yaml:"validity"`
+	// CRLValidity is the NextUpdate of the CRL and the OCSP responses, 1h by default.
+	CRLValidity time.Duration `yaml:"crl_validity"`
+	// CRLURL and OCSPURL are put into the issued certificates if set.
+	CRLURL  string `yaml:"crl_url"`
+	OCSPURL string `yaml:"ocsp_url"`
+}
+
+type CA struct {
+	cert   *x509.Certificate
+	signer crypto.Signer
+	store  Store
+	config Config
+
+	crlLock    sync.Mutex
+	crl        []byte
+	crlUpdated time.Time
+}
+
+// New creates the CA issuing with the sign generated with tlsutils.WithCA.
+func New(sign *tlsutils.Sign, store Store, config Config) (*CA, error) {
+	if sign == nil || len(sign.CertPem) == 0 {
+		return nil, errors.New("ca sign is not set")
+	}
+	// Parse the PEM, so the CA doesn't depend on how the Cert is filled
+	block, _ := pem.Decode(sign.CertPem)
+	if block == nil || block.Type != string(tlsutils.PemBlockTypeCertificate) {
+		return nil, errors.New("cannot parse ca cert PEM block")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse ca cert: %w", err)
+	}
+	if !cert.IsCA || cert.KeyUsage&x509.KeyUsageCertSign == 0 {
+		return nil, errors.New("sign is not a ca")
+	}
+	signer, ok := sign.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported ca private key: %T", sign.PrivateKey)
+	}
+	if store == nil {
+		return nil, errors.New("ca store is not set")
+	}
+	if config.Validity <= 0 {
+		config.Validity = defaultValidity
+	}
+	if config.CRLValidity <= 0 {
+		config.CRLValidity = defaultCRLValidity
+	}
+
+	return &CA{
+		cert:   cert,
+		signer: signer,
+		store:  store,
+		config: config,
+	}, nil
+}
+
+// Certificate returns the CA certificate, e.g. for the peers' cert pool.
+func (ca *CA) Certificate() *x509.Certificate {
+	return ca.cert
+}
+
+// Issue signs the leaf certificate for the subject and SANs of the CSR.
+// The caller is responsible for the CSR subject authorization.
+func (ca *CA) Issue(ctx context.Context, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid csr signature: %w", err)
+	}
+
+	template := &x509.Certificate{
+		Subject:     csr.Subject,
+		DNSNames:    csr.DNSNames,
+		IPAddresses: csr.IPAddresses,
+		URIs:        csr.URIs,
+	}
+	return ca.issue(ctx, template, csr.PublicKey)
+}
+
+// IssuePEM is Issue for the PEM encoded CSR, the PEM encoded certificate is returned.
+func (ca *CA) IssuePEM(ctx context.Context, csrPem []byte) ([]byte, error) {
+	block, _ := pem.Decode(csrPem)
+	if block == nil || block.Type != tlsutils.PemBlockTypeCsr {
+		return nil, errors.New("cannot parse csr PEM block")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse csr: %w", err)
+	}
+
+	cert, err := ca.Issue(ctx, csr)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: string(tlsutils.PemBlockTypeCertificate), Bytes: cert.Raw}), nil
+}
+
+// Renew issues the certificate with the same subject and SANs as the current one
+// for the CSR key. The current certificate is the leaf of the verified chain
+// of the mTLS connection, e.g. http.Request.TLS, so the caller has proven
+// the possession of its private key in the handshake; the certificates presented
+// otherwise are public and are not accepted. The current certificate must be valid
+// and not revoked, so the short-lived certificates are renewed by their holders before NotAfter.
+func (ca *CA) Renew(ctx context.Context, peer *tls.ConnectionState, csr *x509.CertificateRequest) (*x509.Certificate, error) {
+	if peer == nil || len(peer.VerifiedChains) == 0 || len(peer.VerifiedChains[0]) == 0 {
+		return nil, ErrUnverified
+	}
+	current := peer.VerifiedChains[0][0]
+	if err := current.CheckSignatureFrom(ca.cert); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrIssuer, err)
+	}
+	if !time.Now().Before(current.NotAfter) {
+		return nil, ErrExpired
+	}
+	if err := ca.Verify(current); err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("invalid csr signature: %w", err)
+	}
+
+	template := &x509.Certificate{
+		Subject:     current.Subject,
+		DNSNames:    current.DNSNames,
+		IPAddresses: current.IPAddresses,
+		URIs:        current.URIs,
+	}
+	return ca.issue(ctx, template, csr.PublicKey)
+}
+
+// Revoke revokes the certificate, reason is the RFC 5280 code,
+// e.g. ocsp.CessationOfOperation for the decommissioned node.
+func (ca *CA) Revoke(ctx context.Context, serial *big.Int, reason int) error {
+	if err := ca.store.Revoke(ctx, serial, time.Now().UTC(), reason); err != nil {
+		return err
+	}
+
+	ca.crlLock.Lock()
+	ca.crl = nil
+	ca.crlLock.Unlock()
+	return nil
+}
+
+// Verify returns ErrRevoked for the revoked certificate and ErrNotFound for the unknown one,
+// it's meant for tlsutils.WithPeerVerifier.
+func (ca *CA) Verify(cert *x509.Certificate) error {
+	record, err := ca.store.Get(context.Background(), cert.SerialNumber)
+	if err != nil {
+		return err
+	}
+	if record.Revoked() {
+		return ErrRevoked
+	}
+	return nil
+}
+
+// CRL returns the DER encoded CRL, it's cached for the half of CRLValidity.
+func (ca *CA) CRL(ctx context.Context) ([]byte, error) {
+	ca.crlLock.Lock()
+	defer ca.crlLock.Unlock()
+
+	now := time.Now().UTC()
+	if ca.crl != nil && now.Sub(ca.crlUpdated) < ca.config.CRLValidity/2 {
+		return ca.crl, nil
+	}
+
+	revoked, err := ca.store.ListRevoked(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]x509.RevocationListEntry, 0, len(revoked))
+	for _, r := range revoked {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   r.Serial,
+			RevocationTime: r.RevokedAt,
+			ReasonCode:     r.Reason,
+		})
+	}
+
+	template := &x509.RevocationList{
+		RevokedCertificateEntries: entries,
+		// The CRL number must grow, the time does across restarts too
+		Number:     big.NewInt(now.UnixNano()),
+		ThisUpdate: now,
+		NextUpdate: now.Add(ca.config.CRLValidity),
+	}
+	crl, err := x509.CreateRevocationList(rand.Reader, template, ca.cert, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crl: %w", err)
+	}
+
+	ca.crl = crl
+	ca.crlUpdated = now
+	return crl, nil
+}
+
+// OCSPResponse returns the DER encoded OCSP response for the DER encoded request.
+func (ca *CA) OCSPResponse(ctx context.Context, request []byte) ([]byte, error) {
+	req, err := ocsp.ParseRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: req.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(ca.config.CRLValidity),
+	}
+
+	record, err := ca.store.Get(ctx, req.SerialNumber)
+	switch {
+	case errors.Is(err, ErrNotFound):
+		template.Status = ocsp.Unknown
+	case err != nil:
+		return nil, err
+	case record.Revoked():
+		template.Status = ocsp.Revoked
+		template.RevokedAt = record.RevokedAt
+		template.RevocationReason = record.Reason
+	}
+
+	return ocsp.CreateResponse(ca.cert, ca.cert, template, ca.signer)
+}
+
+func (ca *CA) issue(ctx context.Context, template *x509.Certificate, publicKey crypto.PublicKey) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	template.SerialNumber = serial
+	template.NotBefore = now.Add(-backdate)
+	template.NotAfter = now.Add(ca.config.Validity)
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	if _, ok := publicKey.(*rsa.PublicKey); ok {
+		template.KeyUsage |= x509.KeyUsageKeyEncipherment
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth}
+	template.BasicConstraintsValid = true
+	if len(ca.config.CRLURL) > 0 {
+		template.CRLDistributionPoints = []string{ca.config.CRLURL}
+	}
+	if len(ca.config.OCSPURL) > 0 {
+		template.OCSPServer = []string{ca.config.OCSPURL}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, publicKey, ca.signer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ca.store.Save(ctx, &Record{
+		Serial:    cert.SerialNumber,
+		Subject:   cert.Subject.String(),
+		NotBefore: cert.NotBefore,
+		NotAfter:  cert.NotAfter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
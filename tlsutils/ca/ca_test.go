@@ -0,0 +1,165 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/tlsutils"
+	"golang.org/x/crypto/ocsp"
+)
+
+func newTestCA(t *testing.T, store Store) *CA {
+	sign, err := tlsutils.GenerateSign(tlsutils.WithCA(), tlsutils.WithRsaSigner(2048))
+	require.NoError(t, err)
+	ca, err := New(sign, store, Config{Validity: time.Hour})
+	require.NoError(t, err)
+	return ca
+}
+
+func newTestCSR(t *testing.T, name string) *x509.CertificateRequest {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: name},
+		DNSNames: []string{name},
+	}, key)
+	require.NoError(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	require.NoError(t, err)
+	return csr
+}
+
+// verifiedPeer is the state of the mTLS connection with the client certificate verified.
+func verifiedPeer(ca *CA, cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert, ca.Certificate()}},
+	}
+}
+
+func TestCA(t *testing.T) {
+	ca := newTestCA(t, NewMemoryStore())
+	ctx := context.Background()
+
+	cert, err := ca.Issue(ctx, newTestCSR(t, "node-1.mesh"))
+	require.NoError(t, err)
+	assert.Equal(t, "node-1.mesh", cert.Subject.CommonName)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), cert.NotAfter, time.Minute)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate())
+	_, err = cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "node-1.mesh", KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	require.NoError(t, err)
+	assert.NoError(t, ca.Verify(cert))
+
+	// the certificate must be verified by the handshake, not just presented
+	_, err = ca.Renew(ctx, &tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}, newTestCSR(t, "node-1.mesh"))
+	assert.ErrorIs(t, err, ErrUnverified)
+
+	renewed, err := ca.Renew(ctx, verifiedPeer(ca, cert), newTestCSR(t, "other.mesh"))
+	require.NoError(t, err)
+	assert.Equal(t, "node-1.mesh", renewed.Subject.CommonName, "the identity is kept on renewal")
+	assert.NotEqual(t, cert.SerialNumber, renewed.SerialNumber)
+
+	require.NoError(t, ca.Revoke(ctx, cert.SerialNumber, ocsp.CessationOfOperation))
+	assert.ErrorIs(t, ca.Verify(cert), ErrRevoked)
+	assert.NoError(t, ca.Verify(renewed))
+	_, err = ca.Renew(ctx, verifiedPeer(ca, cert), newTestCSR(t, "node-1.mesh"))
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	// the foreign certificate is neither renewed nor verified
+	other := newTestCA(t, NewMemoryStore())
+	foreign, err := other.Issue(ctx, newTestCSR(t, "node-1.mesh"))
+	require.NoError(t, err)
+	_, err = ca.Renew(ctx, verifiedPeer(other, foreign), newTestCSR(t, "node-1.mesh"))
+	assert.ErrorIs(t, err, ErrIssuer)
+	assert.ErrorIs(t, ca.Verify(foreign), ErrNotFound)
+}
+
+func TestCA_handlers(t *testing.T) {
+	ca := newTestCA(t, NewMemoryStore())
+	ctx := context.Background()
+
+	router := chi.NewRouter()
+	ca.Mount(router, "/ca")
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	revoked, err := ca.Issue(ctx, newTestCSR(t, "node-1.mesh"))
+	require.NoError(t, err)
+	valid, err := ca.Issue(ctx, newTestCSR(t, "node-2.mesh"))
+	require.NoError(t, err)
+	require.NoError(t, ca.Revoke(ctx, revoked.SerialNumber, ocsp.CessationOfOperation))
+
+	checker, err := NewCRLChecker(CRLCheckerConfig{URL: server.URL + "/ca/crl"}, ca.Certificate(), server.Client())
+	require.NoError(t, err)
+	defer checker.Close()
+	assert.ErrorIs(t, checker.Verify(revoked), ErrRevoked)
+	assert.NoError(t, checker.Verify(valid))
+
+	// the CRL signed by another CA is rejected, nothing is accepted without the CRL
+	foreign, err := NewCRLChecker(CRLCheckerConfig{URL: server.URL + "/ca/crl"}, newTestCA(t, NewMemoryStore()).Certificate(), server.Client())
+	assert.Error(t, err)
+	assert.ErrorIs(t, foreign.Verify(valid), ErrCRLStale)
+	foreign.Close()
+
+	for cert, status := range map[*x509.Certificate]int{revoked: ocsp.Revoked, valid: ocsp.Good} {
+		request, err := ocsp.CreateRequest(cert, ca.Certificate(), nil)
+		require.NoError(t, err)
+		resp, err := server.Client().Post(server.URL+"/ca/ocsp", "application/ocsp-request", bytes.NewReader(request))
+		require.NoError(t, err)
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		require.NoError(t, err)
+
+		response, err := ocsp.ParseResponseForCert(body, cert, ca.Certificate())
+		require.NoError(t, err)
+		assert.Equal(t, status, response.Status)
+	}
+
+	resp, err := server.Client().Get(server.URL + "/ca/ocsp/garbage")
+	require.NoError(t, err)
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, ocsp.MalformedRequestErrorResponse, body)
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ca.json")
+	store, err := NewFileStore(path)
+	require.NoError(t, err)
+	ca := newTestCA(t, store)
+	ctx := context.Background()
+
+	cert, err := ca.Issue(ctx, newTestCSR(t, "node-1.mesh"))
+	require.NoError(t, err)
+	require.NoError(t, ca.Revoke(ctx, cert.SerialNumber, ocsp.KeyCompromise))
+
+	store, err = NewFileStore(path)
+	require.NoError(t, err)
+	record, err := store.Get(ctx, cert.SerialNumber)
+	require.NoError(t, err)
+	assert.True(t, record.Revoked())
+	assert.Equal(t, ocsp.KeyCompromise, record.Reason)
+	assert.Equal(t, "CN=node-1.mesh", record.Subject)
+
+	require.NoError(t, store.DeleteExpired(ctx, time.Now().Add(2*time.Hour)))
+	_, err = store.Get(ctx, cert.SerialNumber)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,173 @@
+package ca
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+var ErrCRLStale = errors.New("crl is stale")
+
+const maxCRLSize = 10 << 20
+
+type CRLCheckerConfig struct {
+	URL string `yaml:"url"`
+	// RefreshInterval is the period of the CRL refetch, 5m by default.
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// MaxStale is how long the CRL is used after its NextUpdate, 1h by default.
+	MaxStale time.Duration `yaml:"max_stale"`
+	Timeout  time.Duration `yaml:"timeout"`
+}
+
+// CRLChecker verifies the peer certificates against the CRL published by the CA.Mount
+// on the nodes that have no access to the CA store. The last fetched CRL is kept on the failures,
+// it fails closed: no certificate is accepted until the CRL is fetched or once it's stale.
+type CRLChecker struct {
+	config CRLCheckerConfig
+	issuer *x509.Certificate
+	client *http.Client
+
+	lock       sync.RWMutex
+	revoked    map[string]struct{}
+	number     *big.Int
+	nextUpdate time.Time // zero until the CRL is fetched
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// NewCRLChecker fetches the CRL signed by the issuer and starts the background refresh.
+// The checker is created even if the initial fetch fails, the error is returned along.
+func NewCRLChecker(config CRLCheckerConfig, issuer *x509.Certificate, client *http.Client) (*CRLChecker, error) {
+	if len(config.URL) == 0 {
+		return nil, errors.New("crl url is not set")
+	}
+	if issuer == nil {
+		return nil, errors.New("crl issuer is not set")
+	}
+	if config.RefreshInterval <= 0 {
+		config.RefreshInterval = 5 * time.Minute
+	}
+	if config.MaxStale <= 0 {
+		config.MaxStale = time.Hour
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &CRLChecker{
+		config:  config,
+		issuer:  issuer,
+		client:  client,
+		revoked: make(map[string]struct{}),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+
+	err := c.fetch(ctx)
+	go c.run(ctx)
+	return c, err
+}
+
+// Verify returns ErrRevoked for the revoked certificate and ErrCRLStale if there is no fresh CRL,
+// it's meant for tlsutils.WithPeerVerifier.
+func (c *CRLChecker) Verify(cert *x509.Certificate) error {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	if c.nextUpdate.IsZero() || time.Since(c.nextUpdate) > c.config.MaxStale {
+		return ErrCRLStale
+	}
+	if _, ok := c.revoked[cert.SerialNumber.String()]; ok {
+		return ErrRevoked
+	}
+	return nil
+}
+
+func (c *CRLChecker) Close() error {
+	c.cancel()
+	<-c.done
+	return nil
+}
+
+func (c *CRLChecker) run(ctx context.Context) {
+	defer close(c.done)
+
+	ticker := time.NewTicker(c.config.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.fetch(ctx); err != nil && ctx.Err() == nil {
+				zap.L().Warn("Failed to refresh crl, using the stale one", zap.String("url", c.config.URL), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (c *CRLChecker) fetch(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.config.URL, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected crl response status: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return err
+	}
+	crl, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return fmt.Errorf("can't parse crl: %w", err)
+	}
+	if err := crl.CheckSignatureFrom(c.issuer); err != nil {
+		return fmt.Errorf("invalid crl signature: %w", err)
+	}
+
+	revoked := make(map[string]struct{}, len(crl.RevokedCertificateEntries))
+	for _, entry := range crl.RevokedCertificateEntries {
+		revoked[entry.SerialNumber.String()] = struct{}{}
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// Don't let the replayed older CRL unrevoke the certificates
+	if c.number != nil && crl.Number != nil && crl.Number.Cmp(c.number) < 0 {
+		return errors.New("crl number goes backwards")
+	}
+	c.revoked = revoked
+	c.number = crl.Number
+	c.nextUpdate = crl.NextUpdate
+	if c.nextUpdate.IsZero() {
+		// NextUpdate is optional, the CRL is expected to be refetched then
+		c.nextUpdate = time.Now().Add(c.config.RefreshInterval)
+	}
+	return nil
+}
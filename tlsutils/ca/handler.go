@@ -0,0 +1,83 @@
+package ca
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+
+	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ocsp"
+)
+
+const maxOCSPRequestSize = 1 << 14
+
+// Mount registers the CRL and OCSP handlers on the router, e.g. xhttp.Server.Router(),
+// the Config.CRLURL and Config.OCSPURL are expected to point here.
+func (ca *CA) Mount(r chi.Router, prefix string) {
+	r.Method(http.MethodGet, prefix+"/crl", ca.CRLHandler())
+	r.Method(http.MethodPost, prefix+"/ocsp", ca.OCSPHandler())
+	r.Method(http.MethodGet, prefix+"/ocsp/*", ca.OCSPHandler())
+}
+
+// CRLHandler serves the DER encoded CRL.
+func (ca *CA) CRLHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		crl, err := ca.CRL(r.Context())
+		if err != nil {
+			zap.L().Error("Failed to create crl", zap.Error(err))
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pkix-crl")
+		w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(ca.config.CRLValidity.Seconds()/2)))
+		_, _ = w.Write(crl)
+	})
+}
+
+// OCSPHandler serves the RFC 6960 requests, either POST-ed
+// or base64 encoded in the last element of the GET request path.
+func (ca *CA) OCSPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		request, err := readOCSPRequest(r)
+		if err != nil {
+			writeOCSP(w, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+
+		response, err := ca.OCSPResponse(r.Context(), request)
+		if err != nil {
+			var parseErr ocsp.ParseError
+			if errors.As(err, &parseErr) {
+				writeOCSP(w, ocsp.MalformedRequestErrorResponse)
+				return
+			}
+			zap.L().Error("Failed to create ocsp response", zap.Error(err))
+			writeOCSP(w, ocsp.InternalErrorErrorResponse)
+			return
+		}
+		writeOCSP(w, response)
+	})
+}
+
+func readOCSPRequest(r *http.Request) ([]byte, error) {
+	if r.Method == http.MethodPost {
+		return io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+	}
+
+	encoded, err := url.PathUnescape(path.Base(r.URL.EscapedPath()))
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(encoded)
+}
+
+func writeOCSP(w http.ResponseWriter, response []byte) {
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	_, _ = w.Write(response)
+}
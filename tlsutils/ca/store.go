@@ -0,0 +1,176 @@
+package ca
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("certificate not found")
+
+// Record is the issued certificate tracked by the CA.
+type Record struct {
+	Serial    *big.Int  `json:"serial"`
+	Subject   string    `json:"subject"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+	// RevokedAt is zero for the valid certificate
+	RevokedAt time.Time `json:"revoked_at,omitempty"`
+	// Reason is the RFC 5280 revocation reason code, see the golang.org/x/crypto/ocsp constants
+	Reason int `json:"reason,omitempty"`
+}
+
+func (r *Record) Revoked() bool {
+	return !r.RevokedAt.IsZero()
+}
+
+type Store interface {
+	Save(ctx context.Context, record *Record) error
+	// Get returns ErrNotFound for the unknown serial.
+	Get(ctx context.Context, serial *big.Int) (*Record, error)
+	// Revoke keeps the first revocation time and reason.
+	Revoke(ctx context.Context, serial *big.Int, at time.Time, reason int) error
+	// ListRevoked returns the revoked certificates that are not expired yet.
+	ListRevoked(ctx context.Context, now time.Time) ([]*Record, error)
+	// DeleteExpired drops the certificates expired before the time.
+	DeleteExpired(ctx context.Context, before time.Time) error
+}
+
+type fileStore struct {
+	lock    sync.Mutex
+	path    string
+	records map[string]*Record
+}
+
+// NewMemoryStore keeps the records in memory, the revocations are lost on restart.
+func NewMemoryStore() Store {
+	return &fileStore{records: make(map[string]*Record)}
+}
+
+// NewFileStore keeps the records in the JSON file at the path,
+// the file is rewritten on every change.
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{path: path, records: make(map[string]*Record)}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+		return nil, fmt.Errorf("failed to read ca store: %w", err)
+	}
+
+	var records []*Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("failed to parse ca store: %w", err)
+	}
+	for _, r := range records {
+		if r.Serial != nil {
+			s.records[r.Serial.String()] = r
+		}
+	}
+	return s, nil
+}
+
+func (s *fileStore) Save(ctx context.Context, record *Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r := *record
+	s.records[r.Serial.String()] = &r
+	return s.persistLocked()
+}
+
+func (s *fileStore) Get(ctx context.Context, serial *big.Int) (*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.records[serial.String()]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	result := *r
+	return &result, nil
+}
+
+func (s *fileStore) Revoke(ctx context.Context, serial *big.Int, at time.Time, reason int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	r, ok := s.records[serial.String()]
+	if !ok {
+		return ErrNotFound
+	}
+	if r.Revoked() {
+		return nil
+	}
+	r.RevokedAt = at
+	r.Reason = reason
+	return s.persistLocked()
+}
+
+func (s *fileStore) ListRevoked(ctx context.Context, now time.Time) ([]*Record, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	var result []*Record
+	for _, r := range s.records {
+		if r.Revoked() && now.Before(r.NotAfter) {
+			record := *r
+			result = append(result, &record)
+		}
+	}
+	return result, nil
+}
+
+func (s *fileStore) DeleteExpired(ctx context.Context, before time.Time) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for serial, r := range s.records {
+		if r.NotAfter.Before(before) {
+			delete(s.records, serial)
+		}
+	}
+	return s.persistLocked()
+}
+
+func (s *fileStore) persistLocked() error {
+	if len(s.path) == 0 {
+		return nil
+	}
+
+	records := make([]*Record, 0, len(s.records))
+	for _, r := range s.records {
+		records = append(records, r)
+	}
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	// Write to the temporary file first, so the store is never left half-written
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to write ca store: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write ca store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write ca store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to write ca store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,372 @@
+package tlsutils
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/credentials"
+)
+
+const defaultCheckInterval = 30 * time.Second
+
+var (
+	certExpiryDesc = prometheus.NewDesc(
+		"tls_cert_expiry_timestamp_seconds",
+		"NotAfter of the served certificate",
+		[]string{"sign"}, nil,
+	)
+	certReloadsDesc = prometheus.NewDesc(
+		"tls_cert_reloads_total",
+		"Number of the certificate reloads partitioned by result",
+		[]string{"sign", "result"}, nil,
+	)
+)
+
+type CertReloaderConfig struct {
+	// StorageDirectory and SignName locate the PEM files written by Sign.Store,
+	// the files are polled, so the rename into place and the symlink swaps are picked up.
+	StorageDirectory string `yaml:"storage_directory"`
+	SignName         string `yaml:"sign_name"`
+	// CAPath is the PEM bundle to verify the peers with, it's reloaded as well.
+	// The system roots are used by the client if not set, the server doesn't verify the clients.
+	CAPath string `yaml:"ca_path"`
+	// CheckInterval is the period of the files check and the expiry check, 30s by default.
+	CheckInterval time.Duration `yaml:"check_interval"`
+	// RenewBefore is how long before NotAfter the sign is re-issued with the SignIssuer,
+	// the last third of the cert lifetime by default.
+	RenewBefore time.Duration `yaml:"renew_before"`
+}
+
+// SignIssuer issues the new sign, e.g. with GenerateSign and WithParentSign.
+type SignIssuer func() (*Sign, error)
+
+// CertReloader serves the current sign through the tls.Config callbacks,
+// so the certificates are rotated without the restart. The sign is reloaded
+// from the stored PEM files and/or re-issued with the SignIssuer before it expires,
+// the issued sign is stored to the StorageDirectory if set.
+// It implements prometheus.Collector to expose the expiry and reload metrics.
+type CertReloader struct {
+	config CertReloaderConfig
+	issuer SignIssuer
+
+	lock    sync.RWMutex
+	sign    *Sign
+	cert    *tls.Certificate
+	caPem   []byte
+	caPool  *x509.CertPool
+	reloads map[bool]uint64
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// NewCertReloader loads or issues the initial sign and starts the periodic check,
+// the issuer may be nil to only reload the stored files.
+func NewCertReloader(config CertReloaderConfig, issuer SignIssuer) (*CertReloader, error) {
+	if len(config.StorageDirectory) == 0 && issuer == nil {
+		return nil, errors.New("neither storage directory nor issuer is set")
+	}
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = defaultCheckInterval
+	}
+
+	r := &CertReloader{
+		config:  config,
+		issuer:  issuer,
+		reloads: make(map[bool]uint64),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+
+	go r.run()
+	return r, nil
+}
+
+// Sign returns the current sign.
+func (r *CertReloader) Sign() *Sign {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.sign
+}
+
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.cert, nil
+}
+
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.cert, nil
+}
+
+// ServerTLSConfig requires the client certificates verified against the current CA pool if the CAPath is set.
+func (r *CertReloader) ServerTLSConfig(opts ...CredentialsOption) (*tls.Config, error) {
+	base := &tls.Config{
+		GetCertificate: r.GetCertificate,
+	}
+	if len(r.config.CAPath) > 0 {
+		base.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	for _, opt := range opts {
+		if err := opt(base); err != nil {
+			return nil, err
+		}
+	}
+
+	return &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			cfg.ClientCAs = r.pool()
+			return cfg, nil
+		},
+	}, nil
+}
+
+// ClientTLSConfig presents the current sign to the server and verifies the server
+// against the current CA pool.
+func (r *CertReloader) ClientTLSConfig(opts ...CredentialsOption) (*tls.Config, error) {
+	cfg := &tls.Config{
+		GetClientCertificate: r.GetClientCertificate,
+		// The chain is verified in VerifyConnection since RootCAs can't be replaced
+		InsecureSkipVerify: true,
+		VerifyConnection:   r.verifyServer,
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+func (r *CertReloader) GrpcServerCredentials(opts ...CredentialsOption) (credentials.TransportCredentials, error) {
+	cfg, err := r.ServerTLSConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+func (r *CertReloader) GrpcClientCredentials(opts ...CredentialsOption) (credentials.TransportCredentials, error) {
+	cfg, err := r.ClientTLSConfig(opts...)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+func (r *CertReloader) Close() error {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+	return nil
+}
+
+// Reload checks the stored files and the expiry right away.
+func (r *CertReloader) Reload() error {
+	err := r.reload()
+
+	r.lock.Lock()
+	r.reloads[err == nil]++
+	r.lock.Unlock()
+	return err
+}
+
+func (r *CertReloader) Describe(ch chan<- *prometheus.Desc) {
+	ch <- certExpiryDesc
+	ch <- certReloadsDesc
+}
+
+func (r *CertReloader) Collect(ch chan<- prometheus.Metric) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	name := r.config.SignName
+	if r.sign != nil {
+		ch <- prometheus.MustNewConstMetric(certExpiryDesc, prometheus.GaugeValue, float64(r.sign.Cert.NotAfter.Unix()), name)
+	}
+	ch <- prometheus.MustNewConstMetric(certReloadsDesc, prometheus.CounterValue, float64(r.reloads[true]), name, "success")
+	ch <- prometheus.MustNewConstMetric(certReloadsDesc, prometheus.CounterValue, float64(r.reloads[false]), name, "error")
+}
+
+func (r *CertReloader) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if err := r.Reload(); err != nil {
+				zap.L().Warn("Failed to reload the sign, serving the previous one", zap.String("sign", r.config.SignName), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (r *CertReloader) reload() error {
+	if len(r.config.CAPath) > 0 {
+		if err := r.reloadCA(); err != nil {
+			return err
+		}
+	}
+
+	if len(r.config.StorageDirectory) > 0 {
+		sign, err := LoadSign(r.config.StorageDirectory, r.config.SignName)
+		switch {
+		case err == nil:
+			if err := r.apply(sign); err != nil {
+				if r.issuer == nil {
+					return err
+				}
+				// The stored cert and key don't match, they are replaced with the issued pair
+				zap.L().Warn("Stored sign is invalid, issuing the new one", zap.String("sign", r.config.SignName), zap.Error(err))
+				return r.issue()
+			}
+		case errors.Is(err, os.ErrNotExist) && r.issuer != nil:
+			// Issued below
+		default:
+			return err
+		}
+	}
+
+	if r.issuer == nil || !r.needsRenew() {
+		return nil
+	}
+	return r.issue()
+}
+
+func (r *CertReloader) issue() error {
+	sign, err := r.issuer()
+	if err != nil {
+		return fmt.Errorf("failed to issue sign: %w", err)
+	}
+	if len(r.config.StorageDirectory) > 0 {
+		if err := sign.Store(r.config.StorageDirectory, r.config.SignName); err != nil {
+			return err
+		}
+	}
+	return r.apply(sign)
+}
+
+func (r *CertReloader) reloadCA() error {
+	caPem, err := os.ReadFile(r.config.CAPath)
+	if err != nil {
+		return fmt.Errorf("failed to load ca: %w", err)
+	}
+
+	r.lock.RLock()
+	unchanged := bytes.Equal(caPem, r.caPem)
+	r.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPem) {
+		return errors.New("failed to create ca cert pool")
+	}
+
+	r.lock.Lock()
+	r.caPem = caPem
+	r.caPool = pool
+	r.lock.Unlock()
+	return nil
+}
+
+func (r *CertReloader) apply(sign *Sign) error {
+	r.lock.RLock()
+	unchanged := r.sign != nil && bytes.Equal(sign.CertPem, r.sign.CertPem) && bytes.Equal(sign.PrivateKeyPem, r.sign.PrivateKeyPem)
+	r.lock.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	// The files may be caught in the middle of the update, so the pair is checked
+	cert, err := tls.X509KeyPair(sign.CertPem, sign.PrivateKeyPem)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS key pair: %w", err)
+	}
+	leaf := cert.Leaf
+	if leaf == nil {
+		if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("failed to parse sign cert: %w", err)
+		}
+	}
+
+	// The parsed fields are taken from the checked PEM, the sign may come with the PEM only
+	sign = &Sign{
+		Cert:          leaf,
+		CertPem:       sign.CertPem,
+		PrivateKey:    cert.PrivateKey,
+		PrivateKeyPem: sign.PrivateKeyPem,
+	}
+
+	r.lock.Lock()
+	r.sign = sign
+	r.cert = &cert
+	r.lock.Unlock()
+
+	zap.L().Info("Sign is updated", zap.String("sign", r.config.SignName), zap.Stringer("cert", sign))
+	return nil
+}
+
+func (r *CertReloader) needsRenew() bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if r.sign == nil {
+		return true
+	}
+	renewBefore := r.config.RenewBefore
+	if renewBefore <= 0 {
+		renewBefore = r.sign.Cert.NotAfter.Sub(r.sign.Cert.NotBefore) / 3
+	}
+	return time.Until(r.sign.Cert.NotAfter) < renewBefore
+}
+
+func (r *CertReloader) pool() *x509.CertPool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.caPool
+}
+
+func (r *CertReloader) verifyServer(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("no server certificate")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range cs.PeerCertificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         r.pool(),
+		Intermediates: intermediates,
+		DNSName:       cs.ServerName,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
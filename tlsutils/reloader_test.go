@@ -0,0 +1,179 @@
+package tlsutils
+
+import (
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestReloader(t *testing.T, ca *Sign, dir string, name string) *CertReloader {
+	r, err := NewCertReloader(CertReloaderConfig{
+		StorageDirectory: dir,
+		SignName:         name,
+		CAPath:           filepath.Join(dir, "ca.pem"),
+		CheckInterval:    time.Hour,
+	}, func() (*Sign, error) {
+		return GenerateSign(WithParentSign(ca), WithEcdsaSigner(elliptic.P256()), WithDNSNames(name), WithValidity(time.Hour))
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+// handshake returns the serials of the server and the client certs seen by the peers.
+func handshake(t *testing.T, server *tls.Config, client *tls.Config) (*big.Int, *big.Int) {
+	serverConn, clientConn := net.Pipe()
+	defer serverConn.Close()
+	defer clientConn.Close()
+
+	s := tls.Server(serverConn, server)
+	errs := make(chan error, 1)
+	go func() { errs <- s.Handshake() }()
+
+	c := tls.Client(clientConn, client)
+	require.NoError(t, c.Handshake())
+	require.NoError(t, <-errs)
+	return c.ConnectionState().PeerCertificates[0].SerialNumber, s.ConnectionState().PeerCertificates[0].SerialNumber
+}
+
+func TestCertReloader(t *testing.T) {
+	ca, err := GenerateSign(WithCA(), WithEcdsaSigner(elliptic.P256()))
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), ca.CertPem, 0o600))
+
+	server := newTestReloader(t, ca, dir, "server")
+	client := newTestReloader(t, ca, dir, "client")
+	// the issued sign is stored
+	stored, err := LoadSign(dir, "server")
+	require.NoError(t, err)
+	assert.Equal(t, server.Sign().CertPem, stored.CertPem)
+
+	serverCfg, err := server.ServerTLSConfig()
+	require.NoError(t, err)
+	clientCfg, err := client.ClientTLSConfig()
+	require.NoError(t, err)
+	clientCfg.ServerName = "server"
+
+	serverSerial, clientSerial := handshake(t, serverCfg, clientCfg)
+	assert.Equal(t, server.Sign().Cert.SerialNumber, serverSerial)
+	assert.Equal(t, client.Sign().Cert.SerialNumber, clientSerial)
+
+	// the stored files are replaced
+	next, err := GenerateSign(WithParentSign(ca), WithEcdsaSigner(elliptic.P256()), WithDNSNames("server"), WithValidity(time.Hour))
+	require.NoError(t, err)
+	require.NoError(t, next.Store(dir, "server"))
+	require.NoError(t, server.Reload())
+	serverSerial, _ = handshake(t, serverCfg, clientCfg)
+	assert.Equal(t, next.Cert.SerialNumber, serverSerial)
+
+	// re-issued before the expiry
+	client.config.RenewBefore = 2 * time.Hour
+	previous := client.Sign()
+	require.NoError(t, client.Reload())
+	assert.NotEqual(t, previous.Cert.SerialNumber, client.Sign().Cert.SerialNumber)
+	_, clientSerial = handshake(t, serverCfg, clientCfg)
+	assert.Equal(t, client.Sign().Cert.SerialNumber, clientSerial)
+
+	// without the issuer the previous sign is served until the files are consistent
+	static, err := NewCertReloader(CertReloaderConfig{StorageDirectory: dir, SignName: "server", CheckInterval: time.Hour}, nil)
+	require.NoError(t, err)
+	defer static.Close()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, makeName("server", privateKeyFileName)), previous.PrivateKeyPem, 0o600))
+	assert.Error(t, static.Reload())
+	assert.Equal(t, next.CertPem, static.Sign().CertPem)
+
+	// the issuer replaces the mismatched files
+	require.NoError(t, server.Reload())
+	assert.NotEqual(t, next.Cert.SerialNumber, server.Sign().Cert.SerialNumber)
+	stored, err = LoadSign(dir, "server")
+	require.NoError(t, err)
+	assert.Equal(t, server.Sign().CertPem, stored.CertPem)
+	_, err = tls.X509KeyPair(stored.CertPem, stored.PrivateKeyPem)
+	assert.NoError(t, err)
+	leftovers, _ := filepath.Glob(filepath.Join(dir, "*.tmp"))
+	assert.Empty(t, leftovers)
+
+	metrics := `
+# HELP tls_cert_reloads_total Number of the certificate reloads partitioned by result
+# TYPE tls_cert_reloads_total counter
+tls_cert_reloads_total{result="error",sign="server"} 0
+tls_cert_reloads_total{result="success",sign="server"} 3
+`
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(server))
+	assert.NoError(t, testutil.GatherAndCompare(registry, strings.NewReader(metrics), "tls_cert_reloads_total"))
+	assert.Equal(t, 3, testutil.CollectAndCount(server), "expiry and reloads")
+}
+
+func TestCertReloader_rotatedCA(t *testing.T) {
+	ca, err := GenerateSign(WithCA(), WithEd25519Signer())
+	require.NoError(t, err)
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), ca.CertPem, 0o600))
+	server := newTestReloader(t, ca, dir, "server")
+
+	other, err := GenerateSign(WithCA(), WithEd25519Signer())
+	require.NoError(t, err)
+	otherDir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(otherDir, "ca.pem"), other.CertPem, 0o600))
+	client := newTestReloader(t, other, otherDir, "client")
+
+	serverCfg, err := server.ServerTLSConfig()
+	require.NoError(t, err)
+	clientCfg, err := client.ClientTLSConfig()
+	require.NoError(t, err)
+	clientCfg.ServerName = "server"
+
+	// the client doesn't trust the server CA yet
+	serverConn, clientConn := net.Pipe()
+	go func() { _ = tls.Server(serverConn, serverCfg).Handshake(); serverConn.Close() }()
+	err = tls.Client(clientConn, clientCfg).Handshake()
+	clientConn.Close()
+	var unknown x509.UnknownAuthorityError
+	assert.ErrorAs(t, err, &unknown)
+
+	// both CAs are trusted after the bundle update
+	bundle := append(append([]byte{}, ca.CertPem...), other.CertPem...)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "ca.pem"), bundle, 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(otherDir, "ca.pem"), bundle, 0o600))
+	require.NoError(t, server.Reload())
+	require.NoError(t, client.Reload())
+	handshake(t, serverCfg, clientCfg)
+}
+
+func TestCertReloader_pemOnly(t *testing.T) {
+	ca, err := GenerateSign(WithCA(), WithEd25519Signer())
+	require.NoError(t, err)
+
+	// the issuer may fill the PEM only
+	r, err := NewCertReloader(CertReloaderConfig{CheckInterval: time.Hour}, func() (*Sign, error) {
+		sign, err := GenerateSign(WithParentSign(ca), WithEd25519Signer(), WithValidity(time.Hour))
+		if err != nil {
+			return nil, err
+		}
+		return &Sign{CertPem: sign.CertPem, PrivateKeyPem: sign.PrivateKeyPem}, nil
+	})
+	require.NoError(t, err)
+	defer r.Close()
+
+	require.NotNil(t, r.Sign().Cert)
+	assert.NotNil(t, r.Sign().PrivateKey)
+	r.config.RenewBefore = 2 * time.Hour
+	previous := r.Sign()
+	require.NoError(t, r.Reload())
+	assert.NotEqual(t, previous.Cert.SerialNumber, r.Sign().Cert.SerialNumber)
+	assert.Equal(t, 3, testutil.CollectAndCount(r), "expiry and reloads")
+}
@@ -3,16 +3,21 @@ package tlsutils
 import (
 	"bytes"
 	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"math/big"
 	"net"
+	"net/url"
 	"os"
 	"path"
 	"time"
@@ -58,7 +63,51 @@ func (s *Sign) String() string {
 	return ""
 }
 
-func (s *Sign) GrpcServerCredentials(caPem []byte) (credentials.TransportCredentials, error) {
+// CredentialsOption tunes the TLS config of the gRPC credentials.
+type CredentialsOption func(cfg *tls.Config) error
+
+// WithPeerVerifier rejects the peer certificate the verify fails for, e.g. the revoked one.
+// It is called after the chain verification.
+func WithPeerVerifier(verify func(cert *x509.Certificate) error) CredentialsOption {
+	return func(cfg *tls.Config) error {
+		cfg.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return nil
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return fmt.Errorf("failed to parse peer cert: %w", err)
+			}
+			return verify(cert)
+		}
+		return nil
+	}
+}
+
+// WithClientAuth sets the server policy for the client certificates.
+func WithClientAuth(clientAuth tls.ClientAuthType) CredentialsOption {
+	return func(cfg *tls.Config) error {
+		cfg.ClientAuth = clientAuth
+		return nil
+	}
+}
+
+// WithClientSign makes the client present the sign certificate to the server.
+func WithClientSign(sign *Sign) CredentialsOption {
+	return func(cfg *tls.Config) error {
+		if sign == nil || len(sign.CertPem) == 0 || len(sign.PrivateKeyPem) == 0 {
+			return errors.New("incomplete/uninitialized client cert and private key")
+		}
+		certificate, err := tls.X509KeyPair(sign.CertPem, sign.PrivateKeyPem)
+		if err != nil {
+			return fmt.Errorf("failed to load client TLS key pair: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{certificate}
+		return nil
+	}
+}
+
+func (s *Sign) GrpcServerCredentials(caPem []byte, opts ...CredentialsOption) (credentials.TransportCredentials, error) {
 	if len(s.CertPem) == 0 || len(s.PrivateKeyPem) == 0 {
 		return nil, errors.New("incomplete/uninitialized cert and private key")
 	}
@@ -77,13 +126,19 @@ func (s *Sign) GrpcServerCredentials(caPem []byte) (credentials.TransportCredent
 		}
 	}
 
-	return credentials.NewTLS(&tls.Config{
+	cfg := &tls.Config{
 		Certificates: []tls.Certificate{certificate},
 		ClientCAs:    certPool,
-	}), nil
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return credentials.NewTLS(cfg), nil
 }
 
-func (s *Sign) GrpcClientCredentials() (credentials.TransportCredentials, error) {
+func (s *Sign) GrpcClientCredentials(opts ...CredentialsOption) (credentials.TransportCredentials, error) {
 	if len(s.CertPem) == 0 {
 		return nil, errors.New("incomplete/uninitialized cert")
 	}
@@ -93,9 +148,15 @@ func (s *Sign) GrpcClientCredentials() (credentials.TransportCredentials, error)
 		return nil, fmt.Errorf("failed to add server CA's certificate")
 	}
 
-	return credentials.NewTLS(&tls.Config{
+	cfg := &tls.Config{
 		RootCAs: certPool,
-	}), nil
+	}
+	for _, opt := range opts {
+		if err := opt(cfg); err != nil {
+			return nil, err
+		}
+	}
+	return credentials.NewTLS(cfg), nil
 }
 
 func (s *Sign) Store(storageDirectory string, signName string) error {
@@ -109,19 +170,40 @@ func (s *Sign) Store(storageDirectory string, signName string) error {
 		return errors.New("sign private key is not set on")
 	}
 
-	err := os.WriteFile(path.Join(storageDirectory, makeName(signName, certFileName)), s.CertPem, 0o600)
+	// Each file is replaced atomically, but not the pair,
+	// so the readers have to check the pair, see CertReloader
+	err := writeFileAtomic(path.Join(storageDirectory, makeName(signName, certFileName)), s.CertPem)
 	if err != nil {
 		return fmt.Errorf("failed to store sign cert: %w", err)
 	}
 
-	err = os.WriteFile(path.Join(storageDirectory, makeName(signName, privateKeyFileName)), s.PrivateKeyPem, 0o600)
+	err = writeFileAtomic(path.Join(storageDirectory, makeName(signName, privateKeyFileName)), s.PrivateKeyPem)
 	if err != nil {
-		return fmt.Errorf("failed to store sign cert: %w", err)
+		return fmt.Errorf("failed to store sign private key: %w", err)
 	}
 
 	return nil
 }
 
+// writeFileAtomic writes the temporary file and renames it into place,
+// so the file is never seen half-written.
+func writeFileAtomic(name string, data []byte) error {
+	tmp, err := os.CreateTemp(path.Dir(name), path.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
 func LoadSign(storageDirectory string, signName string) (*Sign, error) {
 	if storageDirectory == "" {
 		return nil, ErrStorageDir
@@ -206,6 +288,109 @@ func WithRsaSigner(bits int) SignGenOption {
 	}
 }
 
+// WithEcdsaSigner generates the ECDSA key on the P-256 or P-384 curve.
+func WithEcdsaSigner(curve elliptic.Curve) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		if curve != elliptic.P256() && curve != elliptic.P384() {
+			return fmt.Errorf("unsupported ecdsa curve: %v", curve.Params().Name)
+		}
+		signer, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return err
+		}
+		opts.signer = signer
+		return nil
+	}
+}
+
+func WithEd25519Signer() SignGenOption {
+	return func(opts *SignGenOptions) error {
+		_, signer, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		opts.signer = signer
+		return nil
+	}
+}
+
+func WithSubject(subject pkix.Name) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		opts.templateCert.Subject = subject
+		return nil
+	}
+}
+
+func WithCommonName(commonName string) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		opts.templateCert.Subject.CommonName = commonName
+		return nil
+	}
+}
+
+// WithValidity sets the cert lifetime starting from now, one year by default.
+func WithValidity(validity time.Duration) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		if validity <= 0 {
+			return errors.New("validity must be positive")
+		}
+		opts.templateCert.NotAfter = time.Now().Add(validity)
+		return nil
+	}
+}
+
+func WithURIs(uris ...*url.URL) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		opts.templateCert.URIs = append(opts.templateCert.URIs, uris...)
+		return nil
+	}
+}
+
+// WithSPIFFEID adds the SPIFFE ID, e.g. spiffe://vpnhouse.net/node/1, to the URI SANs.
+func WithSPIFFEID(id string) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		uri, err := url.Parse(id)
+		if err != nil {
+			return fmt.Errorf("invalid spiffe id: %w", err)
+		}
+		if uri.Scheme != "spiffe" || len(uri.Host) == 0 || len(uri.RawQuery) > 0 || len(uri.Fragment) > 0 || uri.User != nil {
+			return fmt.Errorf("invalid spiffe id: %s", id)
+		}
+		opts.templateCert.URIs = append(opts.templateCert.URIs, uri)
+		return nil
+	}
+}
+
+// WithServerOnly limits the cert usage to the TLS server authentication.
+func WithServerOnly() SignGenOption {
+	return func(opts *SignGenOptions) error {
+		opts.templateCert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		return nil
+	}
+}
+
+// WithClientOnly limits the cert usage to the TLS client authentication.
+func WithClientOnly() SignGenOption {
+	return func(opts *SignGenOptions) error {
+		opts.templateCert.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		return nil
+	}
+}
+
+// WithMaxPathLen limits the number of the intermediate CAs below the CA,
+// zero means the CA can issue the leaf certs only.
+// Combine with WithCA and WithParentSign to issue the intermediate CA.
+func WithMaxPathLen(maxPathLen int) SignGenOption {
+	return func(opts *SignGenOptions) error {
+		if maxPathLen < 0 {
+			return errors.New("max path length must not be negative")
+		}
+		opts.templateCert.MaxPathLen = maxPathLen
+		opts.templateCert.MaxPathLenZero = maxPathLen == 0
+		return nil
+	}
+}
+
 func WithParentSign(sign *Sign) SignGenOption {
 	return func(opts *SignGenOptions) error {
 		if sign == nil || sign.Cert == nil || sign.PrivateKey == nil {
@@ -245,21 +430,34 @@ func GenerateSign(opts ...SignGenOption) (*Sign, error) {
 	if genOpts.signer == nil {
 		return nil, errors.New("signer is not set")
 	}
+	if genOpts.templateCert.MaxPathLen > 0 || genOpts.templateCert.MaxPathLenZero {
+		if !genOpts.templateCert.IsCA {
+			return nil, errors.New("path length is set for non-CA cert")
+		}
+	}
+	if !genOpts.templateCert.IsCA {
+		// The leaf cert can't sign the other certs
+		genOpts.templateCert.KeyUsage = x509.KeyUsageDigitalSignature
+		if _, ok := genOpts.signer.(*rsa.PrivateKey); ok {
+			genOpts.templateCert.KeyUsage |= x509.KeyUsageKeyEncipherment
+		}
+	}
 
 	var signerCert *x509.Certificate
 	var privateKey crypto.PrivateKey
 	var publicKey crypto.PublicKey
-	if genOpts.templateCert.IsCA {
-		signerCert = genOpts.templateCert
-		privateKey = genOpts.signer
-		publicKey = genOpts.signer.Public()
-	} else if genOpts.parentSign != nil {
+	if genOpts.parentSign != nil {
+		// The leaf or the intermediate CA
 		signerCert = genOpts.parentSign.Cert
 		privateKey = genOpts.parentSign.PrivateKey
 		publicKey, err = getPublicKey(genOpts.signer)
 		if err != nil {
 			return nil, err
 		}
+	} else if genOpts.templateCert.IsCA {
+		signerCert = genOpts.templateCert
+		privateKey = genOpts.signer
+		publicKey = genOpts.signer.Public()
 	} else {
 		return nil, errors.New("incomplete sign options")
 	}
@@ -269,12 +467,17 @@ func GenerateSign(opts ...SignGenOption) (*Sign, error) {
 		return nil, err
 	}
 
-	cert, err := x509.CreateCertificate(rand.Reader, genOpts.templateCert, signerCert, publicKey, privateKey)
+	certDer, err := x509.CreateCertificate(rand.Reader, genOpts.templateCert, signerCert, publicKey, privateKey)
 	if err != nil {
 		return nil, err
 	}
 
-	certPem, err := encodePEM(cert, PemBlockTypeCertificate)
+	cert, err := x509.ParseCertificate(certDer)
+	if err != nil {
+		return nil, err
+	}
+
+	certPem, err := encodePEM(certDer, PemBlockTypeCertificate)
 	if err != nil {
 		return nil, err
 	}
@@ -290,7 +493,7 @@ func GenerateSign(opts ...SignGenOption) (*Sign, error) {
 	}
 
 	return &Sign{
-		Cert:          genOpts.templateCert,
+		Cert:          cert,
 		CertPem:       certPem,
 		PrivateKey:    genOpts.signer,
 		PrivateKeyPem: certPrivateKeyPem,
@@ -340,7 +543,7 @@ func decodePEM(data []byte, blockType PemBlockType) ([]byte, error) {
 
 func marshalPrivateKey(privateKey crypto.PrivateKey) ([]byte, error) {
 	switch k := privateKey.(type) {
-	case *rsa.PrivateKey:
+	case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
 		key, err := x509.MarshalPKCS8PrivateKey(k)
 		if err != nil {
 			return nil, err
@@ -359,7 +562,7 @@ func unmarshalPrivateKey(keyDer []byte) (crypto.PrivateKey, error) {
 	key, err := x509.ParsePKCS8PrivateKey(keyDer)
 	if err == nil {
 		switch key := key.(type) {
-		case *rsa.PrivateKey:
+		case *rsa.PrivateKey, *ecdsa.PrivateKey, ed25519.PrivateKey:
 			return key, nil
 		default:
 			return nil, fmt.Errorf("unknown private key type (%v) %T in PKCS#8 wrapping", key, key)
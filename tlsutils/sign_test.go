@@ -0,0 +1,92 @@
+package tlsutils
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateSign_keys(t *testing.T) {
+	for name, signer := range map[string]SignGenOption{
+		"rsa":     WithRsaSigner(2048),
+		"p256":    WithEcdsaSigner(elliptic.P256()),
+		"p384":    WithEcdsaSigner(elliptic.P384()),
+		"ed25519": WithEd25519Signer(),
+	} {
+		ca, err := GenerateSign(WithCA(), signer, WithCommonName("ca"))
+		require.NoError(t, err, name)
+
+		leaf, err := GenerateSign(WithParentSign(ca), signer, WithDNSNames("node.mesh"))
+		require.NoError(t, err, name)
+		assert.Equal(t, x509.KeyUsage(0), leaf.Cert.KeyUsage&x509.KeyUsageCertSign, name)
+
+		dir := t.TempDir()
+		require.NoError(t, leaf.Store(dir, "leaf"), name)
+		loaded, err := LoadSign(dir, "leaf")
+		require.NoError(t, err, name)
+		assert.Equal(t, leaf.PrivateKey, loaded.PrivateKey, name)
+
+		pool := x509.NewCertPool()
+		pool.AddCert(ca.Cert)
+		_, err = loaded.Cert.Verify(x509.VerifyOptions{Roots: pool, DNSName: "node.mesh"})
+		assert.NoError(t, err, name)
+	}
+
+	_, err := GenerateSign(WithCA(), WithEcdsaSigner(elliptic.P224()))
+	assert.Error(t, err)
+}
+
+func TestGenerateSign_template(t *testing.T) {
+	root, err := GenerateSign(WithCA(), WithEcdsaSigner(elliptic.P256()), WithMaxPathLen(1),
+		WithSubject(pkix.Name{Organization: []string{"VPN House"}, CommonName: "root"}))
+	require.NoError(t, err)
+	assert.Equal(t, 1, root.Cert.MaxPathLen)
+	assert.Equal(t, []string{"VPN House"}, root.Cert.Subject.Organization)
+
+	intermediate, err := GenerateSign(WithCA(), WithParentSign(root), WithEd25519Signer(), WithMaxPathLen(0), WithCommonName("intermediate"))
+	require.NoError(t, err)
+	assert.True(t, intermediate.Cert.MaxPathLenZero)
+	assert.Equal(t, "root", intermediate.Cert.Issuer.CommonName)
+
+	leaf, err := GenerateSign(WithParentSign(intermediate), WithEcdsaSigner(elliptic.P256()),
+		WithSPIFFEID("spiffe://vpnhouse.net/node/1"), WithClientOnly(), WithValidity(time.Hour))
+	require.NoError(t, err)
+	_, ok := leaf.PrivateKey.(*ecdsa.PrivateKey)
+	assert.True(t, ok)
+	_, ok = intermediate.PrivateKey.(ed25519.PrivateKey)
+	assert.True(t, ok)
+	require.Len(t, leaf.Cert.URIs, 1)
+	assert.Equal(t, "spiffe://vpnhouse.net/node/1", leaf.Cert.URIs[0].String())
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, leaf.Cert.ExtKeyUsage)
+	assert.WithinDuration(t, time.Now().Add(time.Hour), leaf.Cert.NotAfter, time.Minute)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root.Cert)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate.Cert)
+	_, err = leaf.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+	_, err = leaf.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}})
+	assert.Error(t, err, "client only cert")
+
+	// the intermediate with zero path length can't issue CAs
+	sub, err := GenerateSign(WithCA(), WithParentSign(intermediate), WithEcdsaSigner(elliptic.P256()))
+	require.NoError(t, err)
+	intermediates.AddCert(sub.Cert)
+	subLeaf, err := GenerateSign(WithParentSign(sub), WithEcdsaSigner(elliptic.P256()))
+	require.NoError(t, err)
+	_, err = subLeaf.Cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates})
+	assert.Error(t, err)
+
+	_, err = GenerateSign(WithParentSign(root), WithEd25519Signer(), WithSPIFFEID("https://vpnhouse.net/node"))
+	assert.Error(t, err)
+	_, err = GenerateSign(WithParentSign(root), WithEd25519Signer(), WithMaxPathLen(1))
+	assert.Error(t, err)
+}
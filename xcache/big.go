@@ -0,0 +1,75 @@
+package xcache
+
+import (
+	"encoding/binary"
+
+	xxhash "github.com/cespare/xxhash/v2"
+)
+
+// The sub-value is stored under the 16 bytes sub-key along with the 4 bytes of the lengths.
+// Several sub-values fit a chunk, so the sub-values falling into the same bucket
+// do not evict each other even with the minimal bucket size of a single chunk.
+const maxSubValueLen = chunkSize/8 - 16 - 4
+
+// SetBig stores (k, v) of any size in the cache.
+// The value is split into the chunk sized sub-values stored under the keys
+// derived from the value hash, the entry for k keeps the hash and the length only.
+// The values stored with SetBig must be read with GetBig.
+func (c *Cache) SetBig(k, v []byte) error {
+	valueHash := xxhash.Sum64(v)
+	valueLen := len(v)
+
+	subKey := make([]byte, 16)
+	binary.BigEndian.PutUint64(subKey, valueHash)
+	for i := uint64(0); len(v) > 0; i++ {
+		subValueLen := maxSubValueLen
+		if len(v) < subValueLen {
+			subValueLen = len(v)
+		}
+		binary.BigEndian.PutUint64(subKey[8:], i)
+		if err := c.Set(subKey, v[:subValueLen]); err != nil {
+			return err
+		}
+		v = v[subValueLen:]
+	}
+
+	meta := make([]byte, 16)
+	binary.BigEndian.PutUint64(meta, valueHash)
+	binary.BigEndian.PutUint64(meta[8:], uint64(valueLen))
+	return c.Set(k, meta)
+}
+
+// GetBig returns the value stored with SetBig.
+// ErrNoData is returned if any of the sub-values has been evicted already.
+func (c *Cache) GetBig(k []byte) ([]byte, error) {
+	meta, err := c.Get(k)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) != 16 {
+		return nil, ErrCorruptedData
+	}
+	valueHash := binary.BigEndian.Uint64(meta)
+	valueLen := binary.BigEndian.Uint64(meta[8:])
+
+	v := make([]byte, 0, valueLen)
+	subKey := make([]byte, 16)
+	binary.BigEndian.PutUint64(subKey, valueHash)
+	for i := uint64(0); uint64(len(v)) < valueLen; i++ {
+		binary.BigEndian.PutUint64(subKey[8:], i)
+		subValue, err := c.Get(subKey)
+		if err != nil {
+			return nil, err
+		}
+		if len(subValue) == 0 {
+			return nil, ErrCorruptedData
+		}
+		v = append(v, subValue...)
+	}
+
+	// The sub-values may be overwritten by the value having the colliding hash
+	if uint64(len(v)) != valueLen || xxhash.Sum64(v) != valueHash {
+		return nil, ErrNoData
+	}
+	return v, nil
+}
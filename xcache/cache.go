@@ -123,6 +123,13 @@ func (c *Cache) Del(k []byte) {
 	c.buckets[idx].Del(h)
 }
 
+// Take returns the value for the given k and deletes it from the cache atomically.
+func (c *Cache) Take(k []byte) ([]byte, error) {
+	h := xxhash.Sum64(k)
+	idx := h % bucketsCount
+	return c.buckets[idx].Take(k, h)
+}
+
 // Reset removes all the items from the cache.
 func (c *Cache) Reset() {
 	var evicted *Items
@@ -448,6 +455,18 @@ func (b *bucket) GetSet(k []byte, h uint64, provider Provider, onEvict OnEvict)
 	return nil, err
 }
 
+func (b *bucket) Take(k []byte, h uint64) ([]byte, error) {
+	b.l.Lock()
+	defer b.l.Unlock()
+
+	v, err := b.getLocked(k, h, false)
+	if err != nil {
+		return nil, err
+	}
+	delete(b.m, h)
+	return v, nil
+}
+
 func (b *bucket) Del(h uint64) {
 	b.l.Lock()
 	defer b.l.Unlock()
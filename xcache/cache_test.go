@@ -84,6 +84,24 @@ func TestCacheDel(t *testing.T) {
 	}
 }
 
+func TestCacheTake(t *testing.T) {
+	c, err := New(1, nil)
+	assert.NoError(t, err)
+
+	defer c.Reset()
+
+	k, v := []byte("key"), []byte("value")
+	assert.NoError(t, c.Set(k, v))
+
+	vv, err := c.Take(k)
+	assert.NoError(t, err)
+	assert.Equal(t, string(v), string(vv))
+
+	vv, err = c.Take(k)
+	assert.ErrorIs(t, err, ErrNoData)
+	assert.Empty(t, vv)
+}
+
 func TestCacheUpdate(t *testing.T) {
 	c, err := New(1, nil)
 	assert.NoError(t, err)
@@ -231,3 +249,28 @@ func TestCompositeKeys(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, v, []byte(key))
 }
+
+func TestCacheSetGetBig(t *testing.T) {
+	c, err := New(32<<20, nil)
+	assert.NoError(t, err)
+	defer c.Reset()
+
+	for _, size := range []int{0, 1, maxSubValueLen, maxSubValueLen + 1, 1 << 20} {
+		k := []byte(fmt.Sprintf("key_%d", size))
+		v := []byte(xrand.String(size))
+		assert.NoError(t, c.SetBig(k, v))
+
+		vv, err := c.GetBig(k)
+		assert.NoError(t, err)
+		assert.Equalf(t, len(v), len(vv), "unexpected value length for size %d", size)
+		assert.True(t, bytes.Equal(v, vv))
+	}
+
+	_, err = c.GetBig([]byte("missing"))
+	assert.ErrorIs(t, err, ErrNoData)
+
+	// The value stored with Set is not a big one
+	assert.NoError(t, c.Set([]byte("small"), []byte("value")))
+	_, err = c.GetBig([]byte("small"))
+	assert.ErrorIs(t, err, ErrCorruptedData)
+}
@@ -0,0 +1,217 @@
+// Package typed provides the generic layer over the xcache.Cache
+// with the per-entry TTL and the values of any size.
+package typed
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/xcache"
+)
+
+// Codec converts the values to the bytes stored in the cache.
+type Codec[V any] interface {
+	Marshal(v V) ([]byte, error)
+	Unmarshal(data []byte, v *V) error
+}
+
+type JSONCodec[V any] struct{}
+
+func (JSONCodec[V]) Marshal(v V) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (JSONCodec[V]) Unmarshal(data []byte, v *V) error {
+	return json.Unmarshal(data, v)
+}
+
+// BytesCodec stores the byte slices as is.
+type BytesCodec struct{}
+
+func (BytesCodec) Marshal(v []byte) ([]byte, error) {
+	return v, nil
+}
+
+func (BytesCodec) Unmarshal(data []byte, v *[]byte) error {
+	*v = data
+	return nil
+}
+
+type Key interface {
+	~string | ~[]byte
+}
+
+type Loader[V any] func() (V, error)
+
+// ErrLoaderPanic is returned to the GetSet calls waiting for the loader that panicked,
+// the panic itself goes on in the call running the loader.
+var ErrLoaderPanic = errors.New("cache loader panicked")
+
+type options struct {
+	maxBytes int
+	ttl      time.Duration
+}
+
+type Option func(opts *options)
+
+// WithMaxBytes sets the cache capacity, see xcache.New.
+func WithMaxBytes(maxBytes int) Option {
+	return func(opts *options) {
+		opts.maxBytes = maxBytes
+	}
+}
+
+// WithTTL sets the default TTL of the entries, zero means no expiry.
+func WithTTL(ttl time.Duration) Option {
+	return func(opts *options) {
+		opts.ttl = ttl
+	}
+}
+
+// Cache is the thread-safe cache of the typed values.
+// The entries may be evicted before the TTL expires as the cache is full.
+type Cache[K Key, V any] struct {
+	cache *xcache.Cache
+	codec Codec[V]
+	ttl   time.Duration
+	now   func() time.Time
+
+	lock  sync.Mutex
+	calls map[string]*call[V]
+}
+
+// call is the in-flight loader shared by the concurrent GetSet.
+type call[V any] struct {
+	done chan struct{}
+	v    V
+	err  error
+}
+
+// run sets the error for the waiters before the loader panic unwinds GetSet.
+func (cl *call[V]) run(loader Loader[V]) {
+	defer func() {
+		if r := recover(); r != nil {
+			cl.err = fmt.Errorf("%w: %v", ErrLoaderPanic, r)
+			panic(r)
+		}
+	}()
+	cl.v, cl.err = loader()
+}
+
+const defaultMaxBytes = 32 << 20 // 32 Mb
+
+// The value is prefixed with the expiration time in unix nanoseconds, zero means no expiry.
+const headerLen = 8
+
+func New[K Key, V any](codec Codec[V], opts ...Option) (*Cache[K, V], error) {
+	o := options{
+		maxBytes: defaultMaxBytes,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cache, err := xcache.New(o.maxBytes, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Cache[K, V]{
+		cache: cache,
+		codec: codec,
+		ttl:   o.ttl,
+		now:   time.Now,
+		calls: make(map[string]*call[V]),
+	}, nil
+}
+
+// Get returns xcache.ErrNoData if there is no value or it is expired.
+func (c *Cache[K, V]) Get(k K) (V, error) {
+	var v V
+	data, err := c.cache.GetBig([]byte(k))
+	if err != nil {
+		return v, err
+	}
+	if len(data) < headerLen {
+		return v, xcache.ErrCorruptedData
+	}
+
+	expires := binary.BigEndian.Uint64(data)
+	if expires != 0 && uint64(c.now().UnixNano()) >= expires {
+		c.cache.Del([]byte(k))
+		return v, xcache.ErrNoData
+	}
+
+	if err := c.codec.Unmarshal(data[headerLen:], &v); err != nil {
+		return v, err
+	}
+	return v, nil
+}
+
+// Set stores the value with the default TTL.
+func (c *Cache[K, V]) Set(k K, v V) error {
+	return c.SetWithTTL(k, v, c.ttl)
+}
+
+// SetWithTTL stores the value expiring after the ttl, zero means no expiry.
+func (c *Cache[K, V]) SetWithTTL(k K, v V, ttl time.Duration) error {
+	payload, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	var expires uint64
+	if ttl > 0 {
+		expires = uint64(c.now().Add(ttl).UnixNano())
+	}
+	data := make([]byte, headerLen, headerLen+len(payload))
+	binary.BigEndian.PutUint64(data, expires)
+	data = append(data, payload...)
+	return c.cache.SetBig([]byte(k), data)
+}
+
+func (c *Cache[K, V]) Del(k K) {
+	c.cache.Del([]byte(k))
+}
+
+func (c *Cache[K, V]) Reset() {
+	c.cache.Reset()
+}
+
+// GetSet returns the cached value or stores the one returned by the loader
+// with the default TTL. The concurrent calls for the same key share
+// the single loader call. The loader errors are not cached.
+func (c *Cache[K, V]) GetSet(k K, loader Loader[V]) (V, error) {
+	if v, err := c.Get(k); err == nil {
+		return v, nil
+	}
+
+	key := string(k)
+	c.lock.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.lock.Unlock()
+		<-cl.done
+		return cl.v, cl.err
+	}
+	cl := &call[V]{done: make(chan struct{})}
+	c.calls[key] = cl
+	c.lock.Unlock()
+
+	defer func() {
+		c.lock.Lock()
+		delete(c.calls, key)
+		c.lock.Unlock()
+		close(cl.done)
+	}()
+
+	cl.run(loader)
+	if cl.err != nil {
+		return cl.v, cl.err
+	}
+	// The value is still returned if it can't be cached
+	_ = c.Set(k, cl.v)
+	return cl.v, nil
+}
@@ -0,0 +1,148 @@
+package typed
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xcache"
+)
+
+type response struct {
+	Status int    `json:"status"`
+	Body   string `json:"body"`
+}
+
+func TestCache(t *testing.T) {
+	c, err := New[string, response](JSONCodec[response]{})
+	require.NoError(t, err)
+
+	_, err = c.Get("missing")
+	assert.ErrorIs(t, err, xcache.ErrNoData)
+
+	require.NoError(t, c.Set("a", response{Status: 200, Body: "ok"}))
+	v, err := c.Get("a")
+	require.NoError(t, err)
+	assert.Equal(t, response{Status: 200, Body: "ok"}, v)
+
+	c.Del("a")
+	_, err = c.Get("a")
+	assert.ErrorIs(t, err, xcache.ErrNoData)
+}
+
+func TestCache_ttl(t *testing.T) {
+	c, err := New[string, []byte](BytesCodec{}, WithTTL(time.Minute))
+	require.NoError(t, err)
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	require.NoError(t, c.Set("default", []byte("1")))
+	require.NoError(t, c.SetWithTTL("short", []byte("2"), time.Second))
+	require.NoError(t, c.SetWithTTL("forever", []byte("3"), 0))
+
+	now = now.Add(2 * time.Second)
+	_, err = c.Get("short")
+	assert.ErrorIs(t, err, xcache.ErrNoData)
+	v, err := c.Get("default")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("1"), v)
+
+	now = now.Add(time.Hour)
+	_, err = c.Get("default")
+	assert.ErrorIs(t, err, xcache.ErrNoData)
+	v, err = c.Get("forever")
+	require.NoError(t, err)
+	assert.Equal(t, []byte("3"), v)
+}
+
+func TestCache_big(t *testing.T) {
+	c, err := New[[]byte, []byte](BytesCodec{})
+	require.NoError(t, err)
+
+	big := bytes.Repeat([]byte("0123456789"), 50_000)
+	require.NoError(t, c.Set([]byte("big"), big))
+	v, err := c.Get([]byte("big"))
+	require.NoError(t, err)
+	assert.Equal(t, big, v)
+}
+
+func TestCache_GetSet(t *testing.T) {
+	c, err := New[string, response](JSONCodec[response]{}, WithTTL(time.Minute))
+	require.NoError(t, err)
+
+	var calls atomic.Int32
+	release := make(chan struct{})
+	loader := func() (response, error) {
+		calls.Add(1)
+		<-release
+		return response{Status: 200}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, err := c.GetSet("k", loader)
+			assert.NoError(t, err)
+			assert.Equal(t, 200, v.Status)
+		}()
+	}
+	// let the goroutines join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), calls.Load())
+
+	v, err := c.GetSet("k", func() (response, error) {
+		t.Fatal("the cached value is expected")
+		return response{}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 200, v.Status)
+}
+
+func TestCache_GetSetPanic(t *testing.T) {
+	c, err := New[string, response](JSONCodec[response]{}, WithTTL(time.Minute))
+	require.NoError(t, err)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go func() {
+		defer func() { _ = recover() }()
+		_, _ = c.GetSet("k", func() (response, error) {
+			close(started)
+			<-release
+			panic("boom")
+		})
+	}()
+	<-started
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := c.GetSet("k", func() (response, error) {
+			t.Error("the in-flight call is expected to be joined")
+			return response{}, nil
+		})
+		errs <- err
+	}()
+	// let the goroutine join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	assert.ErrorIs(t, <-errs, ErrLoaderPanic)
+
+	// the panic is not cached
+	v, err := c.GetSet("k", func() (response, error) {
+		return response{Status: 200}, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 200, v.Status)
+
+	assert.PanicsWithValue(t, "boom", func() {
+		_, _ = c.GetSet("other", func() (response, error) { panic("boom") })
+	})
+}
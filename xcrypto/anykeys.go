@@ -0,0 +1,122 @@
+// Copyright 2021 The VPN House Authors. All rights reserved.
+// Use of this source code is governed by a AGPL-style
+// license that can be found in the LICENSE file.
+
+package xcrypto
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+
+	"github.com/vpnhouse/common-lib-go/xerror"
+)
+
+type KeyType string
+
+const (
+	KeyTypeRSA     KeyType = "rsa"
+	KeyTypeECDSA   KeyType = "ecdsa" // P-256
+	KeyTypeEd25519 KeyType = "ed25519"
+)
+
+// GenerateKeyOf generates the key pair of the given type.
+func GenerateKeyOf(keyType KeyType) (crypto.Signer, error) {
+	switch keyType {
+	case KeyTypeRSA:
+		return GenerateKey()
+	case KeyTypeECDSA:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case KeyTypeEd25519:
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return private, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type: %s", keyType)
+	}
+}
+
+// MarshalAnyPublicKey encodes the RSA keys as PKCS1 to stay compatible
+// with MarshalPublicKey and the other keys as PKIX.
+func MarshalAnyPublicKey(key crypto.PublicKey) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PublicKey); ok {
+		return MarshalPublicKey(rsaKey)
+	}
+
+	bs, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return nil, xerror.EInternalError("can't marshal public key", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PUBLIC KEY",
+		Bytes: bs,
+	}), nil
+}
+
+// UnmarshalAnyPublicKey decodes the keys encoded with MarshalAnyPublicKey or MarshalPublicKey.
+func UnmarshalAnyPublicKey(bs []byte) (crypto.PublicKey, error) {
+	publicPEM, _ := pem.Decode(bs)
+	if publicPEM == nil {
+		return nil, xerror.EInternalError("can't parse PEM file", nil)
+	}
+
+	var publicKey crypto.PublicKey
+	var err error
+	switch publicPEM.Type {
+	case "RSA PUBLIC KEY":
+		publicKey, err = x509.ParsePKCS1PublicKey(publicPEM.Bytes)
+	default:
+		publicKey, err = x509.ParsePKIXPublicKey(publicPEM.Bytes)
+	}
+	if err != nil {
+		return nil, xerror.EInternalError("can't parse PEM file", err)
+	}
+	return publicKey, nil
+}
+
+// MarshalAnyPrivateKey encodes the RSA keys as PKCS1 to stay compatible
+// with MarshalPrivateKey and the other keys as PKCS8.
+func MarshalAnyPrivateKey(key crypto.Signer) ([]byte, error) {
+	if rsaKey, ok := key.(*rsa.PrivateKey); ok {
+		return MarshalPrivateKey(rsaKey)
+	}
+
+	bs, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, xerror.EInternalError("can't marshal private key", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: bs,
+	}), nil
+}
+
+// UnmarshalAnyPrivateKey decodes the keys encoded with MarshalAnyPrivateKey or MarshalPrivateKey.
+func UnmarshalAnyPrivateKey(bs []byte) (crypto.Signer, error) {
+	privatePEM, _ := pem.Decode(bs)
+	if privatePEM == nil {
+		return nil, xerror.EInternalError("failed to decode PEM block", nil)
+	}
+
+	if privatePEM.Type == "RSA PRIVATE KEY" {
+		return UnmarshalPrivateKey(bs)
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(privatePEM.Bytes)
+	if err != nil {
+		return nil, xerror.EInternalError("failed to parse private key from a PEM block", err)
+	}
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, xerror.EInternalError("unsupported private key type", nil)
+	}
+	return signer, nil
+}
@@ -5,10 +5,12 @@
 package xcrypto
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -52,3 +54,49 @@ func TestUnMarshalPrivateKey(t *testing.T) {
 
 	assert.True(t, key.Equal(pk))
 }
+
+func TestMarshalAnyKey(t *testing.T) {
+	for _, keyType := range []KeyType{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		private, err := GenerateKeyOf(keyType)
+		require.NoError(t, err)
+
+		bs, err := MarshalAnyPublicKey(private.Public())
+		require.NoError(t, err)
+		public, err := UnmarshalAnyPublicKey(bs)
+		require.NoError(t, err)
+		assert.True(t, private.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(public), keyType)
+
+		bs, err = MarshalAnyPrivateKey(private)
+		require.NoError(t, err)
+		decoded, err := UnmarshalAnyPrivateKey(bs)
+		require.NoError(t, err)
+		assert.True(t, private.(interface{ Equal(crypto.PrivateKey) bool }).Equal(decoded), keyType)
+	}
+}
+
+func TestRSAKeeper(t *testing.T) {
+	keeper, err := NewRSAKeeper(t.TempDir())
+	require.NoError(t, err)
+
+	rsaKey, err := GenerateKeyOf(KeyTypeRSA)
+	require.NoError(t, err)
+	edKey, err := GenerateKeyOf(KeyTypeEd25519)
+	require.NoError(t, err)
+
+	rsaID, edID := uuid.New(), uuid.New()
+	require.NoError(t, keeper.AddKey(rsaID, rsaKey.Public()))
+	require.NoError(t, keeper.AddKey(edID, edKey.Public()))
+
+	key, err := keeper.GetKey(edID)
+	require.NoError(t, err)
+	assert.Equal(t, edKey.Public(), key)
+
+	// the RSA keys are still stored as PKCS1
+	key, err = keeper.GetKey(rsaID)
+	require.NoError(t, err)
+	assert.Equal(t, rsaKey.Public(), key)
+
+	keys, err := keeper.ListKeys()
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+}
@@ -5,7 +5,7 @@
 package xcrypto
 
 import (
-	"crypto/rsa"
+	"crypto"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -16,6 +16,8 @@ import (
 	"go.uber.org/zap"
 )
 
+// RSAKeeper stores the public keys of any type supported by MarshalAnyPublicKey
+// as the PEM files named by the key id. The name is kept for the compatibility.
 type RSAKeeper struct {
 	lock    sync.RWMutex
 	root    string
@@ -24,7 +26,7 @@ type RSAKeeper struct {
 
 type KeyInfo struct {
 	Id  uuid.UUID
-	Key *rsa.PublicKey
+	Key crypto.PublicKey
 }
 
 func NewRSAKeeper(root string) (*RSAKeeper, error) {
@@ -95,21 +97,21 @@ func (keeper *RSAKeeper) DeleteKey(keyUUID uuid.UUID) error {
 	return nil
 }
 
-func (keeper *RSAKeeper) GetKey(keyUUID uuid.UUID) (*rsa.PublicKey, error) {
+func (keeper *RSAKeeper) GetKey(keyUUID uuid.UUID) (crypto.PublicKey, error) {
 	keeper.lock.RLock()
 	defer keeper.lock.RUnlock()
 
 	return keeper.readKey(keyUUID)
 }
 
-func (keeper *RSAKeeper) AddKey(keyUUID uuid.UUID, key *rsa.PublicKey) error {
+func (keeper *RSAKeeper) AddKey(keyUUID uuid.UUID, key crypto.PublicKey) error {
 	keeper.lock.Lock()
 	defer keeper.lock.Unlock()
 
 	return keeper.writeKey(keyUUID, key, os.O_CREATE|os.O_EXCL|os.O_WRONLY)
 }
 
-func (keeper *RSAKeeper) ChangeKey(keyUUID uuid.UUID, key *rsa.PublicKey) error {
+func (keeper *RSAKeeper) ChangeKey(keyUUID uuid.UUID, key crypto.PublicKey) error {
 	keeper.lock.Lock()
 	defer keeper.lock.Unlock()
 
@@ -128,7 +130,7 @@ func makeError(err error, opMessage string) error {
 	}
 }
 
-func (keeper *RSAKeeper) readKey(keyUUID uuid.UUID) (*rsa.PublicKey, error) {
+func (keeper *RSAKeeper) readKey(keyUUID uuid.UUID) (crypto.PublicKey, error) {
 	if keyUUID == uuid.Nil {
 		return nil, xerror.EInvalidArgument("nil uuid is not allowed", nil)
 	}
@@ -138,15 +140,15 @@ func (keeper *RSAKeeper) readKey(keyUUID uuid.UUID) (*rsa.PublicKey, error) {
 		return nil, makeError(err, "can't read key")
 	}
 
-	return UnmarshalPublicKey(pemBytes)
+	return UnmarshalAnyPublicKey(pemBytes)
 }
 
-func (keeper *RSAKeeper) writeKey(keyUUID uuid.UUID, key *rsa.PublicKey, osFlags int) error {
+func (keeper *RSAKeeper) writeKey(keyUUID uuid.UUID, key crypto.PublicKey, osFlags int) error {
 	if keyUUID == uuid.Nil {
 		return xerror.EInvalidArgument("nil uuid is not allowed", nil)
 	}
 
-	keyBytes, err := MarshalPublicKey(key)
+	keyBytes, err := MarshalAnyPublicKey(key)
 	if err != nil {
 		return err
 	}
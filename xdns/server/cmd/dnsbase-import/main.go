@@ -0,0 +1,137 @@
+// dnsbase-import builds or incrementally updates the blocklist DB
+// from the hosts files, plain domain lists, Adblock-style lists and RPZ zones.
+//
+// Usage:
+//
+//	dnsbase-import -db blocklist.sqlite3 \
+//	    -list hosts:ads,tracking:/etc/lists/hosts.txt \
+//	    -list adblock:ads:https://example.com/filter.txt
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/xdns/server/dnsbase"
+)
+
+type listSource struct {
+	format     dnsbase.ListFormat
+	categories dnsbase.Categories
+	location   string
+}
+
+type listFlag []listSource
+
+func (l *listFlag) String() string {
+	ss := make([]string, 0, len(*l))
+	for _, s := range *l {
+		ss = append(ss, string(s.format)+":"+strings.Join(s.categories, ",")+":"+s.location)
+	}
+	return strings.Join(ss, " ")
+}
+
+// Set parses the "format:cat1,cat2:path-or-url" source definition.
+func (l *listFlag) Set(v string) error {
+	parts := strings.SplitN(v, ":", 3)
+	if len(parts) != 3 {
+		return fmt.Errorf("expecting format:categories:location, got `%s`", v)
+	}
+
+	format, err := dnsbase.ParseListFormat(parts[0])
+	if err != nil {
+		return err
+	}
+
+	var cats dnsbase.Categories
+	for _, c := range strings.Split(parts[1], ",") {
+		if c = strings.TrimSpace(c); len(c) > 0 {
+			cats = append(cats, c)
+		}
+	}
+	if len(cats) == 0 {
+		return fmt.Errorf("no categories given for `%s`", parts[2])
+	}
+
+	*l = append(*l, listSource{format: format, categories: cats, location: parts[2]})
+	return nil
+}
+
+func main() {
+	var lists listFlag
+	dbPath := flag.String("db", "", "path to the blocklist DB, created if not exists")
+	dryRun := flag.Bool("dry-run", false, "print the diff stats without writing to the DB")
+	flag.Var(&lists, "list", "list source as format:cat1,cat2:path-or-url, formats: hosts, domains, adblock, rpz (repeatable)")
+	flag.Parse()
+
+	if len(*dbPath) == 0 || len(lists) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err := run(*dbPath, lists, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+func run(dbPath string, lists []listSource, dryRun bool) error {
+	rs := make(dnsbase.Ruleset)
+	for _, src := range lists {
+		added, skipped, err := readSource(rs, src)
+		if err != nil {
+			return fmt.Errorf("%s: %w", src.location, err)
+		}
+		fmt.Printf("%s: %d entries accepted, %d skipped\n", src.location, added, skipped)
+	}
+
+	w, err := dnsbase.NewWriter(dbPath)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+
+	stats, err := w.Sync(rs, dryRun)
+	if err != nil {
+		return err
+	}
+
+	prefix := ""
+	if dryRun {
+		prefix = "(dry run) "
+	}
+	fmt.Printf("%s%s: %d domains total, %s\n", prefix, dbPath, len(rs), stats)
+	return nil
+}
+
+func readSource(rs dnsbase.Ruleset, src listSource) (int, int, error) {
+	r, err := openSource(src.location)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	return rs.ReadList(r, src.format, src.categories)
+}
+
+func openSource(location string) (io.ReadCloser, error) {
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.Open(location)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("unexpected HTTP status %s", resp.Status)
+	}
+	return resp.Body, nil
+}
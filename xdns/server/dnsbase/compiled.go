@@ -0,0 +1,184 @@
+package dnsbase
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	errInvalidDomain = errors.New("lookup: invalid domain name given")
+	errNoMatches     = errors.New("lookup: no matches")
+)
+
+type nodeKey struct {
+	parentID int64
+	name     string
+}
+
+// compiledReader is the in-memory copy of the DB built by the reader format.
+// The lookup semantic is the same as reader.Lookup provides, but
+// it takes no locks and performs no allocations, so it is safe to use
+// from many goroutines at once.
+// Returned domains are shared between callers and must not be modified.
+type compiledReader struct {
+	nodes map[nodeKey]*Domain
+}
+
+// NewCompiledReader loads the whole DB into memory,
+// the DB file is not used after the function returns.
+func NewCompiledReader(path string) (*compiledReader, error) {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return nil, fmt.Errorf("compiled: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, parent_id, name, category FROM domains")
+	if err != nil {
+		return nil, fmt.Errorf("compiled: failed to query domains: %w", err)
+	}
+	defer rows.Close()
+
+	byID := make(map[int64]*Domain)
+	for rows.Next() {
+		dom := &Domain{}
+		if err := rows.Scan(&dom.ID, &dom.ParentID, &dom.Name, &dom.Categories); err != nil {
+			return nil, fmt.Errorf("compiled: failed to scan results: %w", err)
+		}
+		byID[dom.ID] = dom
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("compiled: failed to query domains: %w", err)
+	}
+
+	c := &compiledReader{
+		nodes: make(map[nodeKey]*Domain, len(byID)),
+	}
+	for _, dom := range byID {
+		dom.Parent = byID[dom.ParentID]
+		c.nodes[nodeKey{parentID: dom.ParentID, name: dom.Name}] = dom
+	}
+
+	return c, nil
+}
+
+func (c *compiledReader) Lookup(name string) (*Domain, error) {
+	if len(name) < 2 {
+		return nil, errInvalidDomain
+	}
+
+	name = normalizeDomain(name)
+	end := strings.LastIndexByte(name, '.')
+	if end < 0 {
+		return nil, errInvalidDomain
+	}
+
+	// lookup parent category, like ".org", ".ru", ".com", etc
+	parent, ok := c.nodes[nodeKey{name: name[end+1:]}]
+	if !ok {
+		return nil, errNoMatches
+	}
+
+	// walk labels from right to left without splitting the name
+	var wildcard *Domain
+	for end >= 0 {
+		start := strings.LastIndexByte(name[:end], '.') + 1
+		sub, exact := c.nodes[nodeKey{parentID: parent.ID, name: name[start:end]}]
+		if wc, ok := c.nodes[nodeKey{parentID: parent.ID, name: "*"}]; ok {
+			wildcard = wc
+		}
+
+		if !exact {
+			// exact match does not found,
+			// try to return the wildcard match, if any.
+			if wildcard != nil {
+				return wildcard, nil
+			}
+			return nil, errNoMatches
+		}
+
+		parent = sub
+		end = start - 1
+	}
+
+	// same as for the reader: the name may point to the intermediate node
+	if !parent.isChild() {
+		return nil, errNoMatches
+	}
+
+	return parent, nil
+}
+
+// Len returns the number of nodes loaded.
+func (c *compiledReader) Len() int {
+	return len(c.nodes)
+}
+
+// compiledFTLReader is the in-memory copy of the pi-hole's gravity table,
+// see compiledReader for the concurrency notes.
+type compiledFTLReader struct {
+	domains map[string]*Domain
+}
+
+// NewCompiledFTLReader loads the whole gravity table into memory,
+// the DB file is not used after the function returns.
+func NewCompiledFTLReader(path string) (*compiledFTLReader, error) {
+	db, err := openReadOnly(path)
+	if err != nil {
+		return nil, fmt.Errorf("ftl: %w", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("select domain from gravity")
+	if err != nil {
+		return nil, fmt.Errorf("ftl: failed to query gravity: %w", err)
+	}
+	defer rows.Close()
+
+	c := &compiledFTLReader{
+		domains: make(map[string]*Domain),
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("ftl: failed to scan results: %w", err)
+		}
+		c.domains[name] = &Domain{Name: name}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("ftl: failed to query gravity: %w", err)
+	}
+
+	return c, nil
+}
+
+func (c *compiledFTLReader) Lookup(name string) (*Domain, error) {
+	if len(name) == 0 {
+		return nil, errInvalidDomain
+	}
+
+	if dom, ok := c.domains[normalizeDomain(name)]; ok {
+		return dom, nil
+	}
+	return nil, errNoMatches
+}
+
+// Len returns the number of domains loaded.
+func (c *compiledFTLReader) Len() int {
+	return len(c.domains)
+}
+
+func openReadOnly(path string) (*sql.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("failed to stat the DB at path %s: %w", path, err)
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro&immutable=true&_journal_mode=OFF")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open DNS database at %s: %w", path, err)
+	}
+	return db, nil
+}
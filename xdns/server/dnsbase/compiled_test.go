@@ -0,0 +1,170 @@
+package dnsbase
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeRules(t testing.TB, path string, rules map[string]string) {
+	wr, err := NewWriter(path)
+	require.NoError(t, err)
+	defer wr.Close()
+
+	rs := make(Ruleset, len(rules))
+	for rule, category := range rules {
+		require.NoError(t, rs.Add(rule, Categories{category}))
+	}
+	_, err = wr.Sync(rs, false)
+	require.NoError(t, err)
+}
+
+func TestCompiledReader(t *testing.T) {
+	p, closer := dbPath()
+	defer closer()
+	writeRules(t, p, domainRules)
+
+	rd, err := NewReader(p)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	c, err := NewCompiledReader(p)
+	require.NoError(t, err)
+
+	for domain, expectedCategory := range checkResults {
+		dom, err := c.Lookup(domain)
+		require.NoError(t, err, domain)
+		assert.Contains(t, dom.Categories, expectedCategory)
+
+		expected, err := rd.Lookup(domain)
+		require.NoError(t, err)
+		assert.Equal(t, expected.ID, dom.ID)
+	}
+
+	for _, domain := range append(nonExistentDomains, "", "x", "sub1.dom..c", "foo.net") {
+		_, err := c.Lookup(domain)
+		assert.Error(t, err, "given %s", domain)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		_, _ = c.Lookup("subsub.sub33.dom.a")
+		_, _ = c.Lookup("sub1.dom.c")
+	})
+	assert.Zero(t, allocs)
+}
+
+func writeGravity(t testing.TB, path string, domains []string) {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=rwc")
+	require.NoError(t, err)
+	defer db.Close()
+
+	tx, err := db.Begin()
+	require.NoError(t, err)
+	_, err = tx.Exec("create table gravity (domain text not null, adlist_id integer)")
+	require.NoError(t, err)
+	for _, d := range domains {
+		_, err = tx.Exec("insert into gravity(domain) values (?)", d)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tx.Commit())
+}
+
+func TestCompiledFTLReader(t *testing.T) {
+	p, closer := dbPath()
+	defer closer()
+
+	writeGravity(t, p, []string{"ads.example.com", "tracker.net"})
+
+	c, err := NewCompiledFTLReader(p)
+	require.NoError(t, err)
+	assert.Equal(t, 2, c.Len())
+
+	dom, err := c.Lookup("ads.example.com.")
+	require.NoError(t, err)
+	assert.Equal(t, "ads.example.com", dom.Name)
+
+	_, err = c.Lookup("sub.tracker.net")
+	assert.Error(t, err)
+}
+
+func benchmarkRules(n int) map[string]string {
+	rules := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		switch i % 3 {
+		case 0:
+			rules[fmt.Sprintf("*.domain%d.com", i)] = "wc"
+		case 1:
+			rules[fmt.Sprintf("sub.domain%d.net", i)] = "exact"
+		default:
+			rules[fmt.Sprintf("a.b.domain%d.org", i)] = "deep"
+		}
+	}
+	return rules
+}
+
+var benchmarkNames = []string{
+	"x.y.domain300.com",
+	"sub.domain301.net",
+	"a.b.domain302.org",
+	"miss.domain302.org",
+	"www.example.com",
+}
+
+func BenchmarkLookup(b *testing.B) {
+	p, closer := dbPath()
+	defer closer()
+	writeRules(b, p, benchmarkRules(30_000))
+
+	rd, err := NewReader(p)
+	require.NoError(b, err)
+	defer rd.Close()
+
+	c, err := NewCompiledReader(p)
+	require.NoError(b, err)
+
+	readers := map[string]LookupInterface{
+		"sqlite":   rd,
+		"compiled": c,
+	}
+	for name, r := range readers {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = r.Lookup(benchmarkNames[i%len(benchmarkNames)])
+			}
+		})
+	}
+}
+
+func BenchmarkFTLLookup(b *testing.B) {
+	p, closer := dbPath()
+	defer closer()
+
+	domains := make([]string, 0, 30_000)
+	for i := 0; i < cap(domains); i++ {
+		domains = append(domains, fmt.Sprintf("sub.domain%d.net", i))
+	}
+	writeGravity(b, p, domains)
+
+	rd, err := NewFTLReader(p)
+	require.NoError(b, err)
+
+	c, err := NewCompiledFTLReader(p)
+	require.NoError(b, err)
+
+	readers := map[string]LookupInterface{
+		"sqlite":   rd,
+		"compiled": c,
+	}
+	for name, r := range readers {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				_, _ = r.Lookup(domains[i%len(domains)])
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package dnsbase
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+type ListFormat string
+
+const (
+	// ListFormatHosts is the hosts file format: "0.0.0.0 domain [domain...]".
+	ListFormatHosts ListFormat = "hosts"
+	// ListFormatDomains is the plain list, one domain per line,
+	// "*.domain" wildcards are allowed.
+	ListFormatDomains ListFormat = "domains"
+	// ListFormatAdblock is the Adblock-style list, only the "||domain^"
+	// rules are taken into account, the rest are skipped.
+	ListFormatAdblock ListFormat = "adblock"
+	// ListFormatRPZ is the DNS response policy zone file,
+	// only the QNAME triggers with the NXDOMAIN, NODATA or DROP actions are taken.
+	ListFormatRPZ ListFormat = "rpz"
+)
+
+func ParseListFormat(s string) (ListFormat, error) {
+	switch f := ListFormat(strings.ToLower(s)); f {
+	case ListFormatHosts, ListFormatDomains, ListFormatAdblock, ListFormatRPZ:
+		return f, nil
+	default:
+		return "", fmt.Errorf("unknown list format `%s`", s)
+	}
+}
+
+// hostsIgnore contains names usually present in hosts files
+// which must never get into a blocklist.
+var hostsIgnore = map[string]struct{}{
+	"localhost":             {},
+	"localhost.localdomain": {},
+	"local":                 {},
+	"broadcasthost":         {},
+	"ip6-localhost":         {},
+	"ip6-loopback":          {},
+	"ip6-localnet":          {},
+	"ip6-mcastprefix":       {},
+	"ip6-allnodes":          {},
+	"ip6-allrouters":        {},
+	"ip6-allhosts":          {},
+	"0.0.0.0":               {},
+}
+
+// Ruleset is the de-duplicated set of domains with their categories,
+// it represents the desired state of the DB, see writer.Sync.
+type Ruleset map[string]Categories
+
+// Add merges given categories into the domain's ones.
+func (rs Ruleset) Add(domain string, cats Categories) error {
+	domain = normalizeListDomain(domain)
+	if err := validateListDomain(domain); err != nil {
+		return fmt.Errorf("ruleset: invalid domain `%s`: %w", domain, err)
+	}
+	if len(cats) == 0 {
+		return fmt.Errorf("ruleset: empty categories list given for `%s`", domain)
+	}
+
+	rs[domain] = mergeCategories(rs[domain], cats)
+	return nil
+}
+
+// ReadList parses the list of the given format and tags every domain with cats.
+// Invalid entries are skipped, the number of accepted and skipped entries is returned.
+func (rs Ruleset) ReadList(r io.Reader, format ListFormat, cats Categories) (added int, skipped int, err error) {
+	add := func(domain string) {
+		if rs.Add(domain, cats) != nil {
+			skipped++
+			return
+		}
+		added++
+	}
+
+	if format == ListFormatRPZ {
+		if err := parseRPZ(r, add, func() { skipped++ }); err != nil {
+			return added, skipped, err
+		}
+		return added, skipped, nil
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+
+		switch format {
+		case ListFormatHosts:
+			if line[0] == '#' {
+				continue
+			}
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = line[:i]
+			}
+			fields := strings.Fields(line)
+			if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+				skipped++
+				continue
+			}
+			for _, name := range fields[1:] {
+				if _, ok := hostsIgnore[strings.ToLower(name)]; ok {
+					continue
+				}
+				add(name)
+			}
+
+		case ListFormatDomains:
+			if line[0] == '#' || line[0] == '!' {
+				continue
+			}
+			if i := strings.IndexByte(line, '#'); i >= 0 {
+				line = strings.TrimSpace(line[:i])
+			}
+			add(line)
+
+		case ListFormatAdblock:
+			if line[0] == '!' || line[0] == '[' {
+				continue
+			}
+			domain, ok := parseAdblockRule(line)
+			if !ok {
+				skipped++
+				continue
+			}
+			// "||domain^" matches the domain itself and all of its subdomains
+			add(domain)
+			add("*." + domain)
+
+		default:
+			return added, skipped, fmt.Errorf("ruleset: unknown list format `%s`", format)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return added, skipped, fmt.Errorf("ruleset: failed to read the list: %w", err)
+	}
+	return added, skipped, nil
+}
+
+// parseAdblockRule extracts the domain from rules like "||example.com^".
+// Exceptions, rules with modifiers, paths or wildcards are not supported.
+func parseAdblockRule(line string) (string, bool) {
+	if !strings.HasPrefix(line, "||") || !strings.HasSuffix(line, "^") {
+		return "", false
+	}
+
+	domain := line[2 : len(line)-1]
+	if len(domain) == 0 || strings.ContainsAny(domain, "/*$^|:") {
+		return "", false
+	}
+	return domain, true
+}
+
+func parseRPZ(r io.Reader, add func(string), skip func()) error {
+	zp := dns.NewZoneParser(r, ".", "")
+	apex := ""
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		hdr := rr.Header()
+		switch hdr.Rrtype {
+		case dns.TypeSOA:
+			if len(apex) == 0 {
+				apex = hdr.Name
+			}
+			continue
+		case dns.TypeNS:
+			continue
+		}
+
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
+			skip()
+			continue
+		}
+		switch cname.Target {
+		case ".", "*.", "rpz-drop.":
+		default:
+			// rpz-passthru, local data or redirects
+			skip()
+			continue
+		}
+
+		name := hdr.Name
+		if len(apex) > 0 && apex != "." {
+			if !strings.HasSuffix(name, "."+apex) {
+				skip()
+				continue
+			}
+			name = strings.TrimSuffix(name, "."+apex)
+		}
+		// non-QNAME triggers, like rpz-ip or rpz-nsdname are not supported
+		if strings.Contains(name, ".rpz-") {
+			skip()
+			continue
+		}
+		add(name)
+	}
+
+	if err := zp.Err(); err != nil {
+		return fmt.Errorf("ruleset: failed to parse RPZ: %w", err)
+	}
+	return nil
+}
+
+func normalizeListDomain(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.TrimSuffix(s, ".")
+}
+
+func validateListDomain(domain string) error {
+	if len(domain) == 0 {
+		return fmt.Errorf("empty domain")
+	}
+	if err := validateDomain(strings.Split(domain, ".")); err != nil {
+		return err
+	}
+	if _, ok := dns.IsDomainName(domain); !ok {
+		return fmt.Errorf("not a domain name")
+	}
+	if net.ParseIP(domain) != nil {
+		return fmt.Errorf("IP address given")
+	}
+	return nil
+}
+
+func mergeCategories(a, b Categories) Categories {
+	set := make(map[string]struct{}, len(a)+len(b))
+	for _, c := range a {
+		set[c] = struct{}{}
+	}
+	for _, c := range b {
+		set[c] = struct{}{}
+	}
+
+	merged := make(Categories, 0, len(set))
+	for c := range set {
+		merged = append(merged, c)
+	}
+	sort.Strings(merged)
+	return merged
+}
+
+func sameCategories(a, b Categories) bool {
+	a = mergeCategories(a, nil)
+	b = mergeCategories(b, nil)
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
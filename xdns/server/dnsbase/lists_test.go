@@ -0,0 +1,160 @@
+package dnsbase
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	hostsList = `# comment
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # inline
+0.0.0.0 0.0.0.0
+not-an-ip foo.example.com
+::1 ip6-localhost
+`
+	domainsList = `! comment
+ads.example.com
+*.spam.org
+*.broken.*.org
+`
+	adblockList = `[Adblock Plus 2.0]
+! Title: test
+||adblock.net^
+||with.options.net^$third-party
+@@||exception.net^
+||path.net/banner^
+`
+	rpzZone = `$TTL 300
+@ IN SOA localhost. root.localhost. 1 3600 600 86400 300
+  IN NS localhost.
+bad.com          CNAME .
+*.bad.com        CNAME .
+nodata.com       CNAME *.
+passthru.com     CNAME rpz-passthru.
+32.1.0.0.127.rpz-ip CNAME .
+redirect.com     A 10.0.0.1
+`
+)
+
+func TestRuleset_ReadList(t *testing.T) {
+	rs := make(Ruleset)
+
+	added, skipped, err := rs.ReadList(strings.NewReader(hostsList), ListFormatHosts, Categories{"ads"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, 1, skipped)
+
+	added, skipped, err = rs.ReadList(strings.NewReader(domainsList), ListFormatDomains, Categories{"tracking"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, 1, skipped)
+
+	added, skipped, err = rs.ReadList(strings.NewReader(adblockList), ListFormatAdblock, Categories{"ads"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, added)
+	assert.Equal(t, 3, skipped)
+
+	added, skipped, err = rs.ReadList(strings.NewReader(rpzZone), ListFormatRPZ, Categories{"malware"})
+	require.NoError(t, err)
+	assert.Equal(t, 3, added)
+	assert.Equal(t, 3, skipped)
+
+	expected := Ruleset{
+		"ads.example.com":     {"ads", "tracking"},
+		"tracker.example.com": {"ads"},
+		"*.spam.org":          {"tracking"},
+		"adblock.net":         {"ads"},
+		"*.adblock.net":       {"ads"},
+		"bad.com":             {"malware"},
+		"*.bad.com":           {"malware"},
+		"nodata.com":          {"malware"},
+	}
+	assert.Equal(t, expected, rs)
+}
+
+func TestRuleset_ReadList_rpzOrigin(t *testing.T) {
+	zone := `$ORIGIN rpz.local.
+@ 300 IN SOA localhost. root.localhost. 1 3600 600 86400 300
+bad.org CNAME .
+bad.org.elsewhere. CNAME .
+`
+	rs := make(Ruleset)
+	added, skipped, err := rs.ReadList(strings.NewReader(zone), ListFormatRPZ, Categories{"malware"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, added)
+	assert.Equal(t, 1, skipped)
+	assert.Equal(t, Ruleset{"bad.org": {"malware"}}, rs)
+}
+
+func TestWriter_Sync(t *testing.T) {
+	p, closer := dbPath()
+	defer closer()
+
+	wr, err := NewWriter(p)
+	require.NoError(t, err)
+	defer wr.Close()
+
+	initial := Ruleset{
+		"foo.bar":     {"a"},
+		"pre.foo.bar": {"b"},
+		"*.baz.net":   {"c"},
+		"x.y.z.net":   {"d"},
+	}
+	stats, err := wr.Sync(initial, false)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Added: 4}, stats)
+
+	entries, err := wr.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, initial, entries)
+
+	stats, err = wr.Sync(initial, false)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Unchanged: 4}, stats)
+
+	next := Ruleset{
+		"pre.foo.bar": {"b"},
+		"*.baz.net":   {"c", "e"},
+		"new.org":     {"f"},
+	}
+	stats, err = wr.Sync(next, true)
+	require.NoError(t, err)
+	assert.Equal(t, SyncStats{Added: 1, Updated: 1, Removed: 2, Unchanged: 1}, stats)
+
+	// dry run must not touch the DB
+	entries, err = wr.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, initial, entries)
+
+	_, err = wr.Sync(next, false)
+	require.NoError(t, err)
+
+	entries, err = wr.Entries()
+	require.NoError(t, err)
+	assert.Equal(t, next, entries)
+
+	// removed branches must be pruned, the intermediate nodes are kept only if needed
+	records, err := wr.loadRecords()
+	require.NoError(t, err)
+	for _, r := range records {
+		assert.NotEqual(t, "z", r.name)
+		assert.NotEqual(t, "y", r.name)
+	}
+
+	rd, err := NewReader(p)
+	require.NoError(t, err)
+	defer rd.Close()
+
+	_, err = rd.Lookup("foo.bar")
+	assert.Error(t, err)
+	dom, err := rd.Lookup("pre.foo.bar")
+	require.NoError(t, err)
+	assert.Equal(t, Categories{"b"}, dom.Categories)
+	dom, err = rd.Lookup("sub.baz.net")
+	require.NoError(t, err)
+	assert.Equal(t, Categories{"c", "e"}, dom.Categories)
+}
@@ -243,17 +243,6 @@ func validateDomain(parts []string) error {
 }
 
 func (w *writer) Write(domain string, cats Categories) error {
-	domain = normalizeDomain(domain)
-	parts := strings.Split(domain, ".")
-
-	if err := validateDomain(parts); err != nil {
-		return fmt.Errorf("writer: failed to validate the domain %s: %w", domain, err)
-	}
-
-	if len(cats) == 0 {
-		return fmt.Errorf("writer: empty categories list given")
-	}
-
 	tx, err := w.db.Begin()
 	if err != nil {
 		return fmt.Errorf("writer: failed to begin transaction")
@@ -267,6 +256,26 @@ func (w *writer) Write(domain string, cats Categories) error {
 		}
 	}()
 
+	if err := writeRecord(tx, domain, cats); err != nil {
+		return err
+	}
+
+	commit = true
+	return nil
+}
+
+func writeRecord(tx *sql.Tx, domain string, cats Categories) error {
+	domain = normalizeDomain(domain)
+	parts := strings.Split(domain, ".")
+
+	if err := validateDomain(parts); err != nil {
+		return fmt.Errorf("writer: failed to validate the domain %s: %w", domain, err)
+	}
+
+	if len(cats) == 0 {
+		return fmt.Errorf("writer: empty categories list given")
+	}
+
 	var parentID int64
 	for i := len(parts) - 1; i >= 0; i-- {
 		dom, err := lookupRecord(tx, parts[i], parentID)
@@ -301,12 +310,7 @@ func (w *writer) Write(domain string, cats Categories) error {
 
 	// we have the node for such a fqdn part, but it's not the child node,
 	// so turning it to the child.
-	if err := updateRecordCategories(tx, parentID, cats); err != nil {
-		return err
-	}
-
-	commit = true
-	return nil
+	return updateRecordCategories(tx, parentID, cats)
 }
 
 // TODO(nikonov): delete the whole sub-tree / prefix
@@ -0,0 +1,176 @@
+package dnsbase
+
+import (
+	"fmt"
+	"strings"
+)
+
+type SyncStats struct {
+	Added     int
+	Updated   int
+	Removed   int
+	Unchanged int
+}
+
+func (s SyncStats) String() string {
+	return fmt.Sprintf("added=%d, updated=%d, removed=%d, unchanged=%d", s.Added, s.Updated, s.Removed, s.Unchanged)
+}
+
+type record struct {
+	id       int64
+	parentID int64
+	name     string
+	cats     Categories
+	children int
+}
+
+// Entries returns every child record of the DB keyed by its full domain name.
+func (w *writer) Entries() (Ruleset, error) {
+	records, err := w.loadRecords()
+	if err != nil {
+		return nil, err
+	}
+
+	rs := make(Ruleset)
+	for _, r := range records {
+		if r.cats != nil {
+			rs[fullName(records, r)] = r.cats
+		}
+	}
+	return rs, nil
+}
+
+// Sync brings the DB to the state described by the ruleset:
+// new domains are written, categories of existing ones are updated,
+// domains missing in the ruleset are removed. All the changes are applied
+// in a single transaction, nothing is written if dryRun is set.
+func (w *writer) Sync(rs Ruleset, dryRun bool) (SyncStats, error) {
+	stats := SyncStats{}
+
+	records, err := w.loadRecords()
+	if err != nil {
+		return stats, err
+	}
+
+	existing := make(map[string]*record, len(records))
+	for _, r := range records {
+		if r.cats != nil {
+			existing[fullName(records, r)] = r
+		}
+	}
+
+	var toRemove []*record
+	for name, r := range existing {
+		if _, ok := rs[name]; !ok {
+			toRemove = append(toRemove, r)
+		}
+	}
+
+	tx, err := w.db.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("writer: failed to begin transaction")
+	}
+	commit := false
+	defer func() {
+		if commit {
+			_ = tx.Commit()
+		} else {
+			_ = tx.Rollback()
+		}
+	}()
+
+	for _, r := range toRemove {
+		if err := removeRecord(tx, records, r); err != nil {
+			return stats, err
+		}
+		stats.Removed++
+	}
+
+	for name, cats := range rs {
+		r, ok := existing[name]
+		if !ok {
+			if err := writeRecord(tx, name, cats); err != nil {
+				return stats, err
+			}
+			stats.Added++
+			continue
+		}
+
+		if sameCategories(r.cats, cats) {
+			stats.Unchanged++
+			continue
+		}
+		if err := updateRecordCategories(tx, r.id, cats); err != nil {
+			return stats, err
+		}
+		stats.Updated++
+	}
+
+	commit = !dryRun
+	return stats, nil
+}
+
+func (w *writer) loadRecords() (map[int64]*record, error) {
+	rows, err := w.db.Query("SELECT id, parent_id, name, category FROM domains")
+	if err != nil {
+		return nil, fmt.Errorf("sql: failed to query domains: %w", err)
+	}
+	defer rows.Close()
+
+	records := make(map[int64]*record)
+	for rows.Next() {
+		r := &record{}
+		if err := rows.Scan(&r.id, &r.parentID, &r.name, &r.cats); err != nil {
+			return nil, fmt.Errorf("sql: failed to scan results: %w", err)
+		}
+		records[r.id] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sql: failed to query domains: %w", err)
+	}
+
+	for _, r := range records {
+		if p, ok := records[r.parentID]; ok {
+			p.children++
+		}
+	}
+	return records, nil
+}
+
+func fullName(records map[int64]*record, r *record) string {
+	var ss []string
+	for c := r; c != nil; c = records[c.parentID] {
+		ss = append(ss, c.name)
+	}
+	return strings.Join(ss, ".")
+}
+
+// removeRecord turns the child node into the intermediate one if it has
+// children, otherwise deletes it along with parents left with no children.
+func removeRecord(db sqlExecutor, records map[int64]*record, r *record) error {
+	if r.children > 0 {
+		if _, err := db.Exec("update domains set category = NULL where id = ?", r.id); err != nil {
+			return fmt.Errorf("sql: failed to reset categories of id=%d: %w", r.id, err)
+		}
+		r.cats = nil
+		return nil
+	}
+
+	for c := r; c != nil; {
+		if _, err := db.Exec("DELETE from domains where id = ?", c.id); err != nil {
+			return fmt.Errorf("sql: failed to delete the record id=%d: %w", c.id, err)
+		}
+		delete(records, c.id)
+
+		p, ok := records[c.parentID]
+		if !ok {
+			break
+		}
+		p.children--
+		if p.children > 0 || p.cats != nil {
+			break
+		}
+		c = p
+	}
+	return nil
+}
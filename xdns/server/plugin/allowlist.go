@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"fmt"
+	"strings"
+)
+
+// allowlist holds domains that must pass even if the blocklist DB
+// says otherwise. Entries follow the dnsbase rules: "*.example.com"
+// matches any subdomain of example.com, but not example.com itself,
+// while "example.com" matches the exact name only.
+type allowlist struct {
+	exact    map[string]struct{}
+	wildcard map[string]struct{}
+}
+
+func newAllowlist(entries []string) (*allowlist, error) {
+	a := &allowlist{
+		exact:    make(map[string]struct{}, len(entries)),
+		wildcard: make(map[string]struct{}),
+	}
+
+	for _, e := range entries {
+		name := normalizeName(e)
+		parts := strings.Split(name, ".")
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("allowlist: invalid entry `%s`: too few parts", e)
+		}
+		for i := 1; i < len(parts); i++ {
+			if parts[i] == "*" {
+				return nil, fmt.Errorf("allowlist: invalid entry `%s`: only wildcards at the beginning are supported", e)
+			}
+		}
+
+		if parts[0] == "*" {
+			a.wildcard[strings.Join(parts[1:], ".")] = struct{}{}
+		} else {
+			a.exact[name] = struct{}{}
+		}
+	}
+
+	return a, nil
+}
+
+// Has reports whether the given name is allowed by any of the entries.
+func (a *allowlist) Has(name string) bool {
+	if a == nil {
+		return false
+	}
+
+	name = normalizeName(name)
+	if _, ok := a.exact[name]; ok {
+		return true
+	}
+
+	for i := strings.IndexByte(name, '.'); i >= 0; {
+		suffix := name[i+1:]
+		if _, ok := a.wildcard[suffix]; ok {
+			return true
+		}
+		next := strings.IndexByte(suffix, '.')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	return false
+}
+
+func normalizeName(s string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), "."))
+}
@@ -20,16 +20,129 @@ const (
 	pluginName = "blocklist"
 )
 
+// Policy holds the overrides of the blocklist plugin instances it's bound to,
+// use SetAllowlist and SetLocalRecords to replace them in the runtime.
+// A nil Policy has no overrides.
+type Policy struct {
+	mu        sync.RWMutex
+	allowlist *allowlist
+	zone      *localZone
+}
+
+func NewPolicy(allowlist []string, records []LocalRecord) (*Policy, error) {
+	p := &Policy{}
+	if err := p.SetAllowlist(allowlist); err != nil {
+		return nil, err
+	}
+	if err := p.SetLocalRecords(records); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// SetAllowlist replaces the set of domains that must never be blocked.
+// Entries may start with the "*." wildcard, see the dnsbase rules.
+func (p *Policy) SetAllowlist(entries []string) error {
+	a, err := newAllowlist(entries)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.allowlist = a
+	p.mu.Unlock()
+	return nil
+}
+
+// SetLocalRecords replaces the set of records answered locally.
+func (p *Policy) SetLocalRecords(records []LocalRecord) error {
+	z, err := newLocalZone(records)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	p.zone = z
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *Policy) current() (*allowlist, *localZone) {
+	if p == nil {
+		return nil, nil
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.allowlist, p.zone
+}
+
+// policies maps the names used in the Caddyfile to the policies,
+// e.g. "blocklist office" binds the plugin instance to the "office" policy.
+var policies struct {
+	mu     sync.RWMutex
+	byName map[string]*Policy
+}
+
+// RegisterPolicy makes the policy available to the "blocklist <name>" directive,
+// it must be called before the server is started.
+func RegisterPolicy(name string, p *Policy) {
+	policies.mu.Lock()
+	defer policies.mu.Unlock()
+
+	if policies.byName == nil {
+		policies.byName = make(map[string]*Policy)
+	}
+	policies.byName[name] = p
+}
+
+func UnregisterPolicy(name string) {
+	policies.mu.Lock()
+	defer policies.mu.Unlock()
+
+	delete(policies.byName, name)
+}
+
+func lookupPolicy(name string) (*Policy, bool) {
+	policies.mu.RLock()
+	defer policies.mu.RUnlock()
+
+	p, ok := policies.byName[name]
+	return p, ok
+}
+
 // blocklistPlugin implements coredns' plugin.Handler interface
 type blocklistPlugin struct {
-	Next plugin.Handler
+	Next   plugin.Handler
+	policy *Policy
 
 	mu   sync.Mutex
 	looq dnsbase.LookupInterface
+	// lockFree is set if looq is safe for the concurrent use
+	lockFree bool
 }
 
 func (b *blocklistPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
 	state := &request.Request{W: w, Req: r}
+	allow, zone := b.policy.current()
+
+	if answer, ok := zone.Lookup(state.Name(), state.QType()); ok {
+		localCount.WithLabelValues(metrics.WithServer(ctx)).Inc()
+
+		m := &dns.Msg{}
+		m.SetReply(r)
+		m.Authoritative = true
+		m.RecursionAvailable = true
+		m.Answer = answer
+		if err := w.WriteMsg(m); err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		return dns.RcodeSuccess, nil
+	}
+
+	if allow.Has(state.Name()) {
+		return plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
+	}
 
 	if b.mustPass(ctx, state.Name()) {
 		return plugin.NextOrFailure(b.Name(), b.Next, ctx, w, r)
@@ -63,8 +176,10 @@ func (b *blocklistPlugin) mustPass(ctx context.Context, name string) bool {
 		lookupDurationHist.WithLabelValues(metrics.WithServer(ctx)).Observe(float64(time.Since(start)))
 	}()
 
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	if !b.lockFree {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+	}
 	v, _ := b.looq.Lookup(name)
 	return v == nil // the name is not in block lists
 }
@@ -75,22 +190,45 @@ func (*blocklistPlugin) Name() string {
 
 func (*blocklistPlugin) Ready() bool { return true }
 
-func New(dbpath string) error {
+// New registers the blocklist plugin backed by the pi-hole's gravity DB.
+// With inMemory set the DB is loaded into memory once,
+// lookups then take no locks and do not touch the DB file.
+func New(dbpath string, inMemory bool) error {
 	if isRegistered() {
 		// the plugin has already been registered,
 		// we don't want to replace it in the runtime (yet).
 		return nil
 	}
 
-	rd, err := dnsbase.NewFTLReader(dbpath)
+	var rd dnsbase.LookupInterface
+	var err error
+	if inMemory {
+		rd, err = dnsbase.NewCompiledFTLReader(dbpath)
+	} else {
+		rd, err = dnsbase.NewFTLReader(dbpath)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to initialize blacklist db: %v", err)
 	}
 
 	dnsserver.Directives = append([]string{pluginName}, dnsserver.Directives...)
 	setupFn := func(c *caddy.Controller) error {
+		var policy *Policy
+		for c.Next() {
+			args := c.RemainingArgs()
+			if len(args) > 1 {
+				return c.ArgErr()
+			}
+			if len(args) == 1 {
+				var ok bool
+				if policy, ok = lookupPolicy(args[0]); !ok {
+					return c.Errf("unknown blocklist policy `%s`", args[0])
+				}
+			}
+		}
+
 		dnsserver.GetConfig(c).AddPlugin(func(next plugin.Handler) plugin.Handler {
-			return &blocklistPlugin{Next: next, looq: rd}
+			return &blocklistPlugin{Next: next, policy: policy, looq: rd, lockFree: inMemory}
 		})
 		return nil
 	}
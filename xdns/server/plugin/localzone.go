@@ -0,0 +1,146 @@
+package plugin
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	localRecordDefaultTTL = 60
+	// maxCNAMEChain limits in-zone CNAME chasing, protects from loops.
+	maxCNAMEChain = 8
+)
+
+// LocalRecord describes a custom record answered by the server itself
+// without asking the upstream servers and without the blocklist check.
+type LocalRecord struct {
+	Name string `yaml:"name"`
+	// Type is one of A, AAAA or CNAME.
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+	// TTL in seconds, localRecordDefaultTTL if not set.
+	TTL uint32 `yaml:"ttl"`
+}
+
+func (r LocalRecord) intoRR() (dns.RR, error) {
+	name := normalizeName(r.Name)
+	if len(name) == 0 {
+		return nil, fmt.Errorf("empty name")
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, fmt.Errorf("invalid name")
+	}
+
+	ttl := r.TTL
+	if ttl == 0 {
+		ttl = localRecordDefaultTTL
+	}
+
+	hdr := dns.RR_Header{
+		Name:  dns.Fqdn(name),
+		Class: dns.ClassINET,
+		Ttl:   ttl,
+	}
+
+	switch strings.ToUpper(r.Type) {
+	case "A":
+		ip := net.ParseIP(r.Value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IPv4 address `%s`", r.Value)
+		}
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip}, nil
+	case "AAAA":
+		ip := net.ParseIP(r.Value)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("invalid IPv6 address `%s`", r.Value)
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case "CNAME":
+		target := normalizeName(r.Value)
+		if _, ok := dns.IsDomainName(target); !ok || len(target) == 0 {
+			return nil, fmt.Errorf("invalid CNAME target `%s`", r.Value)
+		}
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(target)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported record type `%s`", r.Type)
+	}
+}
+
+// localZone keeps custom records indexed by the lowercase FQDN.
+type localZone struct {
+	records map[string][]dns.RR
+}
+
+func newLocalZone(records []LocalRecord) (*localZone, error) {
+	z := &localZone{
+		records: make(map[string][]dns.RR, len(records)),
+	}
+
+	for _, r := range records {
+		rr, err := r.intoRR()
+		if err != nil {
+			return nil, fmt.Errorf("localzone: invalid record %s %s: %w", r.Name, r.Type, err)
+		}
+
+		name := rr.Header().Name
+		existing := z.records[name]
+		for _, e := range existing {
+			// a CNAME record must not coexist with any other data (RFC 1034, 3.6.2)
+			if e.Header().Rrtype == dns.TypeCNAME || rr.Header().Rrtype == dns.TypeCNAME {
+				return nil, fmt.Errorf("localzone: CNAME record %s conflicts with other records", name)
+			}
+		}
+		z.records[name] = append(existing, rr)
+	}
+
+	return z, nil
+}
+
+// Lookup returns the answer section for the given query.
+// The second value is false if the zone knows nothing about the name,
+// so the query must be handled by the rest of the chain.
+// An empty answer with true means NODATA: the name exists, but has no
+// records of the requested type.
+func (z *localZone) Lookup(name string, qtype uint16) ([]dns.RR, bool) {
+	if z == nil || len(z.records) == 0 {
+		return nil, false
+	}
+
+	name = dns.Fqdn(normalizeName(name))
+	rrs, ok := z.records[name]
+	if !ok {
+		return nil, false
+	}
+
+	var answer []dns.RR
+	for i := 0; i < maxCNAMEChain; i++ {
+		var cname *dns.CNAME
+		for _, rr := range rrs {
+			if rr.Header().Rrtype == qtype || qtype == dns.TypeANY {
+				answer = append(answer, dns.Copy(rr))
+			} else if c, ok := rr.(*dns.CNAME); ok {
+				cname = c
+				answer = append(answer, dns.Copy(rr))
+			}
+		}
+
+		if cname == nil {
+			break
+		}
+
+		// follow the chain only within the zone, the client
+		// resolves the rest of it on its own.
+		rrs, ok = z.records[cname.Target]
+		if !ok {
+			break
+		}
+	}
+
+	return answer, true
+}
@@ -15,6 +15,13 @@ var blockedCount = promauto.NewCounterVec(prometheus.CounterOpts{
 	Help:      "Counter of requests blocked.",
 }, []string{"server"})
 
+var localCount = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: plugin.Namespace,
+	Subsystem: "blocklist",
+	Name:      "request_local_total",
+	Help:      "Counter of requests answered from the local records.",
+}, []string{"server"})
+
 var lookupDurationHist = promauto.NewHistogramVec(prometheus.HistogramOpts{
 	Namespace: plugin.Namespace,
 	Subsystem: "blocklist",
@@ -0,0 +1,124 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/coredns/coredns/plugin/pkg/dnstest"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xdns/server/dnsbase"
+)
+
+func TestAllowlist(t *testing.T) {
+	a, err := newAllowlist([]string{"*.a", "exact.b.", "*.dom.c", "UPPER.d"})
+	require.NoError(t, err)
+
+	allowed := []string{"sub.a", "sub1.sub2.a.", "exact.b", "sub.dom.c", "x.y.dom.c", "upper.d"}
+	for _, name := range allowed {
+		assert.True(t, a.Has(name), name)
+	}
+
+	denied := []string{"a", "b", "sub.exact.b", "dom.c", "c", "sub.d"}
+	for _, name := range denied {
+		assert.False(t, a.Has(name), name)
+	}
+
+	var empty *allowlist
+	assert.False(t, empty.Has("sub.a"))
+
+	for _, invalid := range []string{"*", "a", "sub.*.a"} {
+		_, err := newAllowlist([]string{invalid})
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestLocalZone(t *testing.T) {
+	z, err := newLocalZone([]LocalRecord{
+		{Name: "api.internal", Type: "A", Value: "10.0.0.1"},
+		{Name: "api.internal", Type: "A", Value: "10.0.0.2"},
+		{Name: "api.internal", Type: "AAAA", Value: "fd00::1", TTL: 300},
+		{Name: "www.internal.", Type: "cname", Value: "api.internal"},
+	})
+	require.NoError(t, err)
+
+	answer, ok := z.Lookup("API.internal.", dns.TypeA)
+	require.True(t, ok)
+	require.Len(t, answer, 2)
+	assert.Equal(t, "10.0.0.1", answer[0].(*dns.A).A.String())
+	assert.EqualValues(t, localRecordDefaultTTL, answer[0].Header().Ttl)
+
+	answer, ok = z.Lookup("www.internal.", dns.TypeAAAA)
+	require.True(t, ok)
+	require.Len(t, answer, 2)
+	assert.Equal(t, dns.TypeCNAME, answer[0].Header().Rrtype)
+	assert.Equal(t, "fd00::1", answer[1].(*dns.AAAA).AAAA.String())
+
+	// NODATA
+	answer, ok = z.Lookup("api.internal.", dns.TypeMX)
+	assert.True(t, ok)
+	assert.Empty(t, answer)
+
+	_, ok = z.Lookup("unknown.internal.", dns.TypeA)
+	assert.False(t, ok)
+
+	invalid := [][]LocalRecord{
+		{{Name: "a.internal", Type: "A", Value: "fd00::1"}},
+		{{Name: "a.internal", Type: "AAAA", Value: "10.0.0.1"}},
+		{{Name: "a.internal", Type: "MX", Value: "mx.internal"}},
+		{{Name: "", Type: "A", Value: "10.0.0.1"}},
+		{
+			{Name: "a.internal", Type: "A", Value: "10.0.0.1"},
+			{Name: "a.internal", Type: "CNAME", Value: "b.internal"},
+		},
+	}
+	for _, records := range invalid {
+		_, err := newLocalZone(records)
+		assert.Error(t, err, "%v", records)
+	}
+}
+
+type blockAll struct{}
+
+func (blockAll) Lookup(domain string) (*dnsbase.Domain, error) {
+	return &dnsbase.Domain{}, nil
+}
+
+type testWriter struct {
+	dns.ResponseWriter
+}
+
+func (testWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 53}
+}
+
+func (testWriter) WriteMsg(*dns.Msg) error {
+	return nil
+}
+
+func TestPolicy(t *testing.T) {
+	office, err := NewPolicy([]string{"*.allowed"}, []LocalRecord{{Name: "api.internal", Type: "A", Value: "10.0.0.1"}})
+	require.NoError(t, err)
+	guest, err := NewPolicy(nil, nil)
+	require.NoError(t, err)
+
+	serve := func(policy *Policy, name string) int {
+		b := &blocklistPlugin{policy: policy, looq: blockAll{}, lockFree: true}
+		r := &dns.Msg{}
+		r.SetQuestion(name, dns.TypeA)
+		code, _ := b.ServeDNS(context.Background(), dnstest.NewRecorder(testWriter{}), r)
+		return code
+	}
+
+	assert.Equal(t, dns.RcodeSuccess, serve(office, "api.internal."))
+	assert.Equal(t, dns.RcodeRefused, serve(guest, "api.internal."), "the local zone is per policy")
+	assert.Equal(t, dns.RcodeRefused, serve(guest, "sub.allowed."), "the allowlist is per policy")
+	assert.Equal(t, dns.RcodeRefused, serve(nil, "sub.allowed."))
+	// the allowed name goes to the next plugin, there's none
+	assert.Equal(t, dns.RcodeServerFailure, serve(office, "sub.allowed."))
+
+	require.NoError(t, guest.SetAllowlist([]string{"*.allowed"}))
+	assert.Equal(t, dns.RcodeServerFailure, serve(guest, "sub.allowed."))
+}
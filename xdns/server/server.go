@@ -9,6 +9,7 @@ package server
 import (
 	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/coredns/caddy"
 	"github.com/vpnhouse/common-lib-go/xdns/server/plugin"
@@ -38,15 +39,26 @@ type Config struct {
 	// todo: support tls forwarders
 	ForwardServers []string `yaml:"forward_servers"`
 	BlacklistDB    string   `yaml:"blacklist_db"`
+	// InMemoryDB loads the BlacklistDB into memory on start
+	// instead of querying it on every request.
+	InMemoryDB bool `yaml:"in_memory_db"`
+	// Allowlist overrides the BlacklistDB, wildcards like "*.example.com" are supported.
+	Allowlist []string `yaml:"allowlist"`
+	// LocalRecords are answered by the server itself, bypassing both
+	// the blocklist and the forward servers.
+	LocalRecords []plugin.LocalRecord `yaml:"local_records"`
 }
 
-func (c Config) intoCaddyfile() caddy.CaddyfileInput {
+// serverCount makes the policy names unique across the servers
+var serverCount atomic.Int64
+
+func (c Config) intoCaddyfile(policyName string) caddy.CaddyfileInput {
 	head := ".:53 {\n"
 	tail := "\n}"
 
 	opts := []string{
 		"errors",
-		"blocklist",
+		"blocklist " + policyName,
 	}
 
 	if len(c.ForwardServers) == 0 {
@@ -74,7 +86,9 @@ func (c Config) intoCaddyfile() caddy.CaddyfileInput {
 }
 
 type server struct {
-	instance *caddy.Instance
+	instance   *caddy.Instance
+	policy     *plugin.Policy
+	policyName string
 }
 
 func (s *server) Shutdown() error {
@@ -82,6 +96,7 @@ func (s *server) Shutdown() error {
 		return err
 	}
 
+	plugin.UnregisterPolicy(s.policyName)
 	s.instance = nil
 	return nil
 }
@@ -90,18 +105,37 @@ func (s *server) Running() bool {
 	return s.instance != nil
 }
 
+// SetAllowlist replaces the allowlist in the runtime, no restart required.
+func (s *server) SetAllowlist(entries []string) error {
+	return s.policy.SetAllowlist(entries)
+}
+
+// SetLocalRecords replaces the local records in the runtime, no restart required.
+func (s *server) SetLocalRecords(records []plugin.LocalRecord) error {
+	return s.policy.SetLocalRecords(records)
+}
+
 func NewFilteringServer(cfg Config) (*server, error) {
-	if err := plugin.New(cfg.BlacklistDB); err != nil {
+	if err := plugin.New(cfg.BlacklistDB, cfg.InMemoryDB); err != nil {
+		return nil, err
+	}
+	policy, err := plugin.NewPolicy(cfg.Allowlist, cfg.LocalRecords)
+	if err != nil {
 		return nil, err
 	}
+	policyName := fmt.Sprintf("server-%d", serverCount.Add(1))
+	plugin.RegisterPolicy(policyName, policy)
 
-	instance, err := caddy.Start(cfg.intoCaddyfile())
+	instance, err := caddy.Start(cfg.intoCaddyfile(policyName))
 	if err != nil {
+		plugin.UnregisterPolicy(policyName)
 		return nil, err
 	}
 
 	s := &server{
-		instance: instance,
+		instance:   instance,
+		policy:     policy,
+		policyName: policyName,
 	}
 
 	return s, nil
@@ -26,7 +26,9 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/net/idna"
 
+	"github.com/vpnhouse/common-lib-go/geoip"
 	"github.com/vpnhouse/common-lib-go/xerror"
+	"github.com/vpnhouse/common-lib-go/xlimits/ratelimit"
 )
 
 var initMetricsOnce sync.Once
@@ -81,6 +83,23 @@ func WithSSL(cfg *tls.Config) Option {
 	}
 }
 
+// WithProxyProtocol accepts the PROXY protocol v1/v2 header from the trusted peers,
+// use the same resolver to get the client address from the request.
+func WithProxyProtocol(trusted *geoip.TrustedResolver) Option {
+	return func(w *Server) {
+		w.wrapListener = trusted.Listener
+		w.connContext = geoip.ConnContext
+	}
+}
+
+// WithRateLimit limits the requests by the first matching rule,
+// see ratelimit.Middleware.
+func WithRateLimit(rules ...ratelimit.Rule) Option {
+	return func(w *Server) {
+		w.router.Use(ratelimit.New(rules...).Handler)
+	}
+}
+
 func WithPprof() Option {
 	return func(w *Server) {
 		w.router.Mount("/debug", chi_middleware.Profiler())
@@ -109,6 +128,9 @@ type Server struct {
 	tlsConfig *tls.Config
 	router    chi.Router
 	disablev2 bool
+
+	wrapListener func(net.Listener) net.Listener
+	connContext  func(ctx context.Context, c net.Conn) context.Context
 }
 
 // Run starts the http server asynchronously.
@@ -118,6 +140,7 @@ func (w *Server) Run(addr string) error {
 		Addr:        addr,
 		TLSConfig:   w.tlsConfig,
 		ReadTimeout: 10 * time.Second,
+		ConnContext: w.connContext,
 	}
 
 	if w.disablev2 {
@@ -128,6 +151,9 @@ func (w *Server) Run(addr string) error {
 	if err != nil {
 		return xerror.EInternalError("failed to start http listener", err, zap.String("addr", addr))
 	}
+	if w.wrapListener != nil {
+		lis = w.wrapListener(lis)
+	}
 
 	withTLS := w.tlsConfig != nil
 	zap.L().Info("starting HTTP server", zap.String("addr", addr), zap.Bool("with_tls", withTLS))
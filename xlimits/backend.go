@@ -0,0 +1,70 @@
+package xlimits
+
+import (
+	"context"
+	"time"
+)
+
+// FailureMode defines what limiters do when the backend is unreachable.
+type FailureMode int
+
+const (
+	// FailOpen lets the requests through, the limits are not enforced.
+	FailOpen FailureMode = iota
+	// FailClosed rejects the requests as if the limit has been hit.
+	FailClosed
+)
+
+// RecentBackend keeps the hit counters of Recent.
+type RecentBackend interface {
+	// Incr increments the counter of the key, a new counter expires after period.
+	Incr(key string, period time.Duration) (int, error)
+	// Decr decrements the counter of the key removing it on zero.
+	Decr(key string) (int, error)
+}
+
+// BlockerBackend keeps the slots of Blocker.
+type BlockerBackend interface {
+	// Acquire blocks until one of max slots of id is taken or ctx is done.
+	Acquire(ctx context.Context, id string, max int) error
+	// Release frees the slot of id taken by Acquire.
+	Release(id string) error
+}
+
+type (
+	Option  func(opts *options)
+	options struct {
+		recent  RecentBackend
+		blocker BlockerBackend
+		failure FailureMode
+	}
+)
+
+// WithRecentBackend replaces the in-memory counters of Recent.
+func WithRecentBackend(backend RecentBackend) Option {
+	return func(opts *options) {
+		opts.recent = backend
+	}
+}
+
+// WithBlockerBackend replaces the in-memory semaphores of Blocker.
+func WithBlockerBackend(backend BlockerBackend) Option {
+	return func(opts *options) {
+		opts.blocker = backend
+	}
+}
+
+// WithFailureMode sets the behaviour on the backend errors, FailOpen by default.
+func WithFailureMode(mode FailureMode) Option {
+	return func(opts *options) {
+		opts.failure = mode
+	}
+}
+
+func newOptions(opts []Option) options {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
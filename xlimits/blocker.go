@@ -2,6 +2,7 @@ package xlimits
 
 import (
 	"context"
+	"errors"
 	"sync"
 
 	"github.com/vpnhouse/common-lib-go/xerror"
@@ -10,31 +11,80 @@ import (
 )
 
 type Blocker struct {
-	lock      sync.Mutex
-	maxConn   int64
-	consumers map[string]*consumer
+	backend BlockerBackend
+	maxConn int
+	failure FailureMode
 }
 
+// consumer is the slot taken by Acquire.
 type consumer struct {
+	// held is false if the slot was granted without the backend, see FailOpen.
+	held bool
+}
+
+func NewBlocker(maxConn int, opts ...Option) *Blocker {
+	options := newOptions(opts)
+	if options.blocker == nil {
+		options.blocker = newMemoryBlocker()
+	}
+
+	return &Blocker{
+		backend: options.blocker,
+		maxConn: maxConn,
+		failure: options.failure,
+	}
+}
+
+func (s *Blocker) Acquire(ctx context.Context, id string) (*consumer, error) {
+	err := s.backend.Acquire(ctx, id, s.maxConn)
+	if err == nil {
+		return &consumer{held: true}, nil
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) || s.failure == FailClosed {
+		return nil, xerror.EUnavailable("unavailable", err)
+	}
+
+	zap.L().Error("Blocker backend failed, letting the consumer through", zap.String("id", id), zap.Error(err))
+	return &consumer{}, nil
+}
+
+func (s *Blocker) Release(id string, c *consumer) {
+	if !c.held {
+		return
+	}
+	c.held = false
+
+	err := s.backend.Release(id)
+	if err != nil {
+		zap.L().Error("Failed to release blocker slot", zap.String("id", id), zap.Error(err))
+	}
+}
+
+type memoryBlocker struct {
+	lock      sync.Mutex
+	consumers map[string]*memoryConsumer
+}
+
+type memoryConsumer struct {
 	limit *semaphore.Weighted
 	usage int
 }
 
-func NewBlocker(maxConn int) *Blocker {
-	return &Blocker{
-		maxConn:   int64(maxConn),
-		consumers: make(map[string]*consumer),
+func newMemoryBlocker() *memoryBlocker {
+	return &memoryBlocker{
+		consumers: make(map[string]*memoryConsumer),
 	}
 }
 
-func (s *Blocker) take(id string) *consumer {
+func (s *memoryBlocker) take(id string, max int) *memoryConsumer {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	c, loaded := s.consumers[id]
 	if !loaded {
-		c = &consumer{
-			limit: semaphore.NewWeighted(s.maxConn),
+		c = &memoryConsumer{
+			limit: semaphore.NewWeighted(int64(max)),
 		}
 		s.consumers[id] = c
 	}
@@ -44,34 +94,40 @@ func (s *Blocker) take(id string) *consumer {
 
 }
 
-func (s *Blocker) put(id string) {
+func (s *memoryBlocker) put(id string) *memoryConsumer {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
 	c, loaded := s.consumers[id]
 	if !loaded {
 		zap.L().Error("Can't put unknown consumer")
-		return
+		return nil
 	}
 
 	c.usage -= 1
 	if c.usage == 0 {
 		delete(s.consumers, id)
 	}
+	return c
 }
 
-func (s *Blocker) Acquire(ctx context.Context, id string) (*consumer, error) {
-	c := s.take(id)
+func (s *memoryBlocker) Acquire(ctx context.Context, id string, max int) error {
+	c := s.take(id, max)
 	err := c.limit.Acquire(ctx, 1)
 	if err != nil {
 		s.put(id)
-		return nil, xerror.EUnavailable("unavailable", err)
+		return err
 	}
 
-	return c, nil
+	return nil
 }
 
-func (s *Blocker) Release(id string, c *consumer) {
+func (s *memoryBlocker) Release(id string) error {
+	c := s.put(id)
+	if c == nil {
+		return errors.New("unknown consumer")
+	}
+
 	c.limit.Release(1)
-	s.put(id)
+	return nil
 }
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/vpnhouse/common-lib-go/auth"
+	"github.com/vpnhouse/common-lib-go/geoip"
+	"github.com/vpnhouse/common-lib-go/keystore"
+)
+
+// KeyFunc extracts the key the requests are counted by,
+// false means the rule does not apply to the request.
+type KeyFunc func(r *http.Request) (string, bool)
+
+// ByIP counts the requests by the client IP. The forwarding headers are believed
+// only if the peer is the proxy trusted by the resolver, nil means the peer address
+// is always used, so the clients can't get the new bucket by spoofing the headers.
+func ByIP(trusted *geoip.TrustedResolver) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		ip := trusted.Resolve(r).String()
+		return "ip:" + ip, len(ip) > 0
+	}
+}
+
+// ByUserID counts the requests by the user_id claim of the bearer token,
+// the requests without a valid token are skipped.
+func ByUserID(checker *auth.JWTChecker) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		token, ok := bearerToken(r.Header.Get("Authorization"))
+		if !ok {
+			return "", false
+		}
+
+		var claims auth.ClientClaims
+		if err := checker.Parse(token, &claims); err != nil || len(claims.UserId) == 0 {
+			return "", false
+		}
+		return "user:" + claims.UserId, true
+	}
+}
+
+// ByAPIKey counts the requests by the owner of the API key passed in the header,
+// the bearer prefix is stripped. Unknown keys are skipped.
+func ByAPIKey(ks keystore.Keystore, header string) KeyFunc {
+	return func(r *http.Request) (string, bool) {
+		key := r.Header.Get(header)
+		if token, ok := bearerToken(key); ok {
+			key = token
+		}
+		if len(key) == 0 {
+			return "", false
+		}
+
+		who, ok := ks.Authorize(key)
+		if !ok {
+			return "", false
+		}
+		return "key:" + who, true
+	}
+}
+
+func bearerToken(value string) (string, bool) {
+	parts := strings.Fields(value)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return "", false
+	}
+	return parts[1], true
+}
@@ -0,0 +1,173 @@
+// Package ratelimit implements the token-bucket and sliding-window-log
+// rate limiters along with the HTTP middleware applying them per route.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Decision is the state of the limit after the request has been counted.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Window the limit is defined for.
+	Window time.Duration
+	// Reset is the time until the quota is fully restored.
+	Reset time.Duration
+	// RetryAfter is the time until the next request is allowed, zero if allowed.
+	RetryAfter time.Duration
+}
+
+type Limiter interface {
+	// Allow counts the request of the key.
+	Allow(key string) Decision
+}
+
+// TokenBucket allows bursts of up to limit requests,
+// the tokens are refilled evenly at limit per period.
+type TokenBucket struct {
+	limit  int
+	period time.Duration
+	rate   float64 // tokens per nanosecond
+	now    func() time.Time
+
+	lock      sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(limit int, period time.Duration) *TokenBucket {
+	return &TokenBucket{
+		limit:   limit,
+		period:  period,
+		rate:    float64(limit) / float64(period),
+		now:     time.Now,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (tb *TokenBucket) Allow(key string) Decision {
+	tb.lock.Lock()
+	defer tb.lock.Unlock()
+
+	now := tb.now()
+	tb.sweep(now)
+
+	b, ok := tb.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(tb.limit), last: now}
+		tb.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(tb.limit), b.tokens+float64(now.Sub(b.last))*tb.rate)
+	b.last = now
+
+	d := Decision{Limit: tb.limit, Window: tb.period}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = tb.duration(1 - b.tokens)
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = tb.duration(float64(tb.limit) - b.tokens)
+	return d
+}
+
+func (tb *TokenBucket) duration(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens / tb.rate))
+}
+
+// sweep drops the full buckets once per period, they are the same as the missing ones.
+func (tb *TokenBucket) sweep(now time.Time) {
+	if now.Sub(tb.lastSweep) < tb.period {
+		return
+	}
+	tb.lastSweep = now
+
+	for key, b := range tb.buckets {
+		if now.Sub(b.last) >= tb.period {
+			delete(tb.buckets, key)
+		}
+	}
+}
+
+// SlidingWindow allows up to limit requests within any window,
+// it keeps the timestamps of the requests allowed within the last window.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+	now    func() time.Time
+
+	lock      sync.Mutex
+	logs      map[string][]time.Time
+	lastSweep time.Time
+}
+
+func NewSlidingWindow(limit int, window time.Duration) *SlidingWindow {
+	return &SlidingWindow{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		logs:   make(map[string][]time.Time),
+	}
+}
+
+func (sw *SlidingWindow) Allow(key string) Decision {
+	sw.lock.Lock()
+	defer sw.lock.Unlock()
+
+	now := sw.now()
+	sw.sweep(now)
+
+	log := sw.trim(sw.logs[key], now)
+	d := Decision{Limit: sw.limit, Window: sw.window}
+	if len(log) < sw.limit {
+		log = append(log, now)
+		d.Allowed = true
+	} else if len(log) > 0 {
+		d.RetryAfter = log[0].Add(sw.window).Sub(now)
+	}
+	sw.logs[key] = log
+
+	d.Remaining = sw.limit - len(log)
+	if len(log) > 0 {
+		d.Reset = log[len(log)-1].Add(sw.window).Sub(now)
+	}
+	return d
+}
+
+// trim drops the timestamps out of the window reusing the slice.
+func (sw *SlidingWindow) trim(log []time.Time, now time.Time) []time.Time {
+	idx := 0
+	for idx < len(log) && now.Sub(log[idx]) >= sw.window {
+		idx++
+	}
+	if idx == 0 {
+		return log
+	}
+	return append(log[:0], log[idx:]...)
+}
+
+func (sw *SlidingWindow) sweep(now time.Time) {
+	if now.Sub(sw.lastSweep) < sw.window {
+		return
+	}
+	sw.lastSweep = now
+
+	for key, log := range sw.logs {
+		log = sw.trim(log, now)
+		if len(log) == 0 {
+			delete(sw.logs, key)
+		} else {
+			sw.logs[key] = log
+		}
+	}
+}
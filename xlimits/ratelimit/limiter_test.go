@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func TestTokenBucket(t *testing.T) {
+	c := &clock{now: time.Unix(1700000000, 0)}
+	tb := NewTokenBucket(2, 10*time.Second)
+	tb.now = c.Now
+
+	d := tb.Allow("a")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 1, d.Remaining)
+	assert.Equal(t, 5*time.Second, d.Reset)
+
+	assert.True(t, tb.Allow("a").Allowed)
+	d = tb.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.Equal(t, 5*time.Second, d.RetryAfter)
+	assert.Equal(t, 10*time.Second, d.Reset)
+
+	// other keys have their own buckets
+	assert.True(t, tb.Allow("b").Allowed)
+
+	c.Advance(5 * time.Second)
+	assert.True(t, tb.Allow("a").Allowed)
+	assert.False(t, tb.Allow("a").Allowed)
+
+	// the idle buckets are refilled up to the limit only
+	c.Advance(time.Hour)
+	assert.True(t, tb.Allow("a").Allowed)
+	assert.True(t, tb.Allow("a").Allowed)
+	assert.False(t, tb.Allow("a").Allowed)
+	assert.Len(t, tb.buckets, 1)
+}
+
+func TestSlidingWindow(t *testing.T) {
+	c := &clock{now: time.Unix(1700000000, 0)}
+	sw := NewSlidingWindow(2, 10*time.Second)
+	sw.now = c.Now
+
+	d := sw.Allow("a")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 1, d.Remaining)
+
+	c.Advance(4 * time.Second)
+	assert.True(t, sw.Allow("a").Allowed)
+
+	c.Advance(4 * time.Second)
+	d = sw.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.Equal(t, 2*time.Second, d.RetryAfter)
+	assert.Equal(t, 6*time.Second, d.Reset)
+
+	// the first request leaves the window
+	c.Advance(2 * time.Second)
+	assert.True(t, sw.Allow("a").Allowed)
+	assert.False(t, sw.Allow("a").Allowed)
+
+	c.Advance(time.Minute)
+	assert.True(t, sw.Allow("b").Allowed)
+	assert.Len(t, sw.logs, 1)
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	openapi "github.com/vpnhouse/api/go/server/common"
+	"go.uber.org/zap"
+)
+
+const (
+	HeaderLimit      = "RateLimit-Limit"
+	HeaderRemaining  = "RateLimit-Remaining"
+	HeaderReset      = "RateLimit-Reset"
+	HeaderPolicy     = "RateLimit-Policy"
+	HeaderRetryAfter = "Retry-After"
+)
+
+// Rule applies the limiter to the matching requests.
+type Rule struct {
+	// Method matches any method if empty.
+	Method string
+	// Path is either the exact path or the prefix ending with "/*",
+	// empty path matches any request.
+	Path    string
+	Key     KeyFunc
+	Limiter Limiter
+}
+
+func (rule *Rule) matches(r *http.Request) bool {
+	if len(rule.Method) > 0 && !strings.EqualFold(rule.Method, r.Method) {
+		return false
+	}
+	if len(rule.Path) == 0 {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(rule.Path, "*"); ok {
+		return strings.HasPrefix(r.URL.Path, prefix)
+	}
+	return r.URL.Path == rule.Path
+}
+
+// Middleware applies the first matching rule with the key extracted,
+// so the per-route rules go before the catch-all ones and e.g.
+// a rule by user id may fall back to the rule by IP for the anonymous requests.
+type Middleware struct {
+	rules []Rule
+}
+
+func New(rules ...Rule) *Middleware {
+	return &Middleware{rules: rules}
+}
+
+func (m *Middleware) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := range m.rules {
+			rule := &m.rules[i]
+			if !rule.matches(r) {
+				continue
+			}
+			key, ok := rule.Key(r)
+			if !ok {
+				continue
+			}
+
+			d := rule.Limiter.Allow(key)
+			setHeaders(w.Header(), d)
+			if !d.Allowed {
+				zap.L().Debug("Rate limit exceeded", zap.String("key", key), zap.String("path", r.URL.Path))
+				writeLimitExceeded(w, d)
+				return
+			}
+			break
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setHeaders(h http.Header, d Decision) {
+	h.Set(HeaderLimit, strconv.Itoa(d.Limit))
+	h.Set(HeaderRemaining, strconv.Itoa(d.Remaining))
+	h.Set(HeaderReset, seconds(d.Reset))
+	h.Set(HeaderPolicy, fmt.Sprintf("%d;w=%s", d.Limit, seconds(d.Window)))
+	if !d.Allowed {
+		h.Set(HeaderRetryAfter, seconds(d.RetryAfter))
+	}
+}
+
+// writeLimitExceeded responds with the body of xerror.ENLimitExceeded,
+// the error is not created to not flood the logs and sentry on every rejected request.
+func writeLimitExceeded(w http.ResponseWriter, d Decision) {
+	msg := fmt.Sprintf("rate limit exceeded, retry after %ss", seconds(d.RetryAfter))
+	body, err := json.MarshalIndent(&openapi.Error{
+		Result: openapi.ErrorResultLIMITEXCEEDED,
+		Error:  &msg,
+	}, "", "  ")
+	if err != nil {
+		zap.L().Error("can't marshal error", zap.Error(err))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	if _, err := w.Write(body); err != nil {
+		zap.L().Error("can't write response", zap.Error(err))
+	}
+}
+
+// seconds rounds up to not let the client retry too early.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,100 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/geoip"
+)
+
+type staticKeystore map[string]string
+
+func (ks staticKeystore) Authorize(key string) (string, bool) {
+	who, ok := ks[key]
+	return who, ok
+}
+
+func TestMiddleware(t *testing.T) {
+	m := New(
+		Rule{Method: http.MethodPost, Path: "/api/login", Key: ByIP(nil), Limiter: NewSlidingWindow(1, time.Minute)},
+		Rule{Path: "/api/*", Key: ByAPIKey(staticKeystore{"secret": "partner"}, "X-Api-Key"), Limiter: NewTokenBucket(1, time.Minute)},
+		Rule{Key: ByIP(nil), Limiter: NewTokenBucket(2, time.Minute)},
+	)
+	handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	do := func(method, path, apiKey string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, http.NoBody)
+		r.RemoteAddr = "192.0.2.1:1234"
+		if len(apiKey) > 0 {
+			r.Header.Set("X-Api-Key", apiKey)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/api/login", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "1", w.Header().Get(HeaderLimit))
+	assert.Equal(t, "0", w.Header().Get(HeaderRemaining))
+	assert.Equal(t, "1;w=60", w.Header().Get(HeaderPolicy))
+
+	w = do(http.MethodPost, "/api/login", "")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get(HeaderRetryAfter))
+	var body map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "LIMIT_EXCEEDED", body["result"])
+
+	// by API key owner
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/api/servers", "secret").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/api/servers", "secret").Code)
+
+	// no key, falls back to the rule by IP
+	w = do(http.MethodGet, "/api/servers", "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, "2", w.Header().Get(HeaderLimit))
+	assert.Equal(t, http.StatusNoContent, do(http.MethodGet, "/", "unknown").Code)
+	assert.Equal(t, http.StatusTooManyRequests, do(http.MethodGet, "/", "").Code)
+}
+
+func TestByIP_spoofing(t *testing.T) {
+	trusted, err := geoip.NewTrustedResolver([]string{"10.0.0.0/8"}, geoip.SourceXForwardedFor)
+	require.NoError(t, err)
+
+	for _, resolver := range []*geoip.TrustedResolver{nil, trusted} {
+		m := New(Rule{Key: ByIP(resolver), Limiter: NewTokenBucket(1, time.Minute)})
+		handler := m.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+
+		do := func(peer, forwardedFor string) int {
+			r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+			r.RemoteAddr = peer + ":1234"
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			return w.Code
+		}
+
+		// the untrusted peer rotating the header still hits the limit
+		assert.Equal(t, http.StatusNoContent, do("192.0.2.1", "198.51.100.1"))
+		assert.Equal(t, http.StatusTooManyRequests, do("192.0.2.1", "198.51.100.2"))
+		assert.Equal(t, http.StatusTooManyRequests, do("192.0.2.1", "198.51.100.3"))
+	}
+
+	// the clients behind the trusted proxy are counted separately
+	r := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	k, ok := ByIP(trusted)(r)
+	assert.True(t, ok)
+	assert.Equal(t, "ip:198.51.100.1", k)
+}
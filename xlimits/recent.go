@@ -10,17 +10,23 @@ import (
 )
 
 type Recent struct {
-	recent *cache2go.CacheTable
-	max    int
-	period time.Duration
-	lock   sync.Mutex
+	backend RecentBackend
+	max     int
+	period  time.Duration
+	failure FailureMode
 }
 
-func NewRecent(max int, period time.Duration) *Recent {
+func NewRecent(max int, period time.Duration, opts ...Option) *Recent {
+	options := newOptions(opts)
+	if options.recent == nil {
+		options.recent = newMemoryRecent()
+	}
+
 	return &Recent{
-		recent: cache2go.Cache(uuid.NewString()),
-		max:    max,
-		period: period,
+		backend: options.recent,
+		max:     max,
+		period:  period,
+		failure: options.failure,
 	}
 }
 
@@ -28,17 +34,53 @@ func (r *Recent) Hit(ip string) bool {
 	if ip == "" {
 		return false
 	}
+
+	cntr, err := r.backend.Incr(ip, r.period)
+	if err != nil {
+		zap.L().Error("Failed to count recent hit", zap.String("key", ip), zap.Error(err))
+		return r.failure == FailClosed
+	}
+
+	return cntr > r.max
+}
+
+func (r *Recent) Undo(ip string) int {
+	if ip == "" {
+		return 0
+	}
+
+	cntr, err := r.backend.Decr(ip)
+	if err != nil {
+		zap.L().Error("Failed to undo recent hit", zap.String("key", ip), zap.Error(err))
+		return 0
+	}
+
+	return cntr
+}
+
+type memoryRecent struct {
+	recent *cache2go.CacheTable
+	lock   sync.Mutex
+}
+
+func newMemoryRecent() *memoryRecent {
+	return &memoryRecent{
+		recent: cache2go.Cache(uuid.NewString()),
+	}
+}
+
+func (r *memoryRecent) Incr(key string, period time.Duration) (int, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
 	var cntr *int
-	cached, err := r.recent.Value(ip)
+	cached, err := r.recent.Value(key)
 
 	if err == nil {
 		cntr = cached.Data().(*int)
 		if cntr != nil {
 			*cntr += 1
-			return *cntr > r.max
+			return *cntr, nil
 		} else {
 			zap.L().Error("Invalid nil recent pointer")
 		}
@@ -47,33 +89,29 @@ func (r *Recent) Hit(ip string) bool {
 	var cntr_value int = 1
 	cntr = &cntr_value
 
-	r.recent.Add(ip, r.period, cntr)
-	return cntr_value > r.max
+	r.recent.Add(key, period, cntr)
+	return cntr_value, nil
 }
 
-func (r *Recent) Undo(ip string) int {
-	if ip == "" {
-		return 0
-	}
-
+func (r *memoryRecent) Decr(key string) (int, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
 	var cntr *int
-	cached, err := r.recent.Value(ip)
+	cached, err := r.recent.Value(key)
 	if err != nil {
-		return 0
+		return 0, nil
 	}
 
 	cntr = cached.Data().(*int)
 	if cntr == nil {
-		return 0
+		return 0, nil
 	}
 
 	*cntr -= 1
 	if *cntr == 0 {
-		r.recent.Delete(ip)
+		r.recent.Delete(key)
 	}
 
-	return *cntr
+	return *cntr, nil
 }
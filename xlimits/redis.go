@@ -0,0 +1,435 @@
+package xlimits
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultRedisTimeout  = time.Second
+	defaultRedisPoolSize = 8
+	defaultRedisSlotTTL  = 10 * time.Minute
+	defaultRedisPoll     = 100 * time.Millisecond
+)
+
+var errRedisNil = errors.New("redis: nil reply")
+
+// The scripts run atomically on the server, the blocker ones use the server clock,
+// so the nodes' clocks don't have to be in sync (requires Redis 5+).
+const (
+	// redisIncrScript sets the expiry along with the counter creation.
+	redisIncrScript = `local n = redis.call('INCR', KEYS[1])
+if n == 1 then
+	redis.call('PEXPIRE', KEYS[1], ARGV[1])
+end
+return n`
+
+	// redisDecrScript removes the counter on zero, it might have expired before DECR recreated it.
+	redisDecrScript = `local n = redis.call('DECR', KEYS[1])
+if n <= 0 then
+	redis.call('DEL', KEYS[1])
+	return 0
+end
+return n`
+
+	// redisAcquireScript adds the holder token scored by its expiry
+	// if less than ARGV[1] holders are alive, the expired ones are dropped first.
+	redisAcquireScript = `local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now)
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1`
+
+	// redisRefreshScript extends the expiry of the holder tokens that are still there.
+	redisRefreshScript = `local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+for i = 2, #ARGV do
+	redis.call('ZADD', KEYS[1], 'XX', now + tonumber(ARGV[1]), ARGV[i])
+end
+redis.call('PEXPIRE', KEYS[1], ARGV[1])
+return 0`
+)
+
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+type RedisConfig struct {
+	Addr     string `yaml:"addr"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+	// Prefix of the keys shared by the nodes of the service.
+	Prefix string `yaml:"prefix"`
+	// Timeout of a single round trip including the dial.
+	Timeout  time.Duration `yaml:"timeout"`
+	PoolSize int           `yaml:"pool_size"`
+	// SlotTTL bounds the time the blocker slots of a crashed node are held,
+	// the slots of the running node are kept alive every SlotTTL/3.
+	SlotTTL time.Duration `yaml:"slot_ttl"`
+	// PollInterval is the delay between the attempts to take a blocker slot.
+	PollInterval time.Duration `yaml:"poll_interval"`
+}
+
+// Redis implements RecentBackend and BlockerBackend over the Redis protocol,
+// so the limits are shared by all the nodes using the same server and prefix.
+type Redis struct {
+	config RedisConfig
+	pool   chan *redisConn
+
+	lock sync.Mutex
+	// held are the blocker slot tokens taken by this node per id
+	held map[string][]string
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+type redisConn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func NewRedis(config RedisConfig) *Redis {
+	if config.Timeout <= 0 {
+		config.Timeout = defaultRedisTimeout
+	}
+	if config.PoolSize <= 0 {
+		config.PoolSize = defaultRedisPoolSize
+	}
+	if config.SlotTTL <= 0 {
+		config.SlotTTL = defaultRedisSlotTTL
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = defaultRedisPoll
+	}
+
+	r := &Redis{
+		config: config,
+		pool:   make(chan *redisConn, config.PoolSize),
+		held:   make(map[string][]string),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Redis) Incr(key string, period time.Duration) (int, error) {
+	key = r.config.Prefix + "recent:" + key
+	replies, err := r.do([]string{"EVAL", redisIncrScript, "1", key, millis(period)})
+	if err != nil {
+		return 0, err
+	}
+	return asInt(replies[0])
+}
+
+func (r *Redis) Decr(key string) (int, error) {
+	key = r.config.Prefix + "recent:" + key
+	replies, err := r.do([]string{"EVAL", redisDecrScript, "1", key})
+	if err != nil {
+		return 0, err
+	}
+	return asInt(replies[0])
+}
+
+// Acquire takes the slot with a unique holder token, the token expires
+// after SlotTTL unless this node keeps it alive, so the slots of a crashed node
+// are freed one by one.
+func (r *Redis) Acquire(ctx context.Context, id string, max int) error {
+	key := r.config.Prefix + "blocker:" + id
+	token, err := newHolderToken()
+	if err != nil {
+		return err
+	}
+
+	for {
+		replies, err := r.do([]string{"EVAL", redisAcquireScript, "1", key, strconv.Itoa(max), millis(r.config.SlotTTL), token})
+		if err != nil {
+			return err
+		}
+		taken, err := asInt(replies[0])
+		if err != nil {
+			return err
+		}
+		if taken == 1 {
+			r.lock.Lock()
+			r.held[id] = append(r.held[id], token)
+			r.lock.Unlock()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(r.config.PollInterval):
+		}
+	}
+}
+
+func (r *Redis) Release(id string) error {
+	r.lock.Lock()
+	tokens := r.held[id]
+	if len(tokens) == 0 {
+		r.lock.Unlock()
+		return errors.New("unknown blocker slot")
+	}
+	token := tokens[len(tokens)-1]
+	if len(tokens) == 1 {
+		delete(r.held, id)
+	} else {
+		r.held[id] = tokens[:len(tokens)-1]
+	}
+	r.lock.Unlock()
+
+	// the empty set is removed by the server
+	_, err := r.do([]string{"ZREM", r.config.Prefix + "blocker:" + id, token})
+	return err
+}
+
+// Close stops keeping the held slots alive and drops the idle connections.
+func (r *Redis) Close() error {
+	r.once.Do(func() {
+		close(r.stop)
+		<-r.done
+	})
+
+	for {
+		select {
+		case c := <-r.pool:
+			_ = c.conn.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (r *Redis) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(r.config.SlotTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			r.refresh()
+		}
+	}
+}
+
+// refresh extends the expiry of the slots held by this node.
+func (r *Redis) refresh() {
+	r.lock.Lock()
+	commands := make([][]string, 0, len(r.held))
+	for id, tokens := range r.held {
+		command := []string{"EVAL", redisRefreshScript, "1", r.config.Prefix + "blocker:" + id, millis(r.config.SlotTTL)}
+		commands = append(commands, append(command, tokens...))
+	}
+	r.lock.Unlock()
+
+	if len(commands) == 0 {
+		return
+	}
+	if _, err := r.do(commands...); err != nil {
+		zap.L().Error("Failed to refresh blocker slots", zap.Error(err))
+	}
+}
+
+// do sends the commands in a single round trip and returns their replies,
+// the error replies are returned as errors.
+func (r *Redis) do(commands ...[]string) ([]any, error) {
+	c, err := r.get()
+	if err != nil {
+		return nil, err
+	}
+
+	replies, err := c.roundTrip(r.config.Timeout, commands...)
+	if err != nil {
+		var rerr redisError
+		if !errors.As(err, &rerr) {
+			// the connection state is unknown after the I/O error
+			_ = c.conn.Close()
+			return nil, err
+		}
+	}
+
+	r.put(c)
+	return replies, err
+}
+
+func (r *Redis) get() (*redisConn, error) {
+	select {
+	case c := <-r.pool:
+		return c, nil
+	default:
+	}
+
+	conn, err := net.DialTimeout("tcp", r.config.Addr, r.config.Timeout)
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	c := &redisConn{conn: conn, reader: bufio.NewReader(conn)}
+
+	var setup [][]string
+	if r.config.Password != "" {
+		setup = append(setup, []string{"AUTH", r.config.Password})
+	}
+	if r.config.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(r.config.DB)})
+	}
+	if len(setup) > 0 {
+		if _, err := c.roundTrip(r.config.Timeout, setup...); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (r *Redis) put(c *redisConn) {
+	select {
+	case r.pool <- c:
+	default:
+		_ = c.conn.Close()
+	}
+}
+
+func (c *redisConn) roundTrip(timeout time.Duration, commands ...[]string) ([]any, error) {
+	if err := c.conn.SetDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	for _, args := range commands {
+		fmt.Fprintf(&buf, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+
+	// read all the replies to keep the connection in sync
+	replies := make([]any, len(commands))
+	var firstErr error
+	for i := range commands {
+		reply, err := readReply(c.reader)
+		if err != nil {
+			var rerr redisError
+			if !errors.As(err, &rerr) {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		replies[i] = reply
+	}
+	return replies, firstErr
+}
+
+// readReply parses the RESP2 reply.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("redis: %w", err)
+	}
+	if len(line) < 3 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, payload := line[0], line[1:len(line)-2]
+
+	switch kind {
+	case '+':
+		return payload, nil
+	case '-':
+		return nil, redisError(payload)
+	case ':':
+		n, err := strconv.ParseInt(payload, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed integer %q", payload)
+		}
+		return n, nil
+	case '$':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("redis: %w", err)
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(payload)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", payload)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			items[i], err = readReply(r)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("redis: unexpected reply type %q", kind)
+	}
+}
+
+func asInt(reply any) (int, error) {
+	switch v := reply.(type) {
+	case int64:
+		return int(v), nil
+	case nil:
+		return 0, errRedisNil
+	default:
+		return 0, fmt.Errorf("redis: unexpected reply %v", reply)
+	}
+}
+
+func newHolderToken() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b[:]), nil
+}
+
+func millis(d time.Duration) string {
+	ms := d.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10)
+}
@@ -0,0 +1,394 @@
+package xlimits
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis is a stand-in server implementing the commands used by Redis.
+type fakeRedis struct {
+	listener net.Listener
+
+	lock    sync.Mutex
+	values  map[string]int
+	expires map[string]time.Time
+	// holders are the sorted sets of the blocker, token to expiry
+	holders map[string]map[string]time.Time
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	f := &fakeRedis{
+		listener: l,
+		values:   make(map[string]int),
+		expires:  make(map[string]time.Time),
+		holders:  make(map[string]map[string]time.Time),
+	}
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) addr() string {
+	return f.listener.Addr().String()
+}
+
+func (f *fakeRedis) get(key string) (int, bool) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.expire(key)
+	v, ok := f.values[key]
+	return v, ok
+}
+
+func (f *fakeRedis) count(key string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.expire(key)
+	n := 0
+	for _, deadline := range f.holders[key] {
+		if time.Now().Before(deadline) {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *fakeRedis) expire(key string) {
+	if deadline, ok := f.expires[key]; ok && !time.Now().Before(deadline) {
+		delete(f.values, key)
+		delete(f.holders, key)
+		delete(f.expires, key)
+	}
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if _, err := conn.Write([]byte(f.exec(args))); err != nil {
+			return
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	reply, err := readReply(r)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := reply.([]any)
+	if !ok {
+		return nil, fmt.Errorf("unexpected command %v", reply)
+	}
+
+	args := make([]string, len(items))
+	for i, item := range items {
+		args[i], _ = item.(string)
+	}
+	return args, nil
+}
+
+func (f *fakeRedis) exec(args []string) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	cmd, key := strings.ToUpper(args[0]), ""
+	if len(args) > 1 {
+		key = args[1]
+		f.expire(key)
+	}
+
+	switch cmd {
+	case "EVAL":
+		// EVAL script 1 key args...
+		return f.eval(args[1], args[3], args[4:])
+	case "AUTH", "SELECT":
+		return "+OK\r\n"
+	case "ZREM":
+		set := f.holders[key]
+		if _, ok := set[args[2]]; !ok {
+			return ":0\r\n"
+		}
+		delete(set, args[2])
+		if len(set) == 0 {
+			delete(f.holders, key)
+			delete(f.expires, key)
+		}
+		return ":1\r\n"
+	default:
+		return "-ERR unknown command '" + cmd + "'\r\n"
+	}
+}
+
+// eval emulates the scripts used by Redis.
+func (f *fakeRedis) eval(script, key string, args []string) string {
+	f.expire(key)
+	now := time.Now()
+	ms := func(arg string) time.Duration {
+		v, _ := strconv.Atoi(arg)
+		return time.Duration(v) * time.Millisecond
+	}
+
+	switch script {
+	case redisIncrScript:
+		f.values[key]++
+		if f.values[key] == 1 {
+			f.expires[key] = now.Add(ms(args[0]))
+		}
+		return fmt.Sprintf(":%d\r\n", f.values[key])
+	case redisDecrScript:
+		f.values[key]--
+		if f.values[key] <= 0 {
+			delete(f.values, key)
+			delete(f.expires, key)
+			return ":0\r\n"
+		}
+		return fmt.Sprintf(":%d\r\n", f.values[key])
+	case redisAcquireScript:
+		set := f.holders[key]
+		for token, deadline := range set {
+			if !now.Before(deadline) {
+				delete(set, token)
+			}
+		}
+		max, _ := strconv.Atoi(args[0])
+		if len(set) >= max {
+			return ":0\r\n"
+		}
+		if set == nil {
+			set = make(map[string]time.Time)
+			f.holders[key] = set
+		}
+		set[args[2]] = now.Add(ms(args[1]))
+		f.expires[key] = now.Add(ms(args[1]))
+		return ":1\r\n"
+	case redisRefreshScript:
+		set, ok := f.holders[key]
+		if !ok {
+			return ":0\r\n"
+		}
+		for _, token := range args[1:] {
+			if _, ok := set[token]; ok {
+				set[token] = now.Add(ms(args[0]))
+			}
+		}
+		f.expires[key] = now.Add(ms(args[0]))
+		return ":0\r\n"
+	default:
+		return "-NOSCRIPT unknown script\r\n"
+	}
+}
+
+func TestRedis_recent(t *testing.T) {
+	f := newFakeRedis(t)
+	backend := NewRedis(RedisConfig{Addr: f.addr(), Prefix: "test:", Password: "secret", DB: 1})
+	defer backend.Close()
+
+	// two nodes sharing the backend enforce the common limit
+	node1 := NewRecent(2, 50*time.Millisecond, WithRecentBackend(backend))
+	node2 := NewRecent(2, 50*time.Millisecond, WithRecentBackend(backend))
+
+	assert.False(t, node1.Hit("10.0.0.1"))
+	assert.False(t, node2.Hit("10.0.0.1"))
+	assert.True(t, node1.Hit("10.0.0.1"))
+	assert.Equal(t, 2, node2.Undo("10.0.0.1"))
+
+	v, ok := f.get("test:recent:10.0.0.1")
+	assert.True(t, ok)
+	assert.Equal(t, 2, v)
+
+	time.Sleep(60 * time.Millisecond)
+	assert.False(t, node2.Hit("10.0.0.1"))
+
+	assert.Equal(t, 0, node1.Undo("10.0.0.1"))
+	_, ok = f.get("test:recent:10.0.0.1")
+	assert.False(t, ok)
+	assert.Equal(t, 0, node1.Undo("10.0.0.1"))
+	_, ok = f.get("test:recent:10.0.0.1")
+	assert.False(t, ok)
+}
+
+func TestRedis_blocker(t *testing.T) {
+	f := newFakeRedis(t)
+	backend := NewRedis(RedisConfig{Addr: f.addr(), PollInterval: 5 * time.Millisecond})
+	defer backend.Close()
+
+	node1 := NewBlocker(1, WithBlockerBackend(backend))
+	node2 := NewBlocker(1, WithBlockerBackend(backend))
+
+	c1, err := node1.Acquire(context.Background(), "user")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	_, err = node2.Acquire(ctx, "user")
+	assert.Error(t, err)
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		node1.Release("user", c1)
+	}()
+	c2, err := node2.Acquire(context.Background(), "user")
+	require.NoError(t, err)
+
+	assert.Equal(t, 1, f.count("blocker:user"))
+
+	node2.Release("user", c2)
+	assert.Equal(t, 0, f.count("blocker:user"))
+}
+
+func TestRedis_blockerExpiry(t *testing.T) {
+	f := newFakeRedis(t)
+	crashed := NewRedis(RedisConfig{Addr: f.addr(), SlotTTL: 30 * time.Millisecond})
+	live := NewRedis(RedisConfig{Addr: f.addr(), SlotTTL: 30 * time.Millisecond, PollInterval: 5 * time.Millisecond})
+	defer live.Close()
+
+	require.NoError(t, crashed.Acquire(context.Background(), "user", 2))
+	// the closed backend doesn't keep its slot alive anymore
+	require.NoError(t, crashed.Close())
+	require.NoError(t, live.Acquire(context.Background(), "user", 2))
+
+	// the failed attempts don't extend the slot of the crashed node
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Error(t, live.Acquire(ctx, "user", 2))
+
+	// only the slot of the crashed node is freed, the live one is refreshed
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 1, f.count("blocker:user"))
+	require.NoError(t, live.Acquire(context.Background(), "user", 2))
+	assert.Equal(t, 2, f.count("blocker:user"))
+
+	require.NoError(t, live.Release("user"))
+	require.NoError(t, live.Release("user"))
+	assert.Equal(t, 0, f.count("blocker:user"))
+	assert.Error(t, live.Release("user"))
+}
+
+// TestRedis_server runs the scripts on the real server, e.g. REDIS_ADDR=127.0.0.1:6379.
+func TestRedis_server(t *testing.T) {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		t.Skip("REDIS_ADDR is not set")
+	}
+	prefix := fmt.Sprintf("xlimits-test:%d:", time.Now().UnixNano())
+	ctx := context.Background()
+
+	backend := NewRedis(RedisConfig{Addr: addr, Prefix: prefix, SlotTTL: 300 * time.Millisecond, PollInterval: 10 * time.Millisecond})
+	defer backend.Close()
+
+	for _, want := range []int{1, 2} {
+		n, err := backend.Incr("10.0.0.1", 100*time.Millisecond)
+		require.NoError(t, err)
+		assert.Equal(t, want, n)
+	}
+	for _, want := range []int{1, 0, 0} {
+		n, err := backend.Decr("10.0.0.1")
+		require.NoError(t, err)
+		assert.Equal(t, want, n)
+	}
+
+	// the counter expires after the period of the first hit
+	_, err := backend.Incr("10.0.0.2", 100*time.Millisecond)
+	require.NoError(t, err)
+	time.Sleep(150 * time.Millisecond)
+	n, err := backend.Incr("10.0.0.2", 100*time.Millisecond)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	crashed := NewRedis(RedisConfig{Addr: addr, Prefix: prefix, SlotTTL: 100 * time.Millisecond})
+	require.NoError(t, crashed.Acquire(ctx, "user", 2))
+	require.NoError(t, crashed.Close())
+	require.NoError(t, backend.Acquire(ctx, "user", 2))
+
+	timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, backend.Acquire(timeout, "user", 2), context.DeadlineExceeded)
+
+	// the slot of the crashed node ages out, the live one is refreshed
+	time.Sleep(400 * time.Millisecond)
+	require.NoError(t, backend.Acquire(ctx, "user", 2))
+	timeout, cancel = context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, backend.Acquire(timeout, "user", 2), context.DeadlineExceeded)
+
+	require.NoError(t, backend.Release("user"))
+	require.NoError(t, backend.Release("user"))
+	require.NoError(t, backend.Acquire(ctx, "user", 1))
+	require.NoError(t, backend.Release("user"))
+}
+
+func TestRedis_failureMode(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	backend := NewRedis(RedisConfig{Addr: addr, Timeout: 100 * time.Millisecond})
+
+	open := NewRecent(0, time.Minute, WithRecentBackend(backend))
+	assert.False(t, open.Hit("10.0.0.1"))
+	assert.Equal(t, 0, open.Undo("10.0.0.1"))
+
+	closed := NewRecent(0, time.Minute, WithRecentBackend(backend), WithFailureMode(FailClosed))
+	assert.True(t, closed.Hit("10.0.0.1"))
+
+	c, err := NewBlocker(1, WithBlockerBackend(backend)).Acquire(context.Background(), "user")
+	require.NoError(t, err)
+	assert.False(t, c.held)
+
+	_, err = NewBlocker(1, WithBlockerBackend(backend), WithFailureMode(FailClosed)).Acquire(context.Background(), "user")
+	assert.Error(t, err)
+}
+
+func TestMemory(t *testing.T) {
+	recent := NewRecent(1, time.Minute)
+	assert.False(t, recent.Hit("10.0.0.1"))
+	assert.True(t, recent.Hit("10.0.0.1"))
+	assert.Equal(t, 1, recent.Undo("10.0.0.1"))
+	assert.False(t, recent.Hit(""))
+
+	blocker := NewBlocker(1)
+	c, err := blocker.Acquire(context.Background(), "user")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = blocker.Acquire(ctx, "user")
+	assert.Error(t, err)
+
+	blocker.Release("user", c)
+	c, err = blocker.Acquire(context.Background(), "user")
+	require.NoError(t, err)
+	blocker.Release("user", c)
+}
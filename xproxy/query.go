@@ -22,7 +22,9 @@ type (
 	Reporter   func(customInfo any, n uint64)
 	Authorizer func(r *http.Request) (customInfo any, err error)
 	Releaser   func(customInfo any)
-	Transport  interface {
+	// QuotaChecker reports whether the client is over its traffic quota
+	QuotaChecker func(customInfo any) bool
+	Transport    interface {
 		Dial(addr string) (net.Conn, error)
 		HttpClient() *http.Client
 	}
@@ -35,6 +37,7 @@ type Instance struct {
 	ReleaseCallback Releaser
 	StatsReportTx   Reporter
 	StatsReportRx   Reporter
+	QuotaCallback   QuotaChecker
 }
 
 func (i *Instance) doPairedForward(wg *sync.WaitGroup, src, dst io.ReadWriteCloser, customInfo any, rep Reporter) {
@@ -53,9 +56,17 @@ func (i *Instance) doPairedForward(wg *sync.WaitGroup, src, dst io.ReadWriteClos
 			return
 		}
 		rep(customInfo, uint64(n))
+
+		if i.quotaExceeded(customInfo) {
+			return
+		}
 	}
 }
 
+func (i *Instance) quotaExceeded(customInfo any) bool {
+	return i.QuotaCallback != nil && i.QuotaCallback(customInfo)
+}
+
 func (i *Instance) handleV1Connect(w http.ResponseWriter, r *http.Request, customInfo any) {
 	remoteConn, err := i.Transport.Dial(remoteEndpoint(r))
 	if err != nil {
@@ -229,6 +240,11 @@ func (i *Instance) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	defer i.ReleaseCallback(customInfo)
 
+	if i.quotaExceeded(customInfo) {
+		http.Error(w, "Traffic quota exceeded", http.StatusTooManyRequests)
+		return
+	}
+
 	if r.Method == "CONNECT" {
 		if r.ProtoMajor == 1 {
 			i.handleV1Connect(w, r, customInfo)
@@ -0,0 +1,80 @@
+package xstats
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var fullSessionData = SessionData{
+	InstallationID: "installation_id_123",
+	UserID:         "project/auth/user",
+	Country:        "de",
+	Platform:       "android",
+	Protocol:       ProtocolWireguard,
+	Node:           "fra-1",
+	ASN:            3320,
+	Extra:          map[string]string{"app_version": "1.2.3", "tier": "paid"},
+}
+
+func TestSessionDataBinary(t *testing.T) {
+	for _, data := range []SessionData{{}, fullSessionData} {
+		var decoded SessionData
+		require.NoError(t, decoded.decodeBinary(data.appendBinary(nil)))
+		assert.Equal(t, data, decoded)
+	}
+
+	encoded := fullSessionData.appendBinary(nil)
+	for i := 0; i < len(encoded); i++ {
+		var decoded SessionData
+		assert.Error(t, decoded.decodeBinary(encoded[:i]), "truncated at %d", i)
+	}
+}
+
+func TestSessionDataGet(t *testing.T) {
+	assert.Equal(t, "3320", fullSessionData.Get(DimensionASN))
+	assert.Equal(t, ProtocolWireguard, fullSessionData.Get(DimensionProtocol))
+	assert.Equal(t, "paid", fullSessionData.Get("tier"))
+	assert.Empty(t, fullSessionData.Get("missing"))
+	assert.Empty(t, (&SessionData{}).Get(DimensionASN))
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-Client-Type", "iOS")
+	var data SessionData
+	data.SetPlatformFromRequest(r)
+	assert.Equal(t, "ios", data.Platform)
+}
+
+func TestValueRoundTrip(t *testing.T) {
+	sessionID := uuid.New()
+	v := toValue(&Session{SessionData: fullSessionData}, 10, 20)
+
+	r := parse(sessionID[:], v, NowNano())
+	assert.Equal(t, sessionID.String(), r.SessionID)
+	assert.Equal(t, fullSessionData, r.SessionData)
+	assert.EqualValues(t, 10, r.DeltaRx)
+	assert.EqualValues(t, 20, r.DeltaTx)
+}
+
+func BenchmarkSessionDataBinary(b *testing.B) {
+	b.ReportAllocs()
+	buf := make([]byte, 0, 256)
+	for i := 0; i < b.N; i++ {
+		buf = fullSessionData.appendBinary(buf[:0])
+		var decoded SessionData
+		_ = decoded.decodeBinary(buf)
+	}
+}
+
+func BenchmarkSessionDataJSON(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf, _ := json.Marshal(fullSessionData)
+		var decoded SessionData
+		_ = json.Unmarshal(buf, &decoded)
+	}
+}
@@ -0,0 +1,162 @@
+package xstats
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionEvent marks the lifecycle reports, the periodic reports have it empty.
+type SessionEvent string
+
+const (
+	EventStart SessionEvent = "start"
+	EventEnd   SessionEvent = "end"
+	EventIdle  SessionEvent = "idle" // the session is ended having no traffic for the idle timeout
+)
+
+const minIdleCheckInterval = 100 * time.Millisecond
+
+type sessionState struct {
+	data      SessionData
+	firstSeen uint64
+	lastSeen  uint64
+	totalRx   uint64
+	totalTx   uint64
+}
+
+// Duration is the time passed between the first and the last traffic of the session.
+func (r *Report) Duration() time.Duration {
+	if r.LastSeenNano < r.FirstSeenNano {
+		return 0
+	}
+	return time.Duration(r.LastSeenNano - r.FirstSeenNano)
+}
+
+// StartSession starts tracking the session and emits the EventStart report.
+// The reports of the started session carry the cumulative totals until
+// the session is ended with EndSession or by the idle timeout.
+// It returns false if the session is already started.
+func (s *Service) StartSession(sessionID uuid.UUID, data SessionData) bool {
+	nowNano := NowNano()
+
+	s.lock.Lock()
+	if _, ok := s.tracked[sessionID]; ok {
+		s.lock.Unlock()
+		return false
+	}
+	state := &sessionState{
+		data:      data,
+		firstSeen: nowNano,
+		lastSeen:  nowNano,
+	}
+	s.tracked[sessionID] = state
+	s.lock.Unlock()
+
+	s.onFlush(&Report{
+		Session: Session{
+			SessionID:   sessionID.String(),
+			SessionData: data,
+		},
+		CreatedNano:   nowNano,
+		Event:         EventStart,
+		FirstSeenNano: nowNano,
+		LastSeenNano:  nowNano,
+	})
+	return true
+}
+
+// EndSession emits the final EventEnd report with the traffic not flushed yet
+// and the cumulative totals. It returns false if the session is not started.
+func (s *Service) EndSession(sessionID uuid.UUID) bool {
+	return s.end(sessionID, EventEnd, nil)
+}
+
+func (s *Service) end(sessionID uuid.UUID, event SessionEvent, cond func(state *sessionState) bool) bool {
+	s.lock.Lock()
+	state, ok := s.tracked[sessionID]
+	if !ok || (cond != nil && !cond(state)) {
+		s.lock.Unlock()
+		return false
+	}
+	delete(s.tracked, sessionID)
+	s.lock.Unlock()
+
+	nowNano := NowNano()
+	r := &Report{CreatedNano: nowNano}
+	// No data means the traffic is flushed already
+	if v, err := s.sessions.Take(sessionID[:]); err == nil {
+		r = parse(sessionID[:], v, nowNano)
+	}
+	r.SessionID = sessionID.String()
+	r.SessionData = state.data
+	state.fill(r)
+	r.Event = event
+	s.onFlush(r)
+	return true
+}
+
+func (s *Service) endIdle() {
+	deadline := NowNano() - uint64(s.idleTimeout)
+	isIdle := func(state *sessionState) bool {
+		return state.lastSeen < deadline
+	}
+
+	s.lock.Lock()
+	var idle []uuid.UUID
+	for sessionID, state := range s.tracked {
+		if isIdle(state) {
+			idle = append(idle, sessionID)
+		}
+	}
+	s.lock.Unlock()
+
+	for _, sessionID := range idle {
+		// The traffic may have come meanwhile, so the state is checked again
+		s.end(sessionID, EventIdle, isIdle)
+	}
+}
+
+// touch counts the traffic of the started session and returns its data.
+func (s *Service) touch(sessionID uuid.UUID, drx, dtx uint64) (SessionData, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	state, ok := s.tracked[sessionID]
+	if !ok {
+		return SessionData{}, false
+	}
+	state.lastSeen = NowNano()
+	state.totalRx += drx
+	state.totalTx += dtx
+	return state.data, true
+}
+
+// fillLifecycle sets the totals to the periodic report of the started session.
+func (s *Service) fillLifecycle(r *Report, k []byte) {
+	sessionID, err := uuid.FromBytes(k)
+	if err != nil {
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if state, ok := s.tracked[sessionID]; ok {
+		state.fill(r)
+	}
+}
+
+func (st *sessionState) fill(r *Report) {
+	r.FirstSeenNano = st.firstSeen
+	r.LastSeenNano = st.lastSeen
+	r.TotalRx = st.totalRx
+	r.TotalTx = st.totalTx
+}
+
+func idleCheckInterval(timeout time.Duration) time.Duration {
+	if interval := timeout / 2; interval > minIdleCheckInterval {
+		return interval
+	}
+	return minIdleCheckInterval
+}
@@ -0,0 +1,118 @@
+package xstats
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/xcache"
+)
+
+type reportCollector struct {
+	lock    sync.Mutex
+	reports []*Report
+}
+
+func (c *reportCollector) onFlush(r *Report) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.reports = append(c.reports, r)
+}
+
+func (c *reportCollector) count() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.reports)
+}
+
+func (c *reportCollector) events() []*Report {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var result []*Report
+	for _, r := range c.reports {
+		if r.Event != "" {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+func TestService_lifecycle(t *testing.T) {
+	c := &reportCollector{}
+	s, err := New(time.Hour, c.onFlush)
+	require.NoError(t, err)
+
+	sessionID := uuid.New()
+	data := SessionData{UserID: "user", Protocol: ProtocolWireguard}
+	assert.True(t, s.StartSession(sessionID, data))
+	assert.False(t, s.StartSession(sessionID, data))
+
+	for i := 0; i < 3; i++ {
+		s.ReportStats(sessionID, 100, 10, nil)
+	}
+	// the periodic flush carries the totals so far
+	s.sessions.Reset()
+	require.Eventually(t, func() bool { return c.count() == 2 }, time.Second, 5*time.Millisecond)
+	s.ReportStats(sessionID, 1, 2, nil)
+
+	assert.True(t, s.EndSession(sessionID))
+	assert.False(t, s.EndSession(sessionID))
+
+	events := c.events()
+	require.Len(t, events, 2)
+	start, end := events[0], events[1]
+	assert.Equal(t, EventStart, start.Event)
+	assert.Equal(t, "user", start.UserID)
+	assert.Zero(t, start.TotalRx)
+
+	assert.Equal(t, EventEnd, end.Event)
+	assert.Equal(t, sessionID.String(), end.SessionID)
+	assert.Equal(t, ProtocolWireguard, end.Protocol)
+	assert.Equal(t, uint64(1), end.DeltaRx)
+	assert.Equal(t, uint64(2), end.DeltaTx)
+	assert.Equal(t, uint64(301), end.TotalRx)
+	assert.Equal(t, uint64(32), end.TotalTx)
+	assert.Equal(t, start.FirstSeenNano, end.FirstSeenNano)
+	assert.True(t, end.LastSeenNano >= end.FirstSeenNano)
+
+	require.Equal(t, 3, c.count())
+	periodic := c.reports[1]
+	assert.Empty(t, periodic.Event)
+	assert.Equal(t, "user", periodic.UserID)
+	assert.Equal(t, uint64(300), periodic.DeltaRx)
+	assert.Equal(t, uint64(300), periodic.TotalRx)
+
+	// the pending traffic is taken by the end report
+	_, err = s.sessions.Get(sessionID[:])
+	assert.ErrorIs(t, err, xcache.ErrNoData)
+}
+
+func TestService_idle(t *testing.T) {
+	c := &reportCollector{}
+	s, err := New(time.Hour, c.onFlush, WithIdleTimeout(200*time.Millisecond))
+	require.NoError(t, err)
+
+	idle, active := uuid.New(), uuid.New()
+	s.StartSession(idle, SessionData{UserID: "idle"})
+	s.StartSession(active, SessionData{UserID: "active"})
+	s.ReportStats(idle, 10, 10, nil)
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		s.ReportStats(active, 1, 1, nil)
+	}
+
+	events := c.events()
+	require.Len(t, events, 3)
+	r := events[2]
+	assert.Equal(t, EventIdle, r.Event)
+	assert.Equal(t, "idle", r.UserID)
+	assert.Equal(t, uint64(10), r.TotalRx)
+	assert.True(t, r.Duration() < 200*time.Millisecond)
+
+	assert.True(t, s.EndSession(active))
+}
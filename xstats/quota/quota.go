@@ -0,0 +1,298 @@
+// Package quota enforces the per-user daily traffic limits, see auth.ClientClaims.DailyLimit.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/vpnhouse/common-lib-go/auth"
+	"github.com/vpnhouse/common-lib-go/xstats"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultSaveInterval = time.Minute
+	// users idle for that long are forgotten
+	idleTTL = 7 * 24 * time.Hour
+)
+
+var DefaultThresholds = []float64{0.8, 1.0}
+
+type Config struct {
+	// StatePath is the JSON file the counters survive restarts in, memory only if empty.
+	StatePath    string        `yaml:"state_path"`
+	SaveInterval time.Duration `yaml:"save_interval"`
+	// Location of the daily boundary for the users without one, UTC if nil.
+	Location *time.Location `yaml:"-"`
+	// Thresholds are the fractions of the limit firing OnThreshold, DefaultThresholds if empty.
+	Thresholds []float64 `yaml:"thresholds"`
+}
+
+// Event is fired once a day per user and threshold.
+type Event struct {
+	UserID    string
+	Threshold float64
+	Used      int64
+	Limit     int64
+	ResetAt   time.Time
+}
+
+type OnThreshold func(event Event)
+
+// Usage is the user state within the current day, zero Limit means unlimited.
+type Usage struct {
+	Used     int64     `json:"used"`
+	Limit    int64     `json:"limit,omitempty"`
+	Location string    `json:"location,omitempty"`
+	ResetAt  time.Time `json:"reset_at"`
+	// Fired is the number of thresholds fired within the day.
+	Fired int `json:"fired,omitempty"`
+}
+
+func (u *Usage) Exceeded() bool {
+	return u.Limit > 0 && u.Used >= u.Limit
+}
+
+type Tracker struct {
+	config      Config
+	onThreshold OnThreshold
+	now         func() time.Time
+
+	lock  sync.RWMutex
+	users map[string]*Usage
+	dirty bool
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewTracker(config Config, onThreshold OnThreshold) (*Tracker, error) {
+	if config.Location == nil {
+		config.Location = time.UTC
+	}
+	if len(config.Thresholds) == 0 {
+		config.Thresholds = DefaultThresholds
+	}
+	config.Thresholds = append([]float64(nil), config.Thresholds...)
+	sort.Float64s(config.Thresholds)
+	if config.SaveInterval <= 0 {
+		config.SaveInterval = defaultSaveInterval
+	}
+
+	t := &Tracker{
+		config:      config,
+		onThreshold: onThreshold,
+		now:         time.Now,
+		users:       make(map[string]*Usage),
+		stop:        make(chan struct{}),
+	}
+
+	if len(config.StatePath) > 0 {
+		if err := t.load(); err != nil {
+			return nil, err
+		}
+		t.done.Add(1)
+		go t.run()
+	}
+	return t, nil
+}
+
+// SetLimit sets the daily limit in bytes and the timezone of the user,
+// nil loc keeps the current one.
+func (t *Tracker) SetLimit(userID string, limit int64, loc *time.Location) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	u := t.user(userID, t.now())
+	u.Limit = limit
+	if loc != nil && loc.String() != u.Location {
+		u.Location = loc.String()
+		u.ResetAt = nextReset(t.now(), loc)
+	}
+	t.dirty = true
+}
+
+// SetClaims takes the limit from the token claims.
+func (t *Tracker) SetClaims(claims *auth.ClientClaims, loc *time.Location) {
+	if claims == nil || len(claims.UserId) == 0 {
+		return
+	}
+	t.SetLimit(claims.UserId, claims.DailyLimit, loc)
+}
+
+// Add counts the traffic of the user firing the thresholds crossed.
+func (t *Tracker) Add(userID string, bytes uint64) Usage {
+	if len(userID) == 0 {
+		return Usage{}
+	}
+
+	t.lock.Lock()
+	u := t.user(userID, t.now())
+	u.Used += int64(bytes)
+	t.dirty = true
+
+	var events []Event
+	if u.Limit > 0 {
+		for u.Fired < len(t.config.Thresholds) && float64(u.Used) >= t.config.Thresholds[u.Fired]*float64(u.Limit) {
+			events = append(events, Event{
+				UserID:    userID,
+				Threshold: t.config.Thresholds[u.Fired],
+				Used:      u.Used,
+				Limit:     u.Limit,
+				ResetAt:   u.ResetAt,
+			})
+			u.Fired++
+		}
+	}
+	usage := *u
+	t.lock.Unlock()
+
+	if t.onThreshold != nil {
+		for _, e := range events {
+			t.onThreshold(e)
+		}
+	}
+	return usage
+}
+
+// Report counts the session report, use it as xstats.OnFlush.
+func (t *Tracker) Report(r *xstats.Report) {
+	t.Add(r.UserID, r.DeltaRx+r.DeltaTx)
+}
+
+// Exceeded is the fast check for the data path, e.g. to throttle or disconnect the user.
+func (t *Tracker) Exceeded(userID string) bool {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	u, ok := t.users[userID]
+	if !ok || !t.now().Before(u.ResetAt) {
+		return false
+	}
+	return u.Exceeded()
+}
+
+func (t *Tracker) Usage(userID string) (Usage, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if _, ok := t.users[userID]; !ok {
+		return Usage{}, false
+	}
+	return *t.user(userID, t.now()), true
+}
+
+// Close stops the periodic saving and saves the state.
+func (t *Tracker) Close() error {
+	if len(t.config.StatePath) == 0 {
+		return nil
+	}
+
+	close(t.stop)
+	t.done.Wait()
+	return t.save()
+}
+
+// user returns the user state rolled over to the current day.
+func (t *Tracker) user(userID string, now time.Time) *Usage {
+	u, ok := t.users[userID]
+	if !ok {
+		u = &Usage{ResetAt: nextReset(now, t.config.Location)}
+		t.users[userID] = u
+		return u
+	}
+
+	if !now.Before(u.ResetAt) {
+		u.Used = 0
+		u.Fired = 0
+		u.ResetAt = nextReset(now, t.location(u))
+	}
+	return u
+}
+
+func (t *Tracker) location(u *Usage) *time.Location {
+	if len(u.Location) == 0 {
+		return t.config.Location
+	}
+	loc, err := time.LoadLocation(u.Location)
+	if err != nil {
+		return t.config.Location
+	}
+	return loc
+}
+
+// nextReset returns the next midnight in loc.
+func nextReset(now time.Time, loc *time.Location) time.Time {
+	local := now.In(loc)
+	return time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+}
+
+func (t *Tracker) run() {
+	defer t.done.Done()
+
+	ticker := time.NewTicker(t.config.SaveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stop:
+			return
+		case <-ticker.C:
+			if err := t.save(); err != nil {
+				zap.L().Error("Failed to save quota state", zap.String("path", t.config.StatePath), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (t *Tracker) load() error {
+	data, err := os.ReadFile(t.config.StatePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, &t.users)
+}
+
+// save writes the state to the temporary file and renames it over the state file,
+// so the crash can not leave the state half-written.
+func (t *Tracker) save() error {
+	t.lock.Lock()
+	if !t.dirty {
+		t.lock.Unlock()
+		return nil
+	}
+
+	now := t.now()
+	for userID, u := range t.users {
+		if now.Sub(u.ResetAt) > idleTTL {
+			delete(t.users, userID)
+		}
+	}
+	data, err := json.Marshal(t.users)
+	t.dirty = false
+	t.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	tmp := t.config.StatePath + ".tmp"
+	err = os.WriteFile(tmp, data, 0o600)
+	if err == nil {
+		err = os.Rename(tmp, t.config.StatePath)
+	}
+	if err != nil {
+		// retry on the next tick
+		t.lock.Lock()
+		t.dirty = true
+		t.lock.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,102 @@
+package quota
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/vpnhouse/common-lib-go/auth"
+	"github.com/vpnhouse/common-lib-go/xstats"
+)
+
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func TestTracker(t *testing.T) {
+	c := &clock{now: time.Date(2024, 3, 10, 22, 30, 0, 0, time.UTC)}
+	var events []Event
+	tracker, err := NewTracker(Config{}, func(e Event) {
+		events = append(events, e)
+	})
+	require.NoError(t, err)
+	tracker.now = c.Now
+
+	tracker.SetClaims(&auth.ClientClaims{UserId: "p/a/user", DailyLimit: 1000}, nil)
+	tracker.Report(&xstats.Report{Session: xstats.Session{SessionData: xstats.SessionData{UserID: "p/a/user"}}, DeltaRx: 500, DeltaTx: 200})
+	assert.Empty(t, events)
+	assert.False(t, tracker.Exceeded("p/a/user"))
+
+	// another session of the same user
+	usage := tracker.Add("p/a/user", 150)
+	assert.EqualValues(t, 850, usage.Used)
+	require.Len(t, events, 1)
+	assert.Equal(t, 0.8, events[0].Threshold)
+
+	tracker.Add("p/a/user", 150)
+	tracker.Add("p/a/user", 150)
+	require.Len(t, events, 2)
+	assert.Equal(t, 1.0, events[1].Threshold)
+	assert.True(t, tracker.Exceeded("p/a/user"))
+
+	// the day is over in UTC
+	c.now = c.now.Add(2 * time.Hour)
+	assert.False(t, tracker.Exceeded("p/a/user"))
+	usage, ok := tracker.Usage("p/a/user")
+	require.True(t, ok)
+	assert.EqualValues(t, 0, usage.Used)
+	assert.EqualValues(t, 1000, usage.Limit)
+	assert.Equal(t, time.Date(2024, 3, 12, 0, 0, 0, 0, time.UTC), usage.ResetAt)
+
+	// unlimited users are counted but never exceed
+	tracker.Add("p/a/free", 1<<40)
+	assert.False(t, tracker.Exceeded("p/a/free"))
+	assert.Len(t, events, 2)
+}
+
+func TestTracker_timezone(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skip("no timezone database")
+	}
+
+	c := &clock{now: time.Date(2024, 3, 10, 14, 30, 0, 0, time.UTC)}
+	tracker, err := NewTracker(Config{}, nil)
+	require.NoError(t, err)
+	tracker.now = c.Now
+
+	tracker.SetLimit("user", 100, tokyo)
+	tracker.Add("user", 100)
+	assert.True(t, tracker.Exceeded("user"))
+
+	// 00:00 in Tokyo is 15:00 UTC
+	c.now = c.now.Add(30 * time.Minute)
+	assert.False(t, tracker.Exceeded("user"))
+}
+
+func TestTracker_persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "quota.json")
+	now := time.Now()
+
+	tracker, err := NewTracker(Config{StatePath: path}, nil)
+	require.NoError(t, err)
+	tracker.SetLimit("user", 100, nil)
+	tracker.Add("user", 100)
+	require.NoError(t, tracker.Close())
+
+	tracker, err = NewTracker(Config{StatePath: path}, nil)
+	require.NoError(t, err)
+	defer tracker.Close()
+
+	assert.True(t, tracker.Exceeded("user"))
+	usage, ok := tracker.Usage("user")
+	require.True(t, ok)
+	assert.EqualValues(t, 100, usage.Used)
+	assert.True(t, usage.ResetAt.After(now))
+}
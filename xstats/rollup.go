@@ -0,0 +1,169 @@
+package xstats
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Aggregate is the traffic of the reports sharing the dimension values within the window.
+type Aggregate struct {
+	Window     time.Time
+	Dimensions map[Dimension]string
+	Reports    uint64
+	Rx         uint64
+	Tx         uint64
+}
+
+type OnWindow func(window time.Time, aggregates []Aggregate)
+
+// Rollup aggregates the reports by the dimensions over the tumbling windows.
+// The report belongs to the window its accumulation has ended in.
+// A window is emitted once the reports of the window after the next one arrive,
+// so the reports delayed by up to a window are still counted, or on Flush.
+type Rollup struct {
+	window     time.Duration
+	dimensions []Dimension
+	onWindow   OnWindow
+
+	lock    sync.Mutex
+	windows map[time.Time]map[string]*Aggregate
+}
+
+func NewRollup(window time.Duration, dimensions []Dimension, onWindow OnWindow) *Rollup {
+	return &Rollup{
+		window:     window,
+		dimensions: append([]Dimension(nil), dimensions...),
+		onWindow:   onWindow,
+		windows:    make(map[time.Time]map[string]*Aggregate),
+	}
+}
+
+// Add counts the report, use it as OnFlush or the Rollup as a Sink.
+func (r *Rollup) Add(report *Report) {
+	end := time.Unix(0, int64(report.CreatedNano+report.DeltaTNano)).UTC()
+	window := end.Truncate(r.window)
+
+	var keyBuilder strings.Builder
+	for _, dim := range r.dimensions {
+		keyBuilder.WriteString(report.Get(dim))
+		keyBuilder.WriteByte(0)
+	}
+	key := keyBuilder.String()
+
+	r.lock.Lock()
+	aggregates, ok := r.windows[window]
+	if !ok {
+		aggregates = make(map[string]*Aggregate)
+		r.windows[window] = aggregates
+	}
+
+	agg, ok := aggregates[key]
+	if !ok {
+		agg = &Aggregate{Window: window, Dimensions: make(map[Dimension]string, len(r.dimensions))}
+		for _, dim := range r.dimensions {
+			agg.Dimensions[dim] = report.Get(dim)
+		}
+		aggregates[key] = agg
+	}
+	agg.Reports++
+	agg.Rx += report.DeltaRx
+	agg.Tx += report.DeltaTx
+
+	closed := r.closeLocked(window.Add(-r.window))
+	r.lock.Unlock()
+
+	r.emit(closed)
+}
+
+// Snapshot returns the aggregates of the windows not emitted yet.
+func (r *Rollup) Snapshot() []Aggregate {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var result []Aggregate
+	for _, aggregates := range r.windows {
+		result = append(result, copyAggregates(aggregates)...)
+	}
+	sortAggregates(result)
+	return result
+}
+
+// Flush emits all the windows.
+func (r *Rollup) Flush() {
+	r.lock.Lock()
+	closed := r.windows
+	r.windows = make(map[time.Time]map[string]*Aggregate)
+	r.lock.Unlock()
+
+	r.emit(closed)
+}
+
+func (r *Rollup) Name() string {
+	return "rollup"
+}
+
+func (r *Rollup) Write(ctx context.Context, batch []*Report) error {
+	for _, report := range batch {
+		r.Add(report)
+	}
+	return nil
+}
+
+func (r *Rollup) Close() error {
+	r.Flush()
+	return nil
+}
+
+// closeLocked removes the windows started before the given one.
+func (r *Rollup) closeLocked(before time.Time) map[time.Time]map[string]*Aggregate {
+	var closed map[time.Time]map[string]*Aggregate
+	for window, aggregates := range r.windows {
+		if window.Before(before) {
+			if closed == nil {
+				closed = make(map[time.Time]map[string]*Aggregate)
+			}
+			closed[window] = aggregates
+			delete(r.windows, window)
+		}
+	}
+	return closed
+}
+
+func (r *Rollup) emit(closed map[time.Time]map[string]*Aggregate) {
+	if len(closed) == 0 || r.onWindow == nil {
+		return
+	}
+
+	windows := make([]time.Time, 0, len(closed))
+	for window := range closed {
+		windows = append(windows, window)
+	}
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Before(windows[j]) })
+
+	for _, window := range windows {
+		aggregates := copyAggregates(closed[window])
+		sortAggregates(aggregates)
+		r.onWindow(window, aggregates)
+	}
+}
+
+func copyAggregates(aggregates map[string]*Aggregate) []Aggregate {
+	result := make([]Aggregate, 0, len(aggregates))
+	for _, agg := range aggregates {
+		result = append(result, *agg)
+	}
+	return result
+}
+
+// sortAggregates orders by window and the traffic, the heaviest first.
+func sortAggregates(aggregates []Aggregate) {
+	sort.Slice(aggregates, func(i, j int) bool {
+		if !aggregates[i].Window.Equal(aggregates[j].Window) {
+			return aggregates[i].Window.Before(aggregates[j].Window)
+		}
+		return aggregates[i].Rx+aggregates[i].Tx > aggregates[j].Rx+aggregates[j].Tx
+	})
+}
@@ -0,0 +1,55 @@
+package xstats
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rollupReport(at time.Time, country, protocol string, rx uint64) *Report {
+	return &Report{
+		Session: Session{
+			SessionData: SessionData{Country: country, Protocol: protocol},
+		},
+		CreatedNano: uint64(at.Add(-time.Second).UnixNano()),
+		DeltaTNano:  uint64(time.Second),
+		DeltaRx:     rx,
+		DeltaTx:     rx / 10,
+	}
+}
+
+func TestRollup(t *testing.T) {
+	base := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	var emitted []time.Time
+	var aggregates [][]Aggregate
+	r := NewRollup(time.Minute, []Dimension{DimensionCountry, DimensionProtocol}, func(window time.Time, aggs []Aggregate) {
+		emitted = append(emitted, window)
+		aggregates = append(aggregates, aggs)
+	})
+
+	r.Add(rollupReport(base.Add(10*time.Second), "de", ProtocolWireguard, 100))
+	r.Add(rollupReport(base.Add(20*time.Second), "de", ProtocolWireguard, 200))
+	r.Add(rollupReport(base.Add(30*time.Second), "de", ProtocolProxy, 1000))
+	r.Add(rollupReport(base.Add(40*time.Second), "us", ProtocolWireguard, 50))
+
+	// the next window does not close the current one yet
+	r.Add(rollupReport(base.Add(70*time.Second), "de", ProtocolWireguard, 1))
+	assert.Empty(t, emitted)
+	assert.Len(t, r.Snapshot(), 4)
+
+	r.Add(rollupReport(base.Add(130*time.Second), "de", ProtocolWireguard, 1))
+	require.Len(t, emitted, 1)
+	assert.Equal(t, base, emitted[0])
+	assert.Equal(t, []Aggregate{
+		{Window: base, Dimensions: map[Dimension]string{DimensionCountry: "de", DimensionProtocol: ProtocolProxy}, Reports: 1, Rx: 1000, Tx: 100},
+		{Window: base, Dimensions: map[Dimension]string{DimensionCountry: "de", DimensionProtocol: ProtocolWireguard}, Reports: 2, Rx: 300, Tx: 30},
+		{Window: base, Dimensions: map[Dimension]string{DimensionCountry: "us", DimensionProtocol: ProtocolWireguard}, Reports: 1, Rx: 50, Tx: 5},
+	}, aggregates[0])
+
+	r.Flush()
+	assert.Equal(t, []time.Time{base, base.Add(time.Minute), base.Add(2 * time.Minute)}, emitted)
+	assert.Empty(t, r.Snapshot())
+}
@@ -1,7 +1,8 @@
 package xstats
 
 import (
-	"encoding/json"
+	"errors"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,16 +11,39 @@ import (
 
 const maxBytes = 32 << 20 // 32 Mb
 
+var errCorruptedValue = errors.New("corrupted session value")
+
 type Service struct {
 	sessions *xcache.Cache // session_id -> rx, tx, timestamp, data {installation_id, user_id, country etc.}
 	onFlush  OnFlush
+
+	idleTimeout time.Duration
+	lock        sync.Mutex
+	tracked     map[uuid.UUID]*sessionState // sessions started with StartSession
 }
 
-// Use shorten'd json names for more compact in memory placments
+type Option func(s *Service)
+
+// WithIdleTimeout ends the started sessions having no traffic for the given period,
+// see EventIdle.
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(s *Service) {
+		s.idleTimeout = timeout
+	}
+}
+
+// SessionData is stored in memory in the binary form, see appendBinary,
+// the short json names are kept for the reports written by the sinks.
 type SessionData struct {
 	InstallationID string `json:"i_id,omitempty"`
 	UserID         string `json:"u_id,omitempty"`
 	Country        string `json:"c,omitempty"`
+	Platform       string `json:"p,omitempty"`
+	Protocol       string `json:"pr,omitempty"`
+	Node           string `json:"n,omitempty"`
+	ASN            uint32 `json:"asn,omitempty"`
+	// Extra keeps the custom dimensions, see Get.
+	Extra map[string]string `json:"x,omitempty"`
 }
 
 type Session struct {
@@ -33,6 +57,13 @@ type Report struct {
 	DeltaRx     uint64
 	DeltaTx     uint64
 	DeltaTNano  uint64
+
+	// The lifecycle fields are set for the sessions started with StartSession only.
+	Event         SessionEvent
+	FirstSeenNano uint64
+	LastSeenNano  uint64
+	TotalRx       uint64
+	TotalTx       uint64
 }
 
 type (
@@ -66,7 +97,7 @@ func parse(k []byte, v []byte, nowNano uint64) *Report {
 	dataLen := int(ParseUint16(v[i : i+2]))
 	i += 2
 	// Must not be any error
-	_ = json.Unmarshal(v[i:i+dataLen], &r.SessionData)
+	_ = r.SessionData.decodeBinary(v[i : i+dataLen])
 
 	return r
 }
@@ -78,8 +109,8 @@ func toValue(session *Session, drx, dtx uint64) []byte {
 	// [2] len(Data) +
 	// [.] Data
 
-	data, _ := json.Marshal(session.SessionData)
-	d := make([]byte, 8+8+8+2+len(data))
+	const headerLen = 8 + 8 + 8 + 2
+	d := make([]byte, headerLen, headerLen+64)
 
 	i := 0
 	// Delta Rx, Tx
@@ -91,18 +122,20 @@ func toValue(session *Session, drx, dtx uint64) []byte {
 	SetUint64(nowNano, d[i:i+8])
 	i += 8
 
-	// Data
-	dataLen := len(data)
-	SetUint16(uint16(dataLen), d[i:i+2])
-	i += 2
-	copy(d[i:i+dataLen], data)
+	// Data appended in place to save the allocation
+	d = session.SessionData.appendBinary(d)
+	SetUint16(uint16(len(d)-headerLen), d[i:i+2])
 
 	return d
 }
 
-func New(flushInterval time.Duration, onFlush OnFlush) (*Service, error) {
+func New(flushInterval time.Duration, onFlush OnFlush, opts ...Option) (*Service, error) {
 	s := &Service{
 		onFlush: onFlush,
+		tracked: make(map[uuid.UUID]*sessionState),
+	}
+	for _, o := range opts {
+		o(s)
 	}
 	var err error
 	s.sessions, err = xcache.New(maxBytes, s.onEvict)
@@ -113,11 +146,19 @@ func New(flushInterval time.Duration, onFlush OnFlush) (*Service, error) {
 	return s, nil
 }
 
+// ReportStats accumulates the traffic of the session until the next flush.
+// The onData is called once per flush interval to get the session data,
+// it may be nil for the sessions started with StartSession.
 func (s *Service) ReportStats(sessionID uuid.UUID, drx, dtx uint64, onData OnData) {
+	data, tracked := s.touch(sessionID, drx, dtx)
 	s.sessions.Update(sessionID[:], func(v []byte) ([]byte, bool, error) {
 		if len(v) == 0 {
 			session := &Session{SessionID: sessionID.String()}
-			onData(sessionID, &session.SessionData)
+			if tracked {
+				session.SessionData = data
+			} else if onData != nil {
+				onData(sessionID, &session.SessionData)
+			}
 			return toValue(session, drx, dtx), true, nil
 		}
 		return AddRxTx(drx, dtx, v), false, nil
@@ -126,9 +167,23 @@ func (s *Service) ReportStats(sessionID uuid.UUID, drx, dtx uint64, onData OnDat
 
 func (s *Service) run(flushInterval time.Duration) {
 	ticker := time.NewTicker(flushInterval)
-	for range ticker.C {
-		// It causes the onEvict been called if any
-		s.sessions.Reset()
+	defer ticker.Stop()
+
+	var idle <-chan time.Time
+	if s.idleTimeout > 0 {
+		idleTicker := time.NewTicker(idleCheckInterval(s.idleTimeout))
+		defer idleTicker.Stop()
+		idle = idleTicker.C
+	}
+
+	for {
+		select {
+		case <-ticker.C:
+			// It causes the onEvict been called if any
+			s.sessions.Reset()
+		case <-idle:
+			s.endIdle()
+		}
 	}
 }
 
@@ -136,6 +191,7 @@ func (s *Service) onEvict(evicted *xcache.Items) {
 	nowNano := NowNano()
 	for i := range evicted.Values {
 		r := parse(evicted.Keys[i], evicted.Values[i], nowNano)
+		s.fillLifecycle(r, evicted.Keys[i])
 		s.onFlush(r)
 	}
 }
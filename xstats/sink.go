@@ -0,0 +1,353 @@
+package xstats
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	defaultBatchSize     = 1000
+	defaultBatchInterval = 10 * time.Second
+	defaultQueueSize     = 64
+	defaultRetryMin      = time.Second
+	defaultRetryMax      = time.Minute
+	defaultWriteTimeout  = 30 * time.Second
+)
+
+// Sink receives the batches of reports, the batch is retried until Write succeeds.
+type Sink interface {
+	// Name identifies the sink, it names the spool directory of the sink.
+	Name() string
+	Write(ctx context.Context, batch []*Report) error
+	Close() error
+}
+
+type DispatcherConfig struct {
+	// BatchSize triggers the batch to be sent before BatchInterval passes.
+	BatchSize     int           `yaml:"batch_size"`
+	BatchInterval time.Duration `yaml:"batch_interval"`
+	// QueueSize is the number of batches per sink kept in memory,
+	// the oldest batches are dropped on overflow. Not used with SpoolDir.
+	QueueSize int `yaml:"queue_size"`
+	// SpoolDir keeps the batches on disk until the sinks accept them,
+	// so the reports survive restarts and sink outages.
+	SpoolDir     string        `yaml:"spool_dir"`
+	RetryMin     time.Duration `yaml:"retry_min"`
+	RetryMax     time.Duration `yaml:"retry_max"`
+	WriteTimeout time.Duration `yaml:"write_timeout"`
+}
+
+// Dispatcher batches the reports and delivers the batches to every sink independently,
+// so a slow or failing sink never blocks the others. Use OnFlush as the Service callback.
+type Dispatcher struct {
+	config  DispatcherConfig
+	workers []*sinkWorker
+
+	lock   sync.Mutex
+	batch  []*Report
+	closed bool
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func NewDispatcher(config DispatcherConfig, sinks ...Sink) (*Dispatcher, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = defaultBatchSize
+	}
+	if config.BatchInterval <= 0 {
+		config.BatchInterval = defaultBatchInterval
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = defaultQueueSize
+	}
+	if config.RetryMin <= 0 {
+		config.RetryMin = defaultRetryMin
+	}
+	if config.RetryMax < config.RetryMin {
+		config.RetryMax = max(defaultRetryMax, config.RetryMin)
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaultWriteTimeout
+	}
+
+	d := &Dispatcher{
+		config: config,
+		stop:   make(chan struct{}),
+	}
+
+	for _, sink := range sinks {
+		var q queue
+		if len(config.SpoolDir) > 0 {
+			spool, err := openSpool(config.SpoolDir, sink.Name())
+			if err != nil {
+				return nil, err
+			}
+			q = spool
+		} else {
+			q = newMemQueue(config.QueueSize)
+		}
+
+		w := &sinkWorker{
+			sink:   sink,
+			queue:  q,
+			config: &d.config,
+			notify: make(chan struct{}, 1),
+			stop:   d.stop,
+		}
+		d.workers = append(d.workers, w)
+	}
+
+	for _, w := range d.workers {
+		d.done.Add(1)
+		go func(w *sinkWorker) {
+			defer d.done.Done()
+			w.run()
+		}(w)
+	}
+
+	d.done.Add(1)
+	go d.run()
+	return d, nil
+}
+
+// OnFlush adds the report to the current batch.
+func (d *Dispatcher) OnFlush(report *Report) {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		zap.L().Error("Report is flushed after the dispatcher is closed", zap.String("session_id", report.SessionID))
+		return
+	}
+
+	d.batch = append(d.batch, report)
+	var batch []*Report
+	if len(d.batch) >= d.config.BatchSize {
+		batch = d.batch
+		d.batch = nil
+	}
+	d.lock.Unlock()
+
+	d.dispatch(batch)
+}
+
+// Close sends the current batch, makes the last delivery attempt and closes the sinks.
+// The undelivered batches are kept in the spool, if any.
+func (d *Dispatcher) Close() error {
+	d.lock.Lock()
+	if d.closed {
+		d.lock.Unlock()
+		return nil
+	}
+	d.closed = true
+	batch := d.batch
+	d.batch = nil
+	d.lock.Unlock()
+
+	d.dispatch(batch)
+	close(d.stop)
+	d.done.Wait()
+
+	var errs []error
+	for _, w := range d.workers {
+		if err := w.sink.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (d *Dispatcher) run() {
+	defer d.done.Done()
+
+	ticker := time.NewTicker(d.config.BatchInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			d.lock.Lock()
+			batch := d.batch
+			d.batch = nil
+			d.lock.Unlock()
+
+			d.dispatch(batch)
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(batch []*Report) {
+	if len(batch) == 0 {
+		return
+	}
+
+	for _, w := range d.workers {
+		if err := w.queue.push(batch); err != nil {
+			zap.L().Error("Failed to queue stats batch", zap.String("sink", w.sink.Name()), zap.Int("reports", len(batch)), zap.Error(err))
+			continue
+		}
+		w.wakeup()
+	}
+}
+
+type sinkWorker struct {
+	sink   Sink
+	queue  queue
+	config *DispatcherConfig
+	notify chan struct{}
+	stop   chan struct{}
+}
+
+func (w *sinkWorker) wakeup() {
+	select {
+	case w.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (w *sinkWorker) run() {
+	delay := w.config.RetryMin
+	for {
+		batch, ok, err := w.queue.peek()
+		if errors.Is(err, errBadBatch) {
+			zap.L().Error("Quarantining unreadable stats batch", zap.String("sink", w.sink.Name()), zap.Error(err))
+			err = w.queue.quarantine()
+			if err == nil {
+				continue
+			}
+		}
+		if err != nil {
+			zap.L().Warn("Failed to read stats batch", zap.String("sink", w.sink.Name()), zap.Duration("retry_in", delay), zap.Error(err))
+			if !w.backoff(&delay) {
+				return
+			}
+			continue
+		}
+
+		if !ok {
+			select {
+			case <-w.stop:
+				// the last batch may be dispatched along with the stop
+				w.drain()
+				return
+			case <-w.notify:
+				continue
+			}
+		}
+
+		if err := w.write(batch); err != nil {
+			zap.L().Warn("Failed to write stats batch", zap.String("sink", w.sink.Name()), zap.Duration("retry_in", delay), zap.Error(err))
+			if !w.backoff(&delay) {
+				w.drain()
+				return
+			}
+			continue
+		}
+
+		delay = w.config.RetryMin
+		w.queue.pop(batch)
+	}
+}
+
+// backoff waits for the delay and doubles it, false is returned on stop.
+func (w *sinkWorker) backoff(delay *time.Duration) bool {
+	select {
+	case <-w.stop:
+		return false
+	case <-time.After(*delay):
+	}
+	*delay = min(2*(*delay), w.config.RetryMax)
+	return true
+}
+
+// drain makes the single delivery attempt for the queued batches on shutdown.
+func (w *sinkWorker) drain() {
+	for {
+		batch, ok, err := w.queue.peek()
+		if err != nil || !ok {
+			return
+		}
+		if err := w.write(batch); err != nil {
+			if w.queue.durable() {
+				return
+			}
+			zap.L().Error("Dropping stats batch on shutdown", zap.String("sink", w.sink.Name()), zap.Int("reports", len(batch)), zap.Error(err))
+		}
+		w.queue.pop(batch)
+	}
+}
+
+func (w *sinkWorker) write(batch []*Report) error {
+	ctx, cancel := context.WithTimeout(context.Background(), w.config.WriteTimeout)
+	defer cancel()
+	return w.sink.Write(ctx, batch)
+}
+
+// queue is the FIFO of batches of a single sink.
+type queue interface {
+	push(batch []*Report) error
+	// peek returns the oldest batch, false if the queue is empty.
+	peek() ([]*Report, bool, error)
+	// pop removes the batch returned by peek.
+	pop(batch []*Report)
+	// quarantine moves aside the oldest batch that peek failed to decode.
+	quarantine() error
+	durable() bool
+}
+
+type memQueue struct {
+	lock    sync.Mutex
+	size    int
+	batches [][]*Report
+}
+
+func newMemQueue(size int) *memQueue {
+	return &memQueue{size: size}
+}
+
+func (q *memQueue) push(batch []*Report) error {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.batches) >= q.size {
+		zap.L().Error("Stats queue is full, dropping the oldest batch", zap.Int("reports", len(q.batches[0])))
+		q.batches = q.batches[1:]
+	}
+	q.batches = append(q.batches, batch)
+	return nil
+}
+
+func (q *memQueue) peek() ([]*Report, bool, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if len(q.batches) == 0 {
+		return nil, false, nil
+	}
+	return q.batches[0], true, nil
+}
+
+func (q *memQueue) pop(batch []*Report) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	// the batch may have been dropped by the overflow while being written
+	if len(q.batches) > 0 && len(batch) > 0 && &q.batches[0][0] == &batch[0] {
+		q.batches = q.batches[1:]
+	}
+}
+
+func (q *memQueue) quarantine() error {
+	// the batches in memory are always readable
+	return nil
+}
+
+func (q *memQueue) durable() bool {
+	return false
+}
@@ -0,0 +1,152 @@
+package xstats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const defaultFileMaxBytes = 100 << 20 // 100 Mb
+
+type FileSinkConfig struct {
+	Path string `yaml:"path"`
+	// MaxBytes rotates the file once it grows over the size.
+	MaxBytes int64 `yaml:"max_bytes"`
+	// MaxBackups is the number of rotated files kept, zero keeps all.
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// FileSink appends the reports to the newline-delimited JSON file.
+type FileSink struct {
+	config FileSinkConfig
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+}
+
+func NewFileSink(config FileSinkConfig) (*FileSink, error) {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = defaultFileMaxBytes
+	}
+
+	s := &FileSink{config: config}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileSink) Name() string {
+	return "file"
+}
+
+func (s *FileSink) Write(ctx context.Context, batch []*Report) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+
+	// The buffer is flushed as it fills, so the bytes are counted as they reach the file
+	cw := &countingWriter{w: s.file}
+	err := writeReports(cw, batch)
+	s.size += cw.n
+	if err != nil {
+		return err
+	}
+
+	if s.size >= s.config.MaxBytes {
+		// The batch is written already, the rotation is retried on the next write
+		if err := s.rotate(); err != nil {
+			zap.L().Error("Failed to rotate stats file", zap.String("path", s.config.Path), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *FileSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("stats file sink: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("stats file sink: %w", err)
+	}
+
+	s.file = f
+	s.size = info.Size()
+	return nil
+}
+
+func writeReports(w io.Writer, batch []*Report) error {
+	bw := bufio.NewWriter(w)
+	encoder := json.NewEncoder(bw)
+	for _, r := range batch {
+		if err := encoder.Encode(r); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// rotate renames the current file to path.<timestamp> and starts the new one.
+func (s *FileSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	backup := fmt.Sprintf("%s.%s", s.config.Path, time.Now().UTC().Format("20060102T150405.000000000"))
+	if err := os.Rename(s.config.Path, backup); err != nil {
+		return fmt.Errorf("stats file sink: %w", err)
+	}
+
+	if s.config.MaxBackups > 0 {
+		backups, _ := filepath.Glob(s.config.Path + ".*")
+		sort.Strings(backups)
+		for len(backups) > s.config.MaxBackups {
+			_ = os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+
+	return s.open()
+}
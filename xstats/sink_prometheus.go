@@ -0,0 +1,66 @@
+package xstats
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// PrometheusSink counts the traffic totals per country and platform,
+// register it with prometheus.MustRegister.
+type PrometheusSink struct {
+	bytes    *prometheus.CounterVec
+	sessions *prometheus.CounterVec
+}
+
+func NewPrometheusSink(namespace, subsystem string) *PrometheusSink {
+	return &PrometheusSink{
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "traffic_bytes_total",
+			Help:      "Session traffic partitioned by country, platform and direction",
+		}, []string{"country", "platform", "direction"}),
+		sessions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "reports_total",
+			Help:      "Number of session reports partitioned by country and platform",
+		}, []string{"country", "platform"}),
+	}
+}
+
+func (s *PrometheusSink) Name() string {
+	return "prometheus"
+}
+
+func (s *PrometheusSink) Write(ctx context.Context, batch []*Report) error {
+	for _, r := range batch {
+		country, platform := labelValue(r.Country), labelValue(r.Platform)
+		s.bytes.WithLabelValues(country, platform, "rx").Add(float64(r.DeltaRx))
+		s.bytes.WithLabelValues(country, platform, "tx").Add(float64(r.DeltaTx))
+		s.sessions.WithLabelValues(country, platform).Inc()
+	}
+	return nil
+}
+
+func (s *PrometheusSink) Close() error {
+	return nil
+}
+
+func (s *PrometheusSink) Describe(ch chan<- *prometheus.Desc) {
+	s.bytes.Describe(ch)
+	s.sessions.Describe(ch)
+}
+
+func (s *PrometheusSink) Collect(ch chan<- prometheus.Metric) {
+	s.bytes.Collect(ch)
+	s.sessions.Collect(ch)
+}
+
+func labelValue(v string) string {
+	if len(v) == 0 {
+		return "unknown"
+	}
+	return v
+}
@@ -0,0 +1,267 @@
+package xstats
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memSink struct {
+	name string
+
+	lock    sync.Mutex
+	fail    bool
+	reports []*Report
+	writes  int
+}
+
+func (s *memSink) Name() string {
+	return s.name
+}
+
+func (s *memSink) Write(ctx context.Context, batch []*Report) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.writes++
+	if s.fail {
+		return errors.New("sink is down")
+	}
+	s.reports = append(s.reports, batch...)
+	return nil
+}
+
+func (s *memSink) Close() error {
+	return nil
+}
+
+func (s *memSink) setFail(fail bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fail = fail
+}
+
+func (s *memSink) count() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return len(s.reports)
+}
+
+func newReport(id string) *Report {
+	return &Report{
+		Session: Session{
+			SessionID:   id,
+			SessionData: SessionData{Country: "de", Platform: "android"},
+		},
+		DeltaRx: 100,
+		DeltaTx: 10,
+	}
+}
+
+func TestDispatcher(t *testing.T) {
+	healthy := &memSink{name: "healthy"}
+	broken := &memSink{name: "broken", fail: true}
+
+	d, err := NewDispatcher(DispatcherConfig{
+		BatchSize:     2,
+		BatchInterval: time.Hour,
+		RetryMin:      10 * time.Millisecond,
+		RetryMax:      20 * time.Millisecond,
+	}, healthy, broken)
+	require.NoError(t, err)
+
+	for _, id := range []string{"a", "b", "c", "d"} {
+		d.OnFlush(newReport(id))
+	}
+
+	// the broken sink does not block the healthy one
+	assert.Eventually(t, func() bool { return healthy.count() == 4 }, time.Second, 5*time.Millisecond)
+	assert.Equal(t, 0, broken.count())
+
+	broken.setFail(false)
+	assert.Eventually(t, func() bool { return broken.count() == 4 }, time.Second, 5*time.Millisecond)
+
+	// the incomplete batch is sent on close
+	d.OnFlush(newReport("e"))
+	require.NoError(t, d.Close())
+	assert.Equal(t, 5, healthy.count())
+	assert.Equal(t, 5, broken.count())
+}
+
+// lateQueue gets the last batch along with the stop right after the worker found it empty.
+type lateQueue struct {
+	*memQueue
+	worker *sinkWorker
+	once   sync.Once
+}
+
+func (q *lateQueue) peek() ([]*Report, bool, error) {
+	batch, ok, err := q.memQueue.peek()
+	q.once.Do(func() {
+		_ = q.push([]*Report{newReport("a")})
+		q.worker.wakeup()
+		close(q.worker.stop)
+	})
+	return batch, ok, err
+}
+
+func TestSinkWorker_stop(t *testing.T) {
+	config := DispatcherConfig{RetryMin: time.Millisecond, RetryMax: time.Millisecond, WriteTimeout: time.Second}
+	for i := 0; i < 20; i++ {
+		sink := &memSink{name: "billing"}
+		q := &lateQueue{memQueue: newMemQueue(1)}
+		w := &sinkWorker{sink: sink, queue: q, config: &config, notify: make(chan struct{}, 1), stop: make(chan struct{})}
+		q.worker = w
+
+		// the worker sees the notify and the stop at once
+		w.run()
+		require.Equal(t, 1, sink.count())
+	}
+}
+
+func TestDispatcher_spool(t *testing.T) {
+	dir := t.TempDir()
+	sink := &memSink{name: "billing", fail: true}
+
+	d, err := NewDispatcher(DispatcherConfig{BatchSize: 1, SpoolDir: dir, RetryMin: time.Hour}, sink)
+	require.NoError(t, err)
+	d.OnFlush(newReport("a"))
+	d.OnFlush(newReport("b"))
+	require.NoError(t, d.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "billing", "*.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// the reports survive the restart and are delivered in order
+	sink = &memSink{name: "billing"}
+	d, err = NewDispatcher(DispatcherConfig{SpoolDir: dir}, sink)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return sink.count() == 2 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, d.Close())
+
+	assert.Equal(t, "a", sink.reports[0].SessionID)
+	assert.Equal(t, "b", sink.reports[1].SessionID)
+	assert.Equal(t, "android", sink.reports[1].Platform)
+	files, _ = filepath.Glob(filepath.Join(dir, "billing", "*.json"))
+	assert.Empty(t, files)
+}
+
+func TestDispatcher_spoolQuarantine(t *testing.T) {
+	dir := t.TempDir()
+	sink := &memSink{name: "billing"}
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "billing"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "billing", "00000000000000000001.json"), []byte("{broken"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "billing", "00000000000000000002.json"), []byte(`[{"SessionID":"a"}]`), 0o600))
+
+	d, err := NewDispatcher(DispatcherConfig{SpoolDir: dir}, sink)
+	require.NoError(t, err)
+	assert.Eventually(t, func() bool { return sink.count() == 1 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, d.Close())
+	assert.Equal(t, "a", sink.reports[0].SessionID)
+
+	// the undecodable batch is kept aside, not deleted
+	bad, _ := filepath.Glob(filepath.Join(dir, "billing", "*.bad"))
+	assert.Equal(t, []string{filepath.Join(dir, "billing", "00000000000000000001.json.bad")}, bad)
+	files, _ := filepath.Glob(filepath.Join(dir, "billing", "*.json"))
+	assert.Empty(t, files)
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.ndjson")
+	s, err := NewFileSink(FileSinkConfig{Path: path, MaxBytes: 200, MaxBackups: 1})
+	require.NoError(t, err)
+
+	require.NoError(t, s.Write(context.Background(), []*Report{newReport("a")}))
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	var r Report
+	require.NoError(t, json.Unmarshal(data, &r))
+	assert.Equal(t, "a", r.SessionID)
+
+	for i := 0; i < 10; i++ {
+		require.NoError(t, s.Write(context.Background(), []*Report{newReport("b"), newReport("c")}))
+	}
+	require.NoError(t, s.Close())
+
+	backups, err := filepath.Glob(path + ".*")
+	require.NoError(t, err)
+	assert.Len(t, backups, 1)
+
+	f, err := os.Open(backups[0])
+	require.NoError(t, err)
+	defer f.Close()
+	lines := 0
+	for scanner := bufio.NewScanner(f); scanner.Scan(); lines++ {
+		assert.True(t, strings.HasPrefix(scanner.Text(), "{"))
+	}
+	assert.Positive(t, lines)
+}
+
+func TestFileSink_size(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stats.ndjson")
+	s, err := NewFileSink(FileSinkConfig{Path: path})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// the batch is larger than the write buffer
+	batch := make([]*Report, 100)
+	for i := range batch {
+		batch[i] = newReport("a")
+	}
+	require.NoError(t, s.Write(context.Background(), batch))
+	require.NoError(t, s.Write(context.Background(), batch))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Greater(t, info.Size(), int64(4096))
+	assert.Equal(t, info.Size(), s.size)
+}
+
+func TestHTTPSink(t *testing.T) {
+	var received []*Report
+	status := http.StatusServiceUnavailable
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secret", r.Header.Get("X-Token"))
+		var batch []*Report
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&batch))
+		if status == http.StatusOK {
+			received = append(received, batch...)
+		}
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	s := NewHTTPSink(HTTPSinkConfig{URL: srv.URL, Headers: map[string]string{"X-Token": "secret"}}, nil)
+	batch := []*Report{newReport("a"), newReport("b")}
+	assert.Error(t, s.Write(context.Background(), batch))
+
+	status = http.StatusOK
+	require.NoError(t, s.Write(context.Background(), batch))
+	assert.Len(t, received, 2)
+}
+
+func TestPrometheusSink(t *testing.T) {
+	s := NewPrometheusSink("test", "stats")
+	r := newReport("a")
+	r.Platform = ""
+	require.NoError(t, s.Write(context.Background(), []*Report{newReport("a"), newReport("b"), r}))
+
+	assert.Equal(t, float64(200), testutil.ToFloat64(s.bytes.WithLabelValues("de", "android", "rx")))
+	assert.Equal(t, float64(20), testutil.ToFloat64(s.bytes.WithLabelValues("de", "android", "tx")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.sessions.WithLabelValues("de", "unknown")))
+}
@@ -0,0 +1,141 @@
+package xstats
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+const (
+	spoolExt    = ".json"
+	spoolBadExt = ".bad"
+)
+
+// errBadBatch is returned by peek for the batch that can't be decoded,
+// such a batch is quarantined instead of being retried.
+var errBadBatch = errors.New("bad stats batch")
+
+// spool is the durable queue keeping every batch in its own file
+// named by the sequence number, so the order survives restarts.
+type spool struct {
+	lock  sync.Mutex
+	dir   string
+	seq   uint64
+	files []string
+}
+
+func openSpool(root, name string) (*spool, error) {
+	dir := filepath.Join(root, name)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("stats spool: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("stats spool: %w", err)
+	}
+
+	s := &spool{dir: dir}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, spoolExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.files = append(s.files, name)
+		s.seq = max(s.seq, seq)
+	}
+	sort.Strings(s.files)
+	return s, nil
+}
+
+func (s *spool) push(batch []*Report) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.seq++
+	name := fmt.Sprintf("%020d%s", s.seq, spoolExt)
+	tmp := filepath.Join(s.dir, name+".tmp")
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, name)); err != nil {
+		return err
+	}
+
+	s.files = append(s.files, name)
+	return nil
+}
+
+func (s *spool) peek() ([]*Report, bool, error) {
+	s.lock.Lock()
+	if len(s.files) == 0 {
+		s.lock.Unlock()
+		return nil, false, nil
+	}
+	path := filepath.Join(s.dir, s.files[0])
+	s.lock.Unlock()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var batch []*Report
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, false, fmt.Errorf("%w %s: %w", errBadBatch, path, err)
+	}
+	return batch, true, nil
+}
+
+// pop removes the oldest file, the only reader is the sink worker,
+// so it is always the one returned by peek.
+func (s *spool) pop([]*Report) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.files) == 0 {
+		return
+	}
+	if err := os.Remove(filepath.Join(s.dir, s.files[0])); err != nil {
+		zap.L().Error("Failed to remove delivered stats batch, it's sent again on restart", zap.String("file", s.files[0]), zap.Error(err))
+	}
+	s.files = s.files[1:]
+}
+
+// quarantine renames the oldest file to <name>.bad, so it's kept for the inspection
+// but not picked up by openSpool.
+func (s *spool) quarantine() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if len(s.files) == 0 {
+		return nil
+	}
+	path := filepath.Join(s.dir, s.files[0])
+	if err := os.Rename(path, path+spoolBadExt); err != nil {
+		return err
+	}
+	s.files = s.files[1:]
+	return nil
+}
+
+func (s *spool) durable() bool {
+	return true
+}